@@ -1,21 +1,56 @@
 package main
 
 import (
+	"html/template"
+	"log"
+	"os"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/junaid9001/spectr_backend/config"
 	"github.com/junaid9001/spectr_backend/routes"
+	"github.com/junaid9001/spectr_backend/services"
+	"github.com/junaid9001/spectr_backend/storage"
 )
 
 func main() {
 
 	//database setups
 	config.LoadEnv()
+
+	//prices are kept with 2 decimals, the store currency has to have them
+	if _, err := services.NormalizeCurrency(os.Getenv("CURRENCY")); err != nil {
+		log.Fatal("CURRENCY ", err.Error())
+	}
+
 	config.ConnectDB()
 	config.MigrateAll()
 
+	//uploaded files, local disk or s3 (STORAGE_DRIVER)
+	if err := storage.Init(); err != nil {
+		log.Fatal("storage setup failed ", err.Error())
+	}
+
+	//release cart holds that ran out
+	services.StartReservationSweeper(time.Minute)
+
+	//autocomplete names, rebuilt on product changes and for new sales
+	services.StartSuggestIndex(config.DB, 10*time.Minute)
+
+	//refunds the payment provider didn't take yet
+	services.StartRefundRetryJob(config.DB, 5*time.Minute)
+
+	//related and bought together pairs from the catalog and order history
+	services.StartRecommendationJob(config.DB, time.Hour)
+
 	r := gin.Default()
+	r.SetFuncMap(template.FuncMap{"fileURL": storage.URL})
 	r.LoadHTMLGlob("templates/*")
-	r.Static("/uploads", "./uploads")
+
+	//local files are served by the app, s3 files by the bucket
+	if local, ok := storage.Default().(*storage.Local); ok {
+		r.Static("/uploads", local.Dir())
+	}
 
 	//register all routes
 	routes.RegisterRoutes(r)
@@ -5,24 +5,83 @@ import (
 	"log"
 
 	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
 )
 
 func MigrateAll() {
+	if err := convertMoneyColumns(); err != nil {
+		log.Fatal("money column conversion failed ", err.Error())
+		return
+	}
+
+	//wishlist uniqueness moved to user + product + variant
+	if DB.Migrator().HasIndex(&models.Wishlist{}, "idx_user_product") {
+		if err := DB.Migrator().DropIndex(&models.Wishlist{}, "idx_user_product"); err != nil {
+			log.Fatal("dropping old wishlist index failed ", err.Error())
+			return
+		}
+	}
+
+	//holds moved to user + product + variant, AutoMigrate doesn't touch an index that already exists
+	if DB.Migrator().HasIndex(&models.StockReservation{}, "idx_reservation_user_product") {
+		if err := DB.Migrator().DropIndex(&models.StockReservation{}, "idx_reservation_user_product"); err != nil {
+			log.Fatal("dropping old reservation index failed ", err.Error())
+			return
+		}
+	}
+
+	//holds are kept per cart owner (user or guest cart) + product + variant
+	if DB.Migrator().HasIndex(&models.StockReservation{}, "idx_reservation_user_product_variant") {
+		if err := DB.Migrator().DropIndex(&models.StockReservation{}, "idx_reservation_user_product_variant"); err != nil {
+			log.Fatal("dropping old reservation index failed ", err.Error())
+			return
+		}
+	}
+
+	//sold_count starts from the existing orders the first time it is added
+	backfillSold := DB.Migrator().HasTable(&models.Product{}) && !DB.Migrator().HasColumn(&models.Product{}, "sold_count")
+
 	err := DB.AutoMigrate(
 		&models.User{},
+		&models.Address{},
 		&models.Otp{},
 		&models.RefreshToken{},
 		&models.Product{},
+		&models.ProductVariant{},
+		&models.ProductImage{},
 		&models.CartItem{},
+		&models.GuestCart{},
+		&models.CartChange{},
 		&models.Wishlist{},
 		&models.Order{},
 		&models.OrderItem{},
+		&models.OrderStatusHistory{},
 		&models.Payment{},
+		&models.PaymentEvent{},
+		&models.Refund{},
+		&models.RefundItem{},
+		&models.ReturnRequest{},
+		&models.ReturnItem{},
 		&models.AppStats{},
 		&models.Category{},
 		&models.Filter{},
 		&models.FilterOption{},
 		&models.ProductFilterOption{},
+		&models.StockReservation{},
+		&models.Coupon{},
+		&models.CouponRedemption{},
+		&models.CartCoupon{},
+		&models.TaxRate{},
+		&models.ShippingRule{},
+		&models.ExchangeRate{},
+		&models.SearchQueryLog{},
+		&models.Review{},
+		&models.ReviewPhoto{},
+		&models.ReviewVote{},
+		&models.ProductQuestion{},
+		&models.ProductAnswer{},
+		&models.ProductPair{},
+		&models.ProductView{},
 	)
 
 	if err != nil {
@@ -30,5 +89,240 @@ func MigrateAll() {
 		return
 	}
 
+	if err := backfillProductImages(); err != nil {
+		log.Fatal("product image backfill failed ", err.Error())
+		return
+	}
+
+	if err := convertUploadPaths(); err != nil {
+		log.Fatal("upload path conversion failed ", err.Error())
+		return
+	}
+
+	//orders from before order numbers get SP + their zero padded id
+	if err := DB.Exec("UPDATE orders SET order_number = 'SP' || LPAD(id::text, 8, '0') WHERE order_number = ''").Error; err != nil {
+		log.Fatal("order number backfill failed ", err.Error())
+		return
+	}
+
+	if err := setupProductSearch(); err != nil {
+		log.Fatal("product search setup failed ", err.Error())
+		return
+	}
+
+	if backfillSold {
+		if err := backfillSoldCounts(); err != nil {
+			log.Fatal("sold count backfill failed ", err.Error())
+			return
+		}
+	}
+
 	fmt.Print("All models migrated")
 }
+
+// money columns that used to be decimal / float and are now bigint minor units
+var moneyColumns = map[string][]string{
+	"products":           {"price"},
+	"cart_items":         {"unit_price", "total_price"},
+	"orders":             {"subtotal", "discount_amount", "tax_amount", "shipping_fee", "total_amount"},
+	"order_items":        {"unit_price", "total_price", "discount_amount", "tax_amount"},
+	"payments":           {"amount", "refunded_amount"},
+	"refunds":            {"amount"},
+	"refund_items":       {"amount"},
+	"app_stats":          {"total_revenue"},
+	"coupons":            {"value", "max_discount", "min_cart_value"},
+	"coupon_redemptions": {"discount_amount"},
+	"shipping_rules":     {"amount", "per_kg", "threshold"},
+}
+
+// one time: multiply old decimal values by 100 and switch the column to bigint,
+// columns that are already integers (or don't exist yet) are skipped
+func convertMoneyColumns() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for table, columns := range moneyColumns {
+			for _, column := range columns {
+				var info struct {
+					DataType      string
+					ColumnDefault *string
+				}
+
+				err := tx.Raw(`SELECT data_type, column_default FROM information_schema.columns
+					WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?`, table, column).
+					Scan(&info).Error
+				if err != nil {
+					return err
+				}
+
+				switch info.DataType {
+				case "numeric", "double precision", "real":
+				default:
+					continue
+				}
+
+				if info.ColumnDefault != nil {
+					if err := tx.Exec(fmt.Sprintf(`ALTER TABLE %q ALTER COLUMN %q DROP DEFAULT`, table, column)).Error; err != nil {
+						return err
+					}
+				}
+
+				if err := tx.Exec(fmt.Sprintf(`ALTER TABLE %q ALTER COLUMN %q TYPE bigint USING ROUND(%q * 100)::bigint`,
+					table, column, column)).Error; err != nil {
+					return err
+				}
+
+				if info.ColumnDefault != nil {
+					if err := tx.Exec(fmt.Sprintf(`ALTER TABLE %q ALTER COLUMN %q SET DEFAULT 0`, table, column)).Error; err != nil {
+						return err
+					}
+				}
+
+				log.Printf("converted %s.%s to minor units", table, column)
+			}
+		}
+		return nil
+	})
+}
+
+// one time: single product images (image_url) and variant image lists (product_variants.images)
+// become gallery rows, old files were never resized so every size points at the original
+func backfillProductImages() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`INSERT INTO product_images
+			(product_id, original, thumbnail, medium, large, position, is_primary, created_at, updated_at)
+			SELECT p.id, p.image_url, p.image_url, p.image_url, p.image_url, 0, true, NOW(), NOW()
+			FROM products p
+			WHERE COALESCE(p.image_url, '') <> ''
+			AND NOT EXISTS (SELECT 1 FROM product_images i WHERE i.product_id = p.id)`).Error; err != nil {
+			return err
+		}
+
+		if !tx.Migrator().HasColumn(&models.ProductVariant{}, "images") {
+			return nil
+		}
+
+		if err := tx.Exec(`INSERT INTO product_images
+			(product_id, variant_id, original, thumbnail, medium, large, position, is_primary, created_at, updated_at)
+			SELECT v.product_id, v.id, img.path, img.path, img.path, img.path,
+				(SELECT COALESCE(MAX(i.position), -1) FROM product_images i WHERE i.product_id = v.product_id)
+					+ ROW_NUMBER() OVER (PARTITION BY v.product_id ORDER BY v.id, img.n),
+				false, NOW(), NOW()
+			FROM product_variants v
+			CROSS JOIN LATERAL jsonb_array_elements_text(
+				CASE WHEN jsonb_typeof(v.images) = 'array' THEN v.images ELSE '[]'::jsonb END
+			) WITH ORDINALITY AS img(path, n)`).Error; err != nil {
+			return err
+		}
+
+		return tx.Migrator().DropColumn(&models.ProductVariant{}, "images")
+	})
+}
+
+// columns that used to hold /uploads/... paths and now hold storage keys
+var uploadColumns = map[string][]string{
+	"products":        {"image_url"},
+	"product_images":  {"original", "thumbnail", "medium", "large"},
+	"return_requests": {"photo_url"},
+}
+
+// one time: "/uploads/x.jpg" becomes the key "x.jpg", keys are left alone
+func convertUploadPaths() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for table, columns := range uploadColumns {
+			for _, column := range columns {
+				if err := tx.Exec(fmt.Sprintf(`UPDATE %q SET %q = SUBSTRING(%q FROM 10) WHERE %q LIKE '/uploads/%%'`,
+					table, column, column, column)).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// full text search: products.search_vector holds name (A), brand and category names (B) and
+// description (C), kept up to date by triggers on products and categories, pg_trgm is used
+// for typo tolerant name / brand matching
+var productSearchSQL = []string{
+	`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+	`CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING GIN (search_vector)`,
+	`CREATE INDEX IF NOT EXISTS idx_products_name_trgm ON products USING GIN (name gin_trgm_ops)`,
+	`CREATE INDEX IF NOT EXISTS idx_products_brand_trgm ON products USING GIN (brand gin_trgm_ops)`,
+
+	//names of the category and all its parents
+	`CREATE OR REPLACE FUNCTION product_search_vector(p_name text, p_description text, p_brand text, p_category_id bigint)
+	RETURNS tsvector AS $$
+		SELECT setweight(to_tsvector('english', COALESCE(p_name, '')), 'A')
+			|| setweight(to_tsvector('english', COALESCE(p_brand, '')), 'B')
+			|| setweight(to_tsvector('english', COALESCE((
+				WITH RECURSIVE chain AS (
+					SELECT id, parent_id, category_name, 1 AS depth FROM categories WHERE id = p_category_id
+					UNION ALL
+					SELECT c.id, c.parent_id, c.category_name, chain.depth + 1
+					FROM categories c JOIN chain ON c.id = chain.parent_id
+					WHERE chain.depth < 20
+				)
+				SELECT string_agg(category_name, ' ') FROM chain
+			), '')), 'B')
+			|| setweight(to_tsvector('english', COALESCE(p_description, '')), 'C')
+	$$ LANGUAGE sql STABLE`,
+
+	`CREATE OR REPLACE FUNCTION products_search_vector_trigger() RETURNS trigger AS $$
+	BEGIN
+		NEW.search_vector := product_search_vector(NEW.name, NEW.description, NEW.brand, NEW.category_id);
+		RETURN NEW;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS trg_products_search_vector ON products`,
+	`CREATE TRIGGER trg_products_search_vector
+		BEFORE INSERT OR UPDATE OF name, description, brand, category_id ON products
+		FOR EACH ROW EXECUTE FUNCTION products_search_vector_trigger()`,
+
+	//renaming or moving a category changes the vectors of every product below it
+	`CREATE OR REPLACE FUNCTION categories_search_vector_trigger() RETURNS trigger AS $$
+	BEGIN
+		UPDATE products SET search_vector = product_search_vector(name, description, brand, category_id)
+		WHERE category_id IN (
+			WITH RECURSIVE tree AS (
+				SELECT id FROM categories WHERE id = NEW.id
+				UNION
+				SELECT c.id FROM categories c JOIN tree ON c.parent_id = tree.id
+			)
+			SELECT id FROM tree
+		);
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS trg_categories_search_vector ON categories`,
+	`CREATE TRIGGER trg_categories_search_vector
+		AFTER UPDATE OF category_name, parent_id ON categories
+		FOR EACH ROW EXECUTE FUNCTION categories_search_vector_trigger()`,
+
+	`UPDATE products SET search_vector = product_search_vector(name, description, brand, category_id)
+		WHERE search_vector IS NULL`,
+}
+
+func setupProductSearch() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range productSearchSQL {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// units sold per product from orders that were not cancelled or sent back, minus returned units
+func backfillSoldCounts() error {
+	return DB.Exec(`UPDATE products SET sold_count = sold.units
+		FROM (
+			SELECT oi.product_id, SUM(oi.quantity - oi.returned_quantity) AS units
+			FROM order_items oi
+			JOIN orders o ON o.id = oi.order_id
+			WHERE o.status NOT IN ?
+			GROUP BY oi.product_id
+		) sold
+		WHERE products.id = sold.product_id AND sold.units > 0`,
+		[]string{models.OrderStatusCancelled, models.OrderStatusReturned}).Error
+}
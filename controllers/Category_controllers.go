@@ -7,6 +7,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/services"
 	"github.com/junaid9001/spectr_backend/utils"
 	"gorm.io/gorm"
 )
@@ -18,8 +19,9 @@ func AddCategory(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		var input struct {
-			CategoryName string `json:"category_name" binding:"required"`
-			ParentID     *uint  `json:"parent_id"`
+			CategoryName     string `json:"category_name" binding:"required"`
+			ParentID         *uint  `json:"parent_id"`
+			ReturnWindowDays *int   `json:"return_window_days" binding:"omitempty,gte=0"`
 		}
 
 		if err := c.ShouldBindJSON(&input); err != nil {
@@ -49,8 +51,9 @@ func AddCategory(db *gorm.DB) gin.HandlerFunc {
 		}
 
 		category := models.Category{
-			CategoryName: input.CategoryName,
-			ParentID:     input.ParentID,
+			CategoryName:     input.CategoryName,
+			ParentID:         input.ParentID,
+			ReturnWindowDays: input.ReturnWindowDays,
 		}
 
 		result := db.Create(&category)
@@ -69,6 +72,8 @@ func AddCategory(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		services.RefreshSuggestIndex()
+
 		c.JSON(http.StatusOK,
 			gin.H{"status": "success", "message": "category " + input.CategoryName + " created", "category_id": category.ID})
 
@@ -102,6 +107,7 @@ func DeleteCategoryByID(db *gorm.DB) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
 			return
 		}
+		services.RefreshSuggestIndex()
 		c.JSON(http.StatusOK, gin.H{"status": "success", "message": "category deleted"})
 	}
 }
@@ -182,8 +188,9 @@ func EditCategoryByID(db *gorm.DB) gin.HandlerFunc {
 		}
 
 		var input struct {
-			CategoryName *string `json:"category_name"`
-			ParentID     *uint   `json:"parent_id."`
+			CategoryName     *string `json:"category_name"`
+			ParentID         *uint   `json:"parent_id."`
+			ReturnWindowDays *int    `json:"return_window_days" binding:"omitempty,gte=0"`
 		}
 
 		if err := c.ShouldBindJSON(&input); err != nil {
@@ -211,7 +218,12 @@ func EditCategoryByID(db *gorm.DB) gin.HandlerFunc {
 			Category.ParentID = input.ParentID
 		}
 
+		if input.ReturnWindowDays != nil {
+			Category.ReturnWindowDays = input.ReturnWindowDays
+		}
+
 		db.Save(&Category)
+		services.RefreshSuggestIndex()
 
 		c.JSON(http.StatusOK, gin.H{"status": "success", "data": Category})
 	}
@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/utils"
+	"gorm.io/gorm"
+)
+
+// body for create / update address
+type AddressInput struct {
+	Name       string `json:"name" binding:"required,max=50"`
+	Phone      string `json:"phone" binding:"required,max=20"`
+	Line1      string `json:"line1" binding:"required,max=255"`
+	Line2      string `json:"line2" binding:"max=255"`
+	City       string `json:"city" binding:"required,max=100"`
+	State      string `json:"state" binding:"required,max=100"`
+	PostalCode string `json:"postal_code" binding:"required,max=20"`
+	Country    string `json:"country" binding:"required,max=60"`
+	IsDefault  bool   `json:"is_default"`
+}
+
+func (in AddressInput) snapshot() models.AddressSnapshot {
+	return models.AddressSnapshot{
+		Name:       strings.TrimSpace(in.Name),
+		Phone:      strings.TrimSpace(in.Phone),
+		Line1:      strings.TrimSpace(in.Line1),
+		Line2:      strings.TrimSpace(in.Line2),
+		City:       strings.TrimSpace(in.City),
+		State:      strings.TrimSpace(in.State),
+		PostalCode: strings.TrimSpace(in.PostalCode),
+		Country:    strings.TrimSpace(in.Country),
+	}
+}
+
+// only one default address per user
+func makeDefaultAddress(tx *gorm.DB, userId, addressId uint) error {
+	if err := tx.Model(&models.Address{}).Where("user_id=? AND id <> ?", userId, addressId).
+		Update("is_default", false).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.Address{}).Where("user_id=? AND id=?", userId, addressId).
+		Update("is_default", true).Error
+}
+
+// add a saved address (user), the first one becomes the default
+func CreateAddress(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		var input AddressInput
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		address := models.Address{
+			UserID:          userId,
+			AddressSnapshot: input.snapshot(),
+		}
+
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			var count int64
+			if err := tx.Model(&models.Address{}).Where("user_id=?", userId).Count(&count).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Create(&address).Error; err != nil {
+				return err
+			}
+
+			if input.IsDefault || count == 0 {
+				address.IsDefault = true
+				return makeDefaultAddress(tx, userId, address.ID)
+			}
+			return nil
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"status": "success", "data": address})
+	}
+}
+
+//users saved addresses, default first
+
+func GetAddresses(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		var addresses []models.Address
+
+		if err := db.Where("user_id=?", userId).Order("is_default DESC, created_at DESC").Find(&addresses).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": addresses})
+	}
+}
+
+//update saved address by id, orders keep their own copy so they don't change
+
+func UpdateAddress(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		addressId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		var input AddressInput
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		var address models.Address
+
+		if err := db.Where("id=? AND user_id=?", addressId, userId).First(&address).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "address not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		address.AddressSnapshot = input.snapshot()
+
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Save(&address).Error; err != nil {
+				return err
+			}
+
+			if input.IsDefault && !address.IsDefault {
+				address.IsDefault = true
+				return makeDefaultAddress(tx, userId, address.ID)
+			}
+			return nil
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": address})
+	}
+}
+
+//mark a saved address as default
+
+func SetDefaultAddress(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		addressId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		var address models.Address
+
+		if err := db.Where("id=? AND user_id=?", addressId, userId).First(&address).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "address not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return makeDefaultAddress(tx, userId, address.ID)
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		address.IsDefault = true
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": address})
+	}
+}
+
+//delete saved address, the newest remaining one takes over as default
+
+func DeleteAddress(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		addressId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		var address models.Address
+
+		if err := db.Where("id=? AND user_id=?", addressId, userId).First(&address).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "address not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(&address).Error; err != nil {
+				return err
+			}
+
+			if !address.IsDefault {
+				return nil
+			}
+
+			var next models.Address
+			err := tx.Where("user_id=?", userId).Order("created_at DESC").First(&next).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			return makeDefaultAddress(tx, userId, next.ID)
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -52,6 +52,9 @@ func SignupHandler(c *gin.Context) {
 		return
 	}
 
+	//cart built as a guest is waiting once the account is verified
+	mergeGuestCart(c, config.DB, user.ID)
+
 	//generate otp also saves in db and sends email
 
 	if _, err := services.GenerateOtp(user.ID, user.Email, "signup"); err != nil {
@@ -144,6 +147,12 @@ func Login(c *gin.Context) {
 		false,
 	)
 
+	//products viewed before logging in join the users history
+	mergeViewerSession(c, config.DB, existingUser.ID)
+
+	//cart built as a guest joins the users cart
+	mergeGuestCart(c, config.DB, existingUser.ID)
+
 	c.JSON(http.StatusOK, gin.H{
 		"status":       "success",
 		"role":         existingUser.Role,
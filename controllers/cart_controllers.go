@@ -2,28 +2,94 @@ package controllers
 
 import (
 	"errors"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/services"
 	"github.com/junaid9001/spectr_backend/utils"
 	"gorm.io/gorm"
 )
 
+// signed id of a guest cart, see services.CartToken
+const cartTokenCookie = "cart_token"
+
+// cart of the logged-in user, or of the guest holding the cart_token cookie. create starts a guest
+// cart for a new visitor, otherwise a visitor without one gets 404
+func cartOwner(c *gin.Context, db *gorm.DB, create bool) (services.CartOwner, bool) {
+	if userId := optionalUserId(c); userId != 0 {
+		return services.UserCart(userId), true
+	}
+
+	if token, err := c.Cookie(cartTokenCookie); err == nil {
+		cart, err := services.FindGuestCart(db, token)
+		if err == nil {
+			return services.GuestCartOwner(cart.ID), true
+		}
+		if !errors.Is(err, services.ErrInvalidCartToken) {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return services.CartOwner{}, false
+		}
+	}
+
+	if !create {
+		c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "your cart is empty"})
+		return services.CartOwner{}, false
+	}
+
+	cart, err := services.CreateGuestCart(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+		return services.CartOwner{}, false
+	}
+
+	c.SetCookie(cartTokenCookie, services.CartToken(cart.ID), int(services.GuestCartTTL.Seconds()), "/", "", false, true)
+	return services.GuestCartOwner(cart.ID), true
+}
+
+// check every line against the catalog, returns the changes the shopper hasn't accepted yet
+func revalidateCart(c *gin.Context, db *gorm.DB, owner services.CartOwner) ([]models.CartChange, bool) {
+	if err := services.RevalidateCart(db, owner); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+		return nil, false
+	}
+
+	changes, err := services.PendingCartChanges(db, owner)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+		return nil, false
+	}
+	return changes, true
+}
+
+// lines of the guest cart move into the users cart, the cookie is cleared
+func mergeGuestCart(c *gin.Context, db *gorm.DB, userId uint) {
+	token, err := c.Cookie(cartTokenCookie)
+	if err != nil {
+		return
+	}
+
+	if guestCartId, err := services.ParseCartToken(token); err == nil {
+		if err := services.MergeGuestCart(db, guestCartId, userId); err != nil {
+			log.Println("merge guest cart:", err)
+			return
+		}
+	}
+	c.SetCookie(cartTokenCookie, "", -1, "/", "", false, true)
+}
+
 //done
 
-// add products to cart (user)
+// add products to cart (user, or guest with the cart_token cookie)
 func AddProductToCart(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
-		//get user id also safe accertion
-		userId, ok := utils.GetUserId(c)
-		if !ok {
-			return
-		}
 		var input struct {
-			ProductId uint `json:"product_id" binding:"required,gt=0"`
-			Quantity  int  `json:"quantity" binding:"required,gt=0"`
+			ProductId uint  `json:"product_id" binding:"required,gt=0"`
+			VariantID *uint `json:"variant_id"` //required when the product has variants
+			Quantity  int   `json:"quantity" binding:"required,gt=0"`
 		}
 
 		if err := c.ShouldBindJSON(&input); err != nil {
@@ -44,93 +110,192 @@ func AddProductToCart(db *gorm.DB) gin.HandlerFunc {
 
 		}
 
-		if product.StockQuantity < input.Quantity {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"status": "failed",
-				"error":  "not enough stock is available",
-			})
-			return
-		}
-
-		//check if product already exists /if then increase the quantity
-		var item models.CartItem
-		err := db.Where("product_id=? AND user_id=?", input.ProductId, userId).First(&item).Error
-
-		if err == nil {
-			item.Quantity = item.Quantity + input.Quantity
-			item.UnitPrice = product.Price
-			item.TotalPrice = float64(item.Quantity) * product.Price
-
-			if err := db.Save(&item).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "could not update cart item"})
+		variant, err := services.ResolveVariant(db, &product, input.VariantID)
+		if err != nil {
+			if errors.Is(err, services.ErrVariantRequired) || errors.Is(err, services.ErrVariantNotFound) {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
 				return
 			}
-
-			c.JSON(http.StatusCreated, gin.H{"status": "success"})
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
 			return
-
 		}
 
-		//if first time
+		//first line of a new guest starts their cart
+		owner, ok := cartOwner(c, db, true)
+		if !ok {
+			return
+		}
 
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+		var reservation *models.StockReservation
 
-			userCartItem := models.CartItem{
-				UserId:     userId,
-				ProductId:  product.ID,
-				Quantity:   input.Quantity,
-				UnitPrice:  product.Price,
-				TotalPrice: float64(input.Quantity) * product.Price,
-				Product:    product,
+		//hold the units together with the cart write so nobody else can take them
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			reservation, err = services.AddCartLine(tx, owner, &product, variant, input.Quantity)
+			if err != nil {
+				return err
 			}
+			return services.TouchGuestCart(tx, owner)
+		})
 
-			result := db.Create(&userCartItem)
-			if result.Error != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": result.Error.Error()})
+		if err != nil {
+			if errors.Is(err, services.ErrInsufficientStock) {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
 				return
 			}
 
-			c.JSON(http.StatusCreated, gin.H{"status": "success"})
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "could not update cart item"})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+
+		c.JSON(http.StatusCreated, gin.H{"status": "success", "reserved_until": reservation.ExpiresAt})
 
 	}
 }
 
-// user's cart
+// user's cart, or the guests one (cart_token cookie)
 
 func GetUserCart(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
-		//get user if also safe accertion
-		userId, ok := utils.GetUserId(c)
+		owner, ok := cartOwner(c, db, false)
 		if !ok {
 			return
 		}
+
+		//lines follow the current price and stock, what changed is listed until accepted at checkout
+		changes, ok := revalidateCart(c, db, owner)
+		if !ok {
+			return
+		}
+
+		//wishlist entries listed under the cart, accounts only
+		var savedForLater []models.Wishlist
+		if !owner.IsGuest() {
+			var err error
+			savedForLater, err = services.SavedForLater(db, owner.UserID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+				return
+			}
+		}
+
 		var products []models.CartItem
 
-		if err := db.Preload("Product").Where("user_id=?", userId).Find(&products).Error; err != nil {
+		if err := owner.Scope(db.Preload("Product").Preload("Variant")).Find(&products).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
 			return
 		}
 
 		if len(products) == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "your cart is empty"})
+			c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "your cart is empty", "changes": changes, "saved_for_later": savedForLater})
 			return
 		}
 
-		var subTotal float64
+		response := gin.H{
+			"status":          "success",
+			"count":           len(products),
+			"data":            products,
+			"changes":         changes,
+			"saved_for_later": savedForLater,
+		}
 
-		for _, prod := range products {
-			subTotal += prod.TotalPrice
+		if err := services.TouchGuestCart(db, owner); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
 		}
-		c.JSON(http.StatusOK, gin.H{
-			"status":   "success",
-			"count":    len(products),
-			"subtotal": subTotal,
-			"data":     products,
-		})
+
+		//coupons and saved addresses belong to accounts, guests get the plain price
+		var couponResult *services.CouponResult
+		var dest *models.AddressSnapshot
+
+		if !owner.IsGuest() {
+			//applied coupon is re-checked every time, the cart may have changed
+			coupon, err := services.CartCouponFor(db, owner.UserID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+				return
+			}
+
+			if coupon != nil {
+				couponResult, err = services.EvaluateCoupon(db, coupon, owner.UserID, products)
+				if err != nil {
+					response["coupon_error"] = err.Error()
+				} else {
+					response["coupon"] = couponResult
+				}
+			}
+
+			//?address_id= to price for another saved address, default address otherwise
+			dest, err = cartDestination(db, owner.UserID, c.Query("address_id"))
+			if err != nil {
+				if errors.Is(err, errInvalidAddressID) || errors.Is(err, errAddressNotFound) {
+					c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+				return
+			}
+		}
+
+		breakdown, err := services.PriceCart(db, products, couponResult, dest)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		//shown in the currency the client asked for
+		currency, rate, ok := requestCurrency(c, db)
+		if !ok {
+			return
+		}
+
+		unconverted := []uint{}
+		if currency != breakdown.Currency {
+			breakdown = services.ConvertBreakdown(breakdown, rate, currency)
+			localizer := services.NewProductLocalizer(db, currency)
+
+			//the line prices come from the breakdown, only the product details of a currency
+			//without a rate keep their own price, flagged like in the listing
+			localize := func(product *models.Product) bool {
+				err := localizer.Localize(product)
+				if err == nil {
+					return true
+				}
+				if errors.Is(err, services.ErrUnknownCurrency) {
+					unconverted = append(unconverted, product.ID)
+					return true
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+				return false
+			}
+
+			for i := range products {
+				products[i].UnitPrice = breakdown.Items[i].UnitPrice
+				products[i].TotalPrice = breakdown.Items[i].Subtotal
+
+				if !localize(&products[i].Product) {
+					return
+				}
+			}
+
+			for i := range savedForLater {
+				if !localize(&savedForLater[i].Product) {
+					return
+				}
+			}
+		}
+
+		response["subtotal"] = breakdown.Subtotal
+		response["discount"] = breakdown.Discount
+		response["tax"] = breakdown.Tax
+		response["shipping"] = breakdown.Shipping
+		response["total"] = breakdown.Total
+		response["currency"] = breakdown.Currency
+		response["pricing"] = breakdown
+		response["unconverted"] = unconverted //ids of products whose details keep their own currency
+
+		c.JSON(http.StatusOK, response)
 
 	}
 }
@@ -151,11 +316,6 @@ func UpdateQuantityInCartByID(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		userId, ok := utils.GetUserId(c)
-		if !ok {
-			return //responds is auto
-		}
-
 		var input struct {
 			Delta int `json:"delta" binding:"required,ne=0"`
 		}
@@ -165,9 +325,14 @@ func UpdateQuantityInCartByID(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		owner, ok := cartOwner(c, db, false)
+		if !ok {
+			return
+		}
+
 		usercartItem := models.CartItem{}
 
-		if err := db.Where("id=? AND user_id=?", cartItemId, userId).First(&usercartItem).Error; err != nil {
+		if err := owner.Scope(db).Where("id=?", cartItemId).First(&usercartItem).Error; err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
 				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": err.Error()})
 				return
@@ -198,21 +363,27 @@ func UpdateQuantityInCartByID(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		if product.StockQuantity < newQuantity {
-			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "not enough stock is available "})
-			return
+		var variant *models.ProductVariant
+		if usercartItem.VariantID != nil {
+			variant, err = services.ResolveVariant(db, &product, usercartItem.VariantID)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+				return
+			}
 		}
 
-		unitPrice := product.Price
-		totalPrice := product.Price * float64(newQuantity)
-
-		updates := map[string]interface{}{
-			"quantity":    newQuantity,
-			"unit_price":  unitPrice,
-			"total_price": totalPrice,
-		}
+		//resize the hold and the cart line together
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := services.SetCartLineQuantity(tx, owner, &usercartItem, &product, variant, newQuantity); err != nil {
+				return err
+			}
+			return services.TouchGuestCart(tx, owner)
+		}); err != nil {
+			if errors.Is(err, services.ErrInsufficientStock) {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+				return
+			}
 
-		if err := db.Model(&usercartItem).Updates(updates).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
 			return
 		}
@@ -237,25 +408,206 @@ func DeleteCartItemByID(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		owner, ok := cartOwner(c, db, false)
+		if !ok {
+			return
+		}
+
+		var item models.CartItem
+
+		if err := owner.Scope(db).Where("id=?", cartItemId).First(&item).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "cart item not found"})
+				return
+			}
+
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		//remove the line and give its held units back
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := services.RemoveCartLine(tx, owner, &item); err != nil {
+				return err
+			}
+			return services.TouchGuestCart(tx, owner)
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+
+	}
+}
+
+//start checkout, renews the hold on every cart line so stock is kept while paying
+
+func StartCheckout(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		//prices and stock are checked first so the holds match what is ordered
+		changes, ok := revalidateCart(c, db, services.UserCart(userId))
+		if !ok {
+			return
+		}
+
+		var cartItems []models.CartItem
+
+		if err := db.Where("user_id=?", userId).Find(&cartItems).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		if len(cartItems) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "cart is empty", "changes": changes})
+			return
+		}
+
+		//product id -> reason the hold could not be placed
+		unavailable := make(map[uint]string)
+		var reservedUntil time.Time
+
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			for _, item := range cartItems {
+				reservation, err := services.ReserveStock(tx, services.UserCart(userId), item.ProductId, services.VariantKey(item.VariantID), item.Quantity)
+				if err != nil {
+					if errors.Is(err, services.ErrInsufficientStock) || errors.Is(err, gorm.ErrRecordNotFound) {
+						unavailable[item.ProductId] = err.Error()
+						continue
+					}
+					return err
+				}
+				reservedUntil = reservation.ExpiresAt
+			}
+			return nil
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		if len(unavailable) > 0 {
+			c.JSON(http.StatusConflict, gin.H{"status": "failed", "error": "some items are no longer available", "unavailable": unavailable})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "reserved_until": reservedUntil, "changes": changes})
+	}
+}
+
+//apply a coupon code to the cart
+
+func ApplyCartCoupon(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
 		userId, ok := utils.GetUserId(c)
 		if !ok {
 			return
 		}
 
-		result := db.Where("user_id=? AND id=?", userId, cartItemId).Delete(&models.CartItem{})
+		var input struct {
+			Code string `json:"code" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		var cartItems []models.CartItem
+
+		if err := db.Preload("Product").Where("user_id=?", userId).Find(&cartItems).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		if len(cartItems) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "cart is empty"})
+			return
+		}
 
-		if result.Error != nil {
+		coupon, err := services.FindCoupon(db, input.Code)
+		if err != nil {
+			respondCouponError(c, err)
+			return
+		}
 
-			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": result.Error.Error()})
+		result, err := services.EvaluateCoupon(db, coupon, userId, cartItems)
+		if err != nil {
+			respondCouponError(c, err)
 			return
 		}
 
-		if result.RowsAffected == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "cart item not found"})
+		//one coupon per cart, a new one replaces the old
+		applied := models.CartCoupon{UserID: userId, CouponID: coupon.ID}
+		if err := db.Save(&applied).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": result})
+	}
+}
+
+//remove the coupon from the cart
+
+func RemoveCartCoupon(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		if err := db.Where("user_id=?", userId).Delete(&models.CartCoupon{}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
 			return
 		}
 
 		c.Status(http.StatusNoContent)
+	}
+}
 
+func respondCouponError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrCouponInvalid) || errors.Is(err, services.ErrCouponNotApplicable) ||
+		errors.Is(err, services.ErrCouponUsedUp) {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+		return
 	}
+	c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+}
+
+var (
+	errInvalidAddressID = errors.New("invalid address_id")
+	errAddressNotFound  = errors.New("address not found")
+)
+
+// address the cart is priced for, nil when the user has none saved
+func cartDestination(db *gorm.DB, userId uint, addressIdStr string) (*models.AddressSnapshot, error) {
+	var address models.Address
+
+	query := db.Where("user_id=?", userId)
+	if addressIdStr != "" {
+		addressId, err := utils.StringToUint(addressIdStr)
+		if err != nil {
+			return nil, errInvalidAddressID
+		}
+		query = query.Where("id=?", addressId)
+	} else {
+		query = query.Where("is_default = ?", true)
+	}
+
+	if err := query.First(&address).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if addressIdStr != "" {
+				return nil, errAddressNotFound
+			}
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &address.AddressSnapshot, nil
 }
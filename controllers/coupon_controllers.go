@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/utils"
+	"gorm.io/gorm"
+)
+
+// body for create / update coupon
+type CouponInput struct {
+	Code         string       `json:"code" binding:"required,max=40"`
+	Description  string       `json:"description"`
+	Type         string       `json:"type" binding:"required,oneof=percentage fixed free_shipping"`
+	Value        models.Money `json:"value" binding:"gte=0"`
+	MaxDiscount  models.Money `json:"max_discount" binding:"gte=0"`
+	MinCartValue models.Money `json:"min_cart_value" binding:"gte=0"`
+	UsageLimit   int          `json:"usage_limit" binding:"gte=0"`
+	PerUserLimit int          `json:"per_user_limit" binding:"gte=0"`
+	StartsAt     *time.Time   `json:"starts_at"`
+	EndsAt       *time.Time   `json:"ends_at"`
+	IsActive     *bool        `json:"is_active"`
+	CategoryID   *uint        `json:"category_id"`
+	ProductID    *uint        `json:"product_id"`
+	Brand        string       `json:"brand" binding:"max=30"`
+}
+
+func (in CouponInput) validate() string {
+	if in.Type == models.CouponTypePercentage && (in.Value <= 0 || in.Value > 100*100) {
+		return "percentage value must be between 0 and 100"
+	}
+	if in.Type == models.CouponTypeFixed && in.Value <= 0 {
+		return "fixed value must be greater than 0"
+	}
+	if in.StartsAt != nil && in.EndsAt != nil && !in.EndsAt.After(*in.StartsAt) {
+		return "ends_at must be after starts_at"
+	}
+	return ""
+}
+
+func (in CouponInput) apply(coupon *models.Coupon) {
+	coupon.Code = strings.ToUpper(strings.TrimSpace(in.Code))
+	coupon.Description = in.Description
+	coupon.Type = in.Type
+	coupon.Value = in.Value
+	coupon.MaxDiscount = in.MaxDiscount
+	coupon.MinCartValue = in.MinCartValue
+	coupon.UsageLimit = in.UsageLimit
+	coupon.PerUserLimit = in.PerUserLimit
+	coupon.StartsAt = in.StartsAt
+	coupon.EndsAt = in.EndsAt
+	coupon.CategoryID = in.CategoryID
+	coupon.ProductID = in.ProductID
+	coupon.Brand = strings.TrimSpace(in.Brand)
+
+	coupon.IsActive = true
+	if in.IsActive != nil {
+		coupon.IsActive = *in.IsActive
+	}
+}
+
+// create coupon (admin)
+func CreateCoupon(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input CouponInput
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		if msg := input.validate(); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": msg})
+			return
+		}
+
+		var coupon models.Coupon
+		input.apply(&coupon)
+
+		if err := db.Create(&coupon).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) || strings.Contains(err.Error(), "duplicate key") {
+				c.JSON(http.StatusConflict, gin.H{"status": "failed", "error": "coupon code already exists"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"status": "success", "data": coupon})
+	}
+}
+
+//all coupons (admin)
+
+func GetAllCoupons(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var coupons []models.Coupon
+
+		if err := db.Order("created_at DESC").Find(&coupons).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": coupons})
+	}
+}
+
+//update coupon by id (admin), used_count is kept
+
+func UpdateCoupon(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		couponId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		var input CouponInput
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		if msg := input.validate(); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": msg})
+			return
+		}
+
+		var coupon models.Coupon
+
+		if err := db.First(&coupon, couponId).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "coupon not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		input.apply(&coupon)
+
+		if err := db.Save(&coupon).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) || strings.Contains(err.Error(), "duplicate key") {
+				c.JSON(http.StatusConflict, gin.H{"status": "failed", "error": "coupon code already exists"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": coupon})
+	}
+}
+
+//delete coupon by id (admin), past redemptions stay
+
+func DeleteCoupon(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		couponId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			res := tx.Delete(&models.Coupon{}, couponId)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+
+			//take it off carts it was applied to
+			return tx.Where("coupon_id=?", couponId).Delete(&models.CartCoupon{}).Error
+		})
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "coupon not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/services"
+	"github.com/junaid9001/spectr_backend/utils"
+	"gorm.io/gorm"
+)
+
+// currency asked for by the client (X-Currency header or ?currency=) and the rate from
+// the store currency to it, writes a 400 and returns ok=false when it can't be used
+func requestCurrency(c *gin.Context, db *gorm.DB) (string, float64, bool) {
+	code := c.GetHeader("X-Currency")
+	if code == "" {
+		code = c.Query("currency")
+	}
+
+	currency, err := services.NormalizeCurrency(code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+		return "", 0, false
+	}
+
+	rate, err := services.ExchangeRateFor(db, models.DefaultCurrency(), currency)
+	if err != nil {
+		if errors.Is(err, services.ErrUnknownCurrency) {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return "", 0, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+		return "", 0, false
+	}
+
+	return currency, rate, true
+}
+
+//---------------- exchange rates (admin) ----------------
+
+// set the rate of a currency pair, body: {"base_currency":"INR","quote_currency":"USD","rate":0.012}
+func SaveExchangeRate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			BaseCurrency  string  `json:"base_currency" binding:"required"`
+			QuoteCurrency string  `json:"quote_currency" binding:"required"`
+			Rate          float64 `json:"rate" binding:"required,gt=0"`
+		}
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		base, err := services.NormalizeCurrency(input.BaseCurrency)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+		quote, err := services.NormalizeCurrency(input.QuoteCurrency)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+		if base == quote {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "base and quote currency are the same"})
+			return
+		}
+
+		rate := models.ExchangeRate{BaseCurrency: base, QuoteCurrency: quote, Rate: input.Rate}
+
+		if err := services.SaveExchangeRate(db, &rate); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": rate})
+	}
+}
+
+// upload a csv (form field "file") of base_currency,quote_currency,rate rows
+func ImportExchangeRates(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "csv file is required"})
+			return
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "could not read file"})
+			return
+		}
+		defer file.Close()
+
+		count, err := services.ImportExchangeRates(db, file)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "imported": count})
+	}
+}
+
+func GetExchangeRates(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rates []models.ExchangeRate
+
+		if err := db.Order("base_currency ASC, quote_currency ASC").Find(&rates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "base_currency": models.DefaultCurrency(), "data": rates})
+	}
+}
+
+func DeleteExchangeRate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rateId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		res := db.Delete(&models.ExchangeRate{}, rateId)
+		if res.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": res.Error.Error()})
+			return
+		}
+		if res.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "exchange rate not found"})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/junaid9001/spectr_backend/storage"
+)
+
+// serve a file of the local storage through a signed link (/files/<key>?expires=..&signature=..)
+// signed links of s3 point at the bucket itself and never reach this handler
+func ServeSignedFile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		local, ok := storage.Default().(*storage.Local)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "file not found"})
+			return
+		}
+
+		key := strings.TrimPrefix(c.Param("key"), "/")
+
+		if !local.VerifySignature(key, c.Query("expires"), c.Query("signature")) {
+			c.JSON(http.StatusForbidden, gin.H{"status": "failed", "error": "link is invalid or expired"})
+			return
+		}
+
+		file, err := local.Get(key)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "file not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+		defer file.Close()
+
+		contentType := mime.TypeByExtension(path.Ext(key))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		c.Header("Cache-Control", "private, no-store")
+		c.DataFromReader(http.StatusOK, -1, contentType, file, nil)
+	}
+}
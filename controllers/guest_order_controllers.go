@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/services"
+	"github.com/junaid9001/spectr_backend/utils"
+	"gorm.io/gorm"
+)
+
+// body for a guest order, the cart comes from the cart_token cookie
+type GuestOrderInput struct {
+	Email  string       `json:"email" binding:"required,email,max=255"`
+	ShipTo AddressInput `json:"ship_to" binding:"required"`
+
+	AcceptedChanges []uint `json:"accepted_changes"` //ids of the cart changes the client showed to the guest
+}
+
+// place an order from the guest cart without an account, the order number and a lookup token
+// are mailed to the guest (public)
+func PlaceGuestOrder(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input GuestOrderInput
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		owner, ok := cartOwner(c, db, false)
+		if !ok {
+			return
+		}
+
+		token, tokenHash, err := services.NewLookupToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		shipTo := input.ShipTo.snapshot()
+
+		order, ok := placeCartOrder(c, db, cartCheckout{
+			owner:           owner,
+			addressText:     shipTo.Format(),
+			shipTo:          shipTo,
+			dest:            &shipTo,
+			guestEmail:      strings.TrimSpace(input.Email),
+			lookupToken:     tokenHash,
+			acceptedChanges: input.AcceptedChanges,
+		})
+		if !ok {
+			return
+		}
+
+		services.SendGuestOrderEmail(order, token)
+
+		//the token is only shown here and in the email
+		c.JSON(http.StatusCreated, gin.H{"status": "success", "data": order, "lookup_token": token})
+	}
+}
+
+// order status for guests (public) ?token= or ?email=&order_number=
+func LookupOrder(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var order *models.Order
+		var err error
+
+		token := c.Query("token")
+		email, orderNumber := c.Query("email"), c.Query("order_number")
+
+		switch {
+		case token != "":
+			order, err = services.FindOrderByLookupToken(db, token)
+		case email != "" && orderNumber != "":
+			order, err = services.FindGuestOrder(db, email, orderNumber)
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "token or email and order_number are required"})
+			return
+		}
+
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "order not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": order})
+	}
+}
+
+// order of the lookup token in the body, responds 404 when there is none
+func guestOrderByToken(c *gin.Context, db *gorm.DB, token string) (*models.Order, bool) {
+	order, err := services.FindOrderByLookupToken(db, token)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "order not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+		return nil, false
+	}
+	return order, true
+}
+
+// pay a guest order (public) body: {"token": "", "method": ""}
+func CreateGuestPayment(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Token  string `json:"token" binding:"required"`
+			Method string `json:"method"`
+		}
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		order, ok := guestOrderByToken(c, db, input.Token)
+		if !ok {
+			return
+		}
+
+		startOrderPayment(c, db, order, input.Method)
+	}
+}
+
+// confirm a payment of a guest order (public) body: {"token": ""}
+func ConfirmGuestPayment(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		paymentId, err := utils.StringToUint(c.Param("payment_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		var input struct {
+			Token string `json:"token" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		order, ok := guestOrderByToken(c, db, input.Token)
+		if !ok {
+			return
+		}
+
+		var payment models.Payment
+
+		if err := db.Where("id=? AND order_id=?", paymentId, order.ID).First(&payment).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "payment not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		confirmOrderPayment(c, db, &payment)
+	}
+}
+
+// move a guest order into the users account (user) body: {"order_number": "", "token": ""}
+// the token is only needed when the account has another email than the order
+func ClaimGuestOrder(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		var input struct {
+			OrderNumber string `json:"order_number" binding:"required"`
+			Token       string `json:"token"`
+		}
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		order, err := services.ClaimGuestOrder(db, userId, input.OrderNumber, input.Token)
+		if err != nil {
+			switch {
+			case errors.Is(err, services.ErrOrderNotClaimable):
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": err.Error()})
+			case errors.Is(err, services.ErrClaimNotAllowed):
+				c.JSON(http.StatusForbidden, gin.H{"status": "failed", "error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": order})
+	}
+}
@@ -3,11 +3,14 @@ package controllers
 import (
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/services"
 	"github.com/junaid9001/spectr_backend/utils"
 	"gorm.io/gorm"
 )
@@ -19,7 +22,9 @@ import (
 func PlaceOrder(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var input struct {
-			ShippingAddress string `json:"shipping_address" binding:"required"`
+			AddressID       *uint  `json:"address_id"`
+			ShippingAddress string `json:"shipping_address"` //free text, only when no saved address is used
+			AcceptedChanges []uint `json:"accepted_changes"` //ids of the cart changes the client showed to the user
 		}
 
 		if err := c.ShouldBindJSON(&input); err != nil {
@@ -32,101 +37,286 @@ func PlaceOrder(db *gorm.DB) gin.HandlerFunc {
 			return //response are already send
 		}
 
-		var UserCartItems []models.CartItem
+		//copy of the address kept on the order so later edits don't change it
+		var shipTo models.AddressSnapshot
+		addressText := strings.TrimSpace(input.ShippingAddress)
 
-		if err := db.Where("user_id=?", userId).Preload("Product").Find(&UserCartItems).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "failed to read cart"})
+		if input.AddressID != nil {
+			var address models.Address
+
+			if err := db.Where("id=? AND user_id=?", *input.AddressID, userId).First(&address).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "address not found"})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+				return
+			}
+
+			shipTo = address.AddressSnapshot
+			addressText = shipTo.Format()
+		}
+
+		if addressText == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "address_id or shipping_address is required"})
 			return
 		}
 
-		if len(UserCartItems) == 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "cart is empty"})
+		//a free text address can't be matched against region rules, it is only taken when the
+		//price is the same everywhere, so the order costs what the cart showed
+		var dest *models.AddressSnapshot
+		if input.AddressID != nil {
+			dest = &shipTo
+		} else {
+			regional, err := services.HasDestinationRules(db)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+				return
+			}
+			if regional {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": services.ErrAddressRequired.Error()})
+				return
+			}
+		}
+
+		createdOrder, ok := placeCartOrder(c, db, cartCheckout{
+			owner:           services.UserCart(userId),
+			addressText:     addressText,
+			addressID:       input.AddressID,
+			shipTo:          shipTo,
+			dest:            dest,
+			acceptedChanges: input.AcceptedChanges,
+		})
+		if !ok {
 			return
 		}
 
-		var total float64 = 0
+		c.JSON(http.StatusCreated, gin.H{"status": "success", "data": createdOrder})
+
+	}
+}
 
-		orderItems := make([]models.OrderItem, 0, len(UserCartItems))
+// what PlaceOrder and PlaceGuestOrder settle before the cart becomes an order
+type cartCheckout struct {
+	owner       services.CartOwner
+	addressText string
+	addressID   *uint
+	shipTo      models.AddressSnapshot
+	dest        *models.AddressSnapshot //priced for, nil = no destination rules
+	guestEmail  string
+	lookupToken string //hash, guest orders only
+
+	acceptedChanges []uint //ids of the pending cart changes the shopper saw
+}
 
-		//orderItems
-		for _, val := range UserCartItems {
-			unitPrice := val.UnitPrice
-			tl := unitPrice * float64(val.Quantity)
+// turn the owners cart into an order: price it, commit the held stock and empty the cart.
+// responds itself when it fails
+func placeCartOrder(c *gin.Context, db *gorm.DB, checkout cartCheckout) (*models.Order, bool) {
+	owner := checkout.owner
+	userId := owner.UserID
+
+	//prices, stock and removed products are checked again, nothing is ordered on changes the
+	//shopper hasn't seen
+	changes, ok := revalidateCart(c, db, owner)
+	if !ok {
+		return nil, false
+	}
 
-			total += tl
+	if !services.CartChangesAccepted(changes, checkout.acceptedChanges) {
+		c.JSON(http.StatusConflict, gin.H{"status": "failed", "error": services.ErrCartChanged.Error(), "changes": changes})
+		return nil, false
+	}
 
-			orderItems = append(orderItems, models.OrderItem{
-				ProductID:  val.ProductId,
-				UnitPrice:  unitPrice,
-				Quantity:   val.Quantity,
-				TotalPrice: tl,
-			})
-		}
+	var cartItems []models.CartItem
 
-		var createdOrder models.Order
+	if err := owner.Scope(db).Preload("Product").Preload("Variant").Find(&cartItems).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "failed to read cart"})
+		return nil, false
+	}
 
-		//start transaction
+	if len(cartItems) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "cart is empty"})
+		return nil, false
+	}
 
-		err := db.Transaction(func(tx *gorm.DB) error {
-			// temporarily enable debug logging (prints SQL to stdout)
-			tx = tx.Debug()
+	//coupon applied to the cart, checked again against what is being ordered (accounts only)
+	var coupon *models.Coupon
+	var couponResult *services.CouponResult
 
-			order := models.Order{
-				UserID:      userId,
-				TotalAmount: total,
-				Address:     input.ShippingAddress,
-				Status:      "pending",
-				CreatedAt:   time.Now(),
-			}
+	if !owner.IsGuest() {
+		var err error
+		coupon, err = services.CartCouponFor(db, userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "failed to read cart"})
+			return nil, false
+		}
 
-			if err := tx.Create(&order).Error; err != nil {
-				return err
+		if coupon != nil {
+			couponResult, err = services.EvaluateCoupon(db, coupon, userId, cartItems)
+			if err != nil {
+				respondCouponError(c, err)
+				return nil, false
 			}
+		}
+	}
 
-			//orderid for each order item
-			for i := range orderItems {
-				orderItems[i].OrderID = order.ID
-			}
+	breakdown, err := services.PriceCart(db, cartItems, couponResult, checkout.dest)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+		return nil, false
+	}
+
+	//the order is charged in the requested currency at todays rate, kept on the order
+	currency, rate, ok := requestCurrency(c, db)
+	if !ok {
+		return nil, false
+	}
+
+	baseCurrency := breakdown.Currency
+	couponDiscount := breakdown.Discount //coupons count in the store currency
+	if currency != baseCurrency {
+		breakdown = services.ConvertBreakdown(breakdown, rate, currency)
+	}
+
+	orderItems := make([]models.OrderItem, 0, len(cartItems))
+
+	//orderItems
+	for i, val := range cartItems {
+		line := breakdown.Items[i]
+
+		sku := ""
+		if val.Variant != nil {
+			sku = val.Variant.SKU
+		}
+
+		orderItems = append(orderItems, models.OrderItem{
+			ProductID:      val.ProductId,
+			VariantID:      val.VariantID,
+			SKU:            sku,
+			UnitPrice:      line.UnitPrice,
+			Quantity:       val.Quantity,
+			TotalPrice:     line.Subtotal,
+			DiscountAmount: line.Discount,
+			TaxAmount:      line.Tax,
+		})
+	}
+
+	couponCode := ""
+	if couponResult != nil {
+		couponCode = coupon.Code
+	}
+
+	orderNumber, err := services.NewOrderNumber()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+		return nil, false
+	}
+
+	actor := services.OrderActor{UserID: userId, Role: "user"}
+	if owner.IsGuest() {
+		actor = services.OrderActor{Role: "guest"}
+	}
+
+	var createdOrder models.Order
+
+	//start transaction
 
-			if err := tx.Create(&orderItems).Error; err != nil {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// temporarily enable debug logging (prints SQL to stdout)
+		tx = tx.Debug()
+
+		order := models.Order{
+			UserID:         userId,
+			OrderNumber:    orderNumber,
+			GuestEmail:     checkout.guestEmail,
+			LookupToken:    checkout.lookupToken,
+			Subtotal:       breakdown.Subtotal,
+			DiscountAmount: breakdown.Discount,
+			CouponCode:     couponCode,
+			FreeShipping:   breakdown.FreeShipping,
+			TaxAmount:      breakdown.Tax,
+			ShippingFee:    breakdown.Shipping,
+			TotalAmount:    breakdown.Total,
+			Currency:       currency,
+			BaseCurrency:   baseCurrency,
+			ExchangeRate:   rate,
+			Address:        checkout.addressText,
+			AddressID:      checkout.addressID,
+			ShipTo:         checkout.shipTo,
+			Status:         models.OrderStatusPending,
+			CreatedAt:      time.Now(),
+		}
+
+		if err := tx.Create(&order).Error; err != nil {
+			return err
+		}
+
+		if err := services.RecordOrderStatus(tx, order.ID, "", models.OrderStatusPending, actor, "order placed"); err != nil {
+			return err
+		}
+
+		if couponResult != nil {
+			if err := services.RedeemCoupon(tx, coupon, userId, order.ID, couponDiscount); err != nil {
 				return err
 			}
+		}
 
-			//decrement stock of all product
-			for _, val := range orderItems {
-				res := tx.Model(&models.Product{}).Where("id=? AND stock_quantity >=?", val.ProductID, val.Quantity).
-					UpdateColumn("stock_quantity", gorm.Expr("stock_quantity - ?", val.Quantity))
-				if res.Error != nil {
-					return res.Error
-				}
-				if res.RowsAffected == 0 {
+		//orderid for each order item
+		for i := range orderItems {
+			orderItems[i].OrderID = order.ID
+		}
+
+		if err := tx.Create(&orderItems).Error; err != nil {
+			return err
+		}
+
+		//convert the owners holds into sold stock
+		for _, val := range orderItems {
+			if err := services.CommitReservedStock(tx, owner, val.ProductID, services.VariantKey(val.VariantID), val.Quantity); err != nil {
+				if errors.Is(err, services.ErrInsufficientStock) || errors.Is(err, gorm.ErrRecordNotFound) {
 					return fmt.Errorf("product %d does not have enough stock", val.ProductID)
 				}
+				return err
 			}
-
-			if err := tx.Where("user_id=?", userId).Delete(&models.CartItem{}).Error; err != nil {
+			if err := services.AdjustSoldCount(tx, val.ProductID, val.Quantity); err != nil {
 				return err
 			}
-			createdOrder = order
-			return nil
+		}
 
-		})
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
-			return
+		if err := services.ReleaseCartReservations(tx, owner); err != nil {
+			return err
+		}
 
+		if err := owner.Scope(tx).Delete(&models.CartItem{}).Error; err != nil {
+			return err
 		}
 
-		//get order items for return
+		//checked again in the order transaction, a change found by a concurrent revalidation wasn't seen either
+		if err := services.AcceptCartChanges(tx, owner, checkout.acceptedChanges); err != nil {
+			return err
+		}
+		createdOrder = order
+		return nil
 
-		if err := db.Preload("OrderItems").First(&createdOrder, createdOrder.ID).Error; err != nil {
-			c.JSON(http.StatusCreated, gin.H{"status": "success", "data": createdOrder.ID})
-			return
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrCartChanged) {
+			changes, _ := services.PendingCartChanges(db, owner)
+			c.JSON(http.StatusConflict, gin.H{"status": "failed", "error": err.Error(), "changes": changes})
+			return nil, false
 		}
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+		return nil, false
 
-		c.JSON(http.StatusCreated, gin.H{"status": "success", "data": createdOrder})
+	}
 
+	//get order items for return
+
+	if err := db.Preload("OrderItems").First(&createdOrder, createdOrder.ID).Error; err != nil {
+		log.Println("reload placed order:", err)
 	}
+
+	return &createdOrder, true
 }
 
 //get users order history (user)
@@ -174,7 +364,8 @@ func GetDetailsOfOrder(db *gorm.DB) gin.HandlerFunc {
 		}
 		var order models.Order
 
-		if err := db.Where("id=? AND user_id=?", orderId, userId).First(&order).Error; err != nil {
+		if err := db.Preload("OrderItems").Preload("StatusHistory").
+			Where("id=? AND user_id=?", orderId, userId).First(&order).Error; err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
 				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "order not found"})
 				return
@@ -215,9 +406,9 @@ func DeleteOrderById(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		//only if cancelled or delivered
-		if order.Status == "pending" {
-			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "cannot delete a pending order"})
+		//only once the order reached an end state (delivered, cancelled, returned)
+		if !services.IsFinalOrderStatus(order.Status) && order.Status != models.OrderStatusDelivered {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "cannot delete an order that is still in progress"})
 			return
 		}
 
@@ -248,9 +439,15 @@ func CancelOrderAndRestock(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		var input struct {
+			Reason string `json:"reason"`
+		}
+		//reason is optional, empty body is fine
+		_ = c.ShouldBindJSON(&input)
+
 		var order models.Order
 
-		if err := db.Preload("OrderItems").Where("id=? AND user_id=?", orderId, userId).First(&order).Error; err != nil {
+		if err := db.Where("id=? AND user_id=?", orderId, userId).First(&order).Error; err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
 				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "order not found"})
 				return
@@ -259,35 +456,31 @@ func CancelOrderAndRestock(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		if order.Status != "pending" {
-			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "only pending orders can be cancelled"})
+		//restock and refund are side effects of the transition
+		updated, err := services.ChangeOrderStatus(db, order.ID, models.OrderStatusCancelled,
+			services.OrderActor{UserID: userId, Role: "user"}, input.Reason)
+		if err != nil {
+			respondOrderTransitionError(c, err)
 			return
 		}
 
-		if err := db.Transaction(func(tx *gorm.DB) error {
-
-			if err := tx.Model(&order).Update("status", "cancelled").Error; err != nil {
-				return err
-			}
-
-			//restock each product
-			for _, val := range order.OrderItems {
-				res := tx.Model(&models.Product{}).Where("id=?", val.ProductID).
-					UpdateColumn("stock_quantity", gorm.Expr("stock_quantity + ?", val.Quantity))
-
-				if res.Error != nil {
-					return res.Error
-				}
-			}
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": updated})
+	}
+}
 
-			return nil
-		}); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
-			return
-		}
+// maps state machine errors to http responses
+func respondOrderTransitionError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrInvalidTransition) {
+		c.JSON(http.StatusConflict, gin.H{"status": "failed", "error": err.Error()})
+		return
+	}
 
-		c.JSON(http.StatusOK, gin.H{"status": "success"})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "order not found"})
+		return
 	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
 }
 
 //---------------**---------------------
@@ -329,8 +522,14 @@ func UpdateOrderStatus(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		adminId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
 		var input struct {
 			Status string `json:"status" binding:"required"`
+			Note   string `json:"note"`
 		}
 
 		if err := c.ShouldBindJSON(&input); err != nil {
@@ -338,74 +537,39 @@ func UpdateOrderStatus(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		statusDelivered := "delivered"
-		statusCancelled := "cancelled"
-
-		var order models.Order
-
-		if err := db.Preload("OrderItems").First(&order, orderId).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "order not found"})
-				return
-			}
-			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
-			return
-		}
-		//validate request
-
-		if input.Status != statusDelivered && input.Status != statusCancelled {
-			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid status"})
-			return
-		}
-
-		//already same?
-		if order.Status == input.Status {
-			c.JSON(http.StatusOK, gin.H{"status": "success", "data": order})
+		order, err := services.ChangeOrderStatus(db, orderId, input.Status,
+			services.OrderActor{UserID: adminId, Role: "admin"}, input.Note)
+		if err != nil {
+			respondOrderTransitionError(c, err)
 			return
 		}
 
-		if input.Status == "cancelled" && order.Status == "pending" {
-			if err := db.Transaction(func(tx *gorm.DB) error {
-
-				if err := tx.Model(&order).Update("status", "cancelled").Error; err != nil {
-					return err
-				}
-
-				//restock each product
-				for _, val := range order.OrderItems {
-					res := tx.Model(&models.Product{}).Where("id=?", val.ProductID).
-						UpdateColumn("stock_quantity", gorm.Expr("stock_quantity + ?", val.Quantity))
-
-					if res.Error != nil {
-						return res.Error
-					}
-				}
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": order, "next_statuses": services.NextOrderStatuses(order.Status)})
+	}
+}
 
-				return nil
+//status history of an order (admin)
 
-			}); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
-				return
-			}
-			db.First(&order, orderId)
-			c.JSON(http.StatusOK, gin.H{"status": "success", "data": order})
+func GetOrderStatusHistory(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
 			return
 		}
 
-		result := db.Model(&order).Update("status", input.Status)
+		var history []models.OrderStatusHistory
 
-		if result.Error != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": result.Error.Error()})
+		if err := db.Where("order_id=?", orderId).Order("created_at ASC").Find(&history).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
 			return
 		}
 
-		if result.RowsAffected == 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "order status was not updated"})
+		if len(history) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "order not found"})
 			return
 		}
 
-		db.First(&order, orderId)
-
-		c.JSON(http.StatusOK, gin.H{"status": "success", "data": order})
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": history})
 	}
 }
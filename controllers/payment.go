@@ -1,14 +1,21 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/services"
 	"github.com/junaid9001/spectr_backend/utils"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var errPaymentInProgress = errors.New("a payment of this order is being processed, wait for it to settle")
+
 // create a payment portal by orderId
 func CreatePayment(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -24,6 +31,12 @@ func CreatePayment(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		var input struct {
+			Method string `json:"method"`
+		}
+		//method is optional, provider picks its default
+		_ = c.ShouldBindJSON(&input)
+
 		var Order models.Order
 
 		if err := db.Where("id=? AND user_id=?", orderId, userId).First(&Order).Error; err != nil {
@@ -36,26 +49,93 @@ func CreatePayment(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		if Order.PaymentStatus != "pending" {
-			c.JSON(400, gin.H{"error": "order not pending payment"})
-			return
+		startOrderPayment(c, db, &Order, input.Method)
+	}
+}
+
+// open a payment with the provider for the whole order, shared by users and guests.
+// an order has one open payment at a time: a pending one is voided and replaced, a processing
+// one has to settle first
+func startOrderPayment(c *gin.Context, db *gorm.DB, order *models.Order, method string) {
+	provider, err := services.GetPaymentProvider("")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+		return
+	}
+
+	var payment models.Payment
+	var intent *services.PaymentIntent
+	var replaced []models.Payment
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		//locked so two requests can't open payments side by side
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(order, order.ID).Error; err != nil {
+			return err
 		}
 
-		amount := Order.TotalAmount
+		if err := services.CheckOrderPayable(order); err != nil {
+			return err
+		}
 
-		payment := models.Payment{
-			OrderID:       orderId,
-			Amount:        amount,
-			PaymentStatus: "pending",
+		var open models.Payment
+		err := tx.Where("order_id=? AND payment_status = ?", order.ID, services.PaymentStatusProcessing).First(&open).Error
+		if err == nil {
+			return errPaymentInProgress
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
 		}
 
-		if err := db.Create(&payment).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
-			return
+		if err := tx.Where("order_id=? AND payment_status = ?", order.ID, services.PaymentStatusPending).
+			Find(&replaced).Error; err != nil {
+			return err
+		}
+		for _, old := range replaced {
+			if err := services.FailPayment(tx, old.ID, "replaced by a new payment"); err != nil {
+				return err
+			}
 		}
 
-		c.JSON(http.StatusOK, gin.H{"payment_id": payment.ID, "amount": amount})
+		intent, err = provider.CreateIntent(order.TotalAmount, order.Currency, fmt.Sprintf("order_%d", order.ID), method)
+		if err != nil {
+			return err
+		}
+
+		payment = models.Payment{
+			OrderID:       order.ID,
+			Amount:        order.TotalAmount,
+			Currency:      order.Currency,
+			PaymentStatus: services.PaymentStatusPending,
+			Provider:      provider.Name(),
+			ProviderRef:   intent.ProviderRef,
+			Method:        method,
+		}
+		return tx.Create(&payment).Error
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrOrderAlreadyPaid) || errors.Is(err, services.ErrOrderNotPayable) ||
+			errors.Is(err, errPaymentInProgress):
+			c.JSON(http.StatusConflict, gin.H{"status": "failed", "error": err.Error()})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+		}
+		return
+	}
+
+	//the replaced intents are called off at the provider, one that was paid anyway is refunded
+	//when its success event arrives
+	for _, old := range replaced {
+		cancelPaymentIntent(&old)
 	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"payment_id":    payment.ID,
+		"amount":        payment.Amount,
+		"currency":      payment.Currency,
+		"provider":      payment.Provider,
+		"client_secret": intent.ClientSecret,
+	})
 }
 
 // confirm payment by payment id
@@ -67,9 +147,17 @@ func ConfirmPayment(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
 		var payment models.Payment
 
-		if err := db.First(&payment, paymentId).Error; err != nil {
+		//only the owner of the order may confirm its payment
+		if err := db.Joins("JOIN orders ON orders.id = payments.order_id").
+			Where("payments.id=? AND orders.user_id=?", paymentId, userId).
+			First(&payment).Error; err != nil {
 			if err == gorm.ErrRecordNotFound {
 				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": err.Error()})
 				return
@@ -79,59 +167,103 @@ func ConfirmPayment(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		if payment.PaymentStatus != "pending" {
-			c.JSON(200, gin.H{"error": "payment already completed"})
-			return
-		}
+		confirmOrderPayment(c, db, &payment)
+	}
+}
 
-		orderId := payment.OrderID
-		var order models.Order
+// capture a payment with the provider and settle the order, shared by users and guests
+func confirmOrderPayment(c *gin.Context, db *gorm.DB, payment *models.Payment) {
+	if payment.PaymentStatus == services.PaymentStatusCompleted {
+		c.JSON(200, gin.H{"error": "payment already completed"})
+		return
+	}
 
-		if err := db.Preload("OrderItems").First(&order, orderId).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": err.Error()})
-				return
-			}
+	if payment.PaymentStatus == services.PaymentStatusFailed {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "payment failed, create a new payment"})
+		return
+	}
+
+	//nothing is captured for an order that was paid or closed meanwhile
+	var order models.Order
+	if err := db.First(&order, payment.OrderID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+		return
+	}
+	if err := services.CheckOrderPayable(&order); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"status": "failed", "error": err.Error()})
+		return
+	}
+
+	provider, err := services.GetPaymentProvider(payment.Provider)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+		return
+	}
 
+	result, err := provider.Capture(payment.ProviderRef)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"status": "failed", "error": err.Error()})
+		return
+	}
+
+	switch result.Status {
+	case services.PaymentStatusFailed:
+		if err := services.FailPayment(db, payment.ID, result.FailureReason); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
 			return
 		}
+		c.JSON(http.StatusPaymentRequired, gin.H{"status": "failed", "error": result.FailureReason})
+		return
 
-		if order.TotalAmount != payment.Amount {
-			c.JSON(400, gin.H{"error": "amount mismatch"})
+	case services.PaymentStatusProcessing:
+		if err := db.Model(payment).Update("payment_status", services.PaymentStatusProcessing).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
 			return
 		}
+		c.JSON(http.StatusAccepted, gin.H{"status": services.PaymentStatusProcessing, "payment_id": payment.ID})
+		return
+	}
 
-		if err := db.Transaction(func(tx *gorm.DB) error {
-
-			//reload order
-			db.Preload("OrderItems").First(&order, orderId)
-
-			if err := tx.Model(&payment).Update("payment_status", "completed").Error; err != nil {
-				return err
-			}
+	var refund *models.Refund
+	var afterCommit []func(db *gorm.DB)
 
-			if err := tx.Model(&order).Update("payment_status", "completed").Error; err != nil {
-				return err
-			}
+	//captured now, an order that was paid or closed meanwhile gets the money back
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		refund, afterCommit, err = services.ApplyCapturedPayment(tx, payment.ID)
+		return err
+	}); err != nil {
+		if errors.Is(err, services.ErrPaymentAmountMismatch) {
+			c.JSON(400, gin.H{"error": "amount mismatch"})
+			return
+		}
+		if errors.Is(err, services.ErrOrderAlreadyPaid) || errors.Is(err, services.ErrOrderNotPayable) {
+			c.JSON(http.StatusConflict, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+		return
+	}
 
-			totalProducts := len(order.OrderItems)
-			totalAmount := payment.Amount
+	for _, fn := range afterCommit {
+		fn(db)
+	}
 
-			if err := tx.Model(&models.AppStats{}).Where("id=?", 1).Updates(map[string]interface{}{
-				"total_sales":         gorm.Expr("total_sales + ?", 1),
-				"total_products_sold": gorm.Expr("total_products_sold + ?", totalProducts),
-				"total_revenue":       gorm.Expr("total_revenue + ?", totalAmount),
-			}).Error; err != nil {
-				return err
-			}
+	if refund != nil {
+		c.JSON(http.StatusConflict, gin.H{"status": "failed", "error": "order no longer takes this payment, it is refunded", "refund_id": refund.ID})
+		return
+	}
 
-			return nil
-		}); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
-			return
-		}
+	c.JSON(200, gin.H{"status": "paid", "order_id": payment.OrderID})
+}
 
-		c.JSON(200, gin.H{"status": "paid", "order_id": order.ID})
+// call off an intent at the provider, failures are only logged
+func cancelPaymentIntent(payment *models.Payment) {
+	provider, err := services.GetPaymentProvider(payment.Provider)
+	if err == nil {
+		err = provider.Cancel(payment.ProviderRef)
+	}
+	if err != nil {
+		log.Printf("cancel payment %d: %v\n", payment.ID, err)
 	}
 }
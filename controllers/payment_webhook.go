@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/services"
+	"github.com/junaid9001/spectr_backend/utils"
+	"gorm.io/gorm"
+)
+
+// provider callback (public), signed with X-Signature, ?provider= picks the gateway
+func PaymentWebhook(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider, err := services.GetPaymentProvider(c.Query("provider"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		payload, err := c.GetRawData()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "could not read body"})
+			return
+		}
+
+		if !provider.VerifyWebhookSignature(payload, c.GetHeader("X-Signature")) {
+			c.JSON(http.StatusUnauthorized, gin.H{"status": "failed", "error": "invalid signature"})
+			return
+		}
+
+		event, err := provider.ParseWebhookEvent(payload)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		stored, isNew, err := services.StorePaymentEvent(db, provider.Name(), event, payload)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		//replayed event, already handled (or waiting for an admin retry)
+		if !isNew {
+			c.JSON(http.StatusOK, gin.H{"status": "success", "message": "event already received"})
+			return
+		}
+
+		processed, err := services.ProcessPaymentEvent(db, stored.ID)
+		if err != nil {
+			//stored as failed, the provider does not need to resend it
+			c.JSON(http.StatusOK, gin.H{"status": "success", "event_status": services.PaymentEventStatusFailed, "error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "event_status": processed.Status})
+	}
+}
+
+//list received payment events, ?status=failed for the ones needing a retry (admin)
+
+func GetPaymentEvents(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var events []models.PaymentEvent
+
+		query := db.Order("created_at DESC")
+		if status := c.Query("status"); status != "" {
+			query = query.Where("status=?", status)
+		}
+
+		if err := query.Find(&events).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": events})
+	}
+}
+
+//run a failed payment event again (admin)
+
+func RetryPaymentEvent(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		eventId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		var event models.PaymentEvent
+
+		if err := db.First(&event, eventId).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "payment event not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		if event.Status == services.PaymentEventStatusProcessed {
+			c.JSON(http.StatusConflict, gin.H{"status": "failed", "error": services.ErrPaymentEventProcessed.Error()})
+			return
+		}
+
+		processed, err := services.ProcessPaymentEvent(db, event.ID)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"status": "failed", "error": err.Error(), "data": processed})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": processed})
+	}
+}
@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/utils"
+	"gorm.io/gorm"
+)
+
+// body for create / update tax rate
+type TaxRateInput struct {
+	Name       string  `json:"name" binding:"required,max=60"`
+	Rate       float64 `json:"rate" binding:"gte=0,lte=100"`
+	CategoryID *uint   `json:"category_id"`
+	Country    string  `json:"country" binding:"max=60"`
+	State      string  `json:"state" binding:"max=100"`
+	IsActive   *bool   `json:"is_active"`
+}
+
+func (in TaxRateInput) apply(rate *models.TaxRate) {
+	rate.Name = strings.TrimSpace(in.Name)
+	rate.Rate = in.Rate
+	rate.CategoryID = in.CategoryID
+	rate.Country = strings.TrimSpace(in.Country)
+	rate.State = strings.TrimSpace(in.State)
+
+	rate.IsActive = true
+	if in.IsActive != nil {
+		rate.IsActive = *in.IsActive
+	}
+}
+
+// body for create / update shipping rule
+type ShippingRuleInput struct {
+	Name      string       `json:"name" binding:"required,max=60"`
+	Type      string       `json:"type" binding:"required,oneof=flat weight free_over"`
+	Amount    models.Money `json:"amount" binding:"gte=0"`
+	PerKg     models.Money `json:"per_kg" binding:"gte=0"`
+	Threshold models.Money `json:"threshold" binding:"gte=0"`
+	Country   string       `json:"country" binding:"max=60"`
+	State     string       `json:"state" binding:"max=100"`
+	IsActive  *bool        `json:"is_active"`
+}
+
+func (in ShippingRuleInput) apply(rule *models.ShippingRule) {
+	rule.Name = strings.TrimSpace(in.Name)
+	rule.Type = in.Type
+	rule.Amount = in.Amount
+	rule.PerKg = in.PerKg
+	rule.Threshold = in.Threshold
+	rule.Country = strings.TrimSpace(in.Country)
+	rule.State = strings.TrimSpace(in.State)
+
+	rule.IsActive = true
+	if in.IsActive != nil {
+		rule.IsActive = *in.IsActive
+	}
+}
+
+//---------------- tax rates (admin) ----------------
+
+func CreateTaxRate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input TaxRateInput
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		var rate models.TaxRate
+		input.apply(&rate)
+
+		if err := db.Create(&rate).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"status": "success", "data": rate})
+	}
+}
+
+func GetTaxRates(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rates []models.TaxRate
+
+		if err := db.Order("id ASC").Find(&rates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": rates})
+	}
+}
+
+func UpdateTaxRate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rateId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		var input TaxRateInput
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		var rate models.TaxRate
+
+		if err := db.First(&rate, rateId).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "tax rate not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		input.apply(&rate)
+
+		if err := db.Save(&rate).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": rate})
+	}
+}
+
+func DeleteTaxRate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rateId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		res := db.Delete(&models.TaxRate{}, rateId)
+		if res.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": res.Error.Error()})
+			return
+		}
+		if res.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "tax rate not found"})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+//---------------- shipping rules (admin) ----------------
+
+func CreateShippingRule(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input ShippingRuleInput
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		var rule models.ShippingRule
+		input.apply(&rule)
+
+		if err := db.Create(&rule).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"status": "success", "data": rule})
+	}
+}
+
+func GetShippingRules(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rules []models.ShippingRule
+
+		if err := db.Order("id ASC").Find(&rules).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": rules})
+	}
+}
+
+func UpdateShippingRule(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ruleId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		var input ShippingRuleInput
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		var rule models.ShippingRule
+
+		if err := db.First(&rule, ruleId).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "shipping rule not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		input.apply(&rule)
+
+		if err := db.Save(&rule).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": rule})
+	}
+}
+
+func DeleteShippingRule(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ruleId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		res := db.Delete(&models.ShippingRule{}, ruleId)
+		if res.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": res.Error.Error()})
+			return
+		}
+		if res.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "shipping rule not found"})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
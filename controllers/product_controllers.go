@@ -1,14 +1,14 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/services"
 	"github.com/junaid9001/spectr_backend/utils"
 	"gorm.io/gorm"
 )
@@ -29,33 +29,44 @@ func CreateProduct(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		file, err := c.FormFile("image")
-		if err == nil {
-			//create a unique file name
-
-			fileName := fmt.Sprintf("%d_%s", time.Now().Unix(), filepath.Base(file.Filename))
-
-			//define where to save
-
-			uploadPath := "uploads/" + fileName
-
-			//save
-			if err := c.SaveUploadedFile(file, uploadPath); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
-				return
-			}
-
-			//Store ONLY the path string in the database
-			product.ImageUrl = "/" + uploadPath
+		currency, err := services.NormalizeCurrency(product.Currency)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status": "failed",
+				"error":  err.Error(),
+			})
+			return
+		}
+		product.Currency = currency
 
+		//gallery images, checked and resized before the product is created
+		images, err := saveImageUploads(imageUploads(c), product.Name, nil)
+		if err != nil {
+			respondImageError(c, err)
+			return
 		}
 
-		result := db.Create(&product)
-		if result.Error != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": result.Error.Error()})
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&product).Error; err != nil {
+				return err
+			}
+			if len(images) == 0 {
+				return nil
+			}
+			if err := services.AddProductImages(tx, product.ID, images); err != nil {
+				return err
+			}
+			return tx.Preload("Images", func(tx *gorm.DB) *gorm.DB {
+				return tx.Order("position ASC, id ASC")
+			}).First(&product, product.ID).Error
+		}); err != nil {
+			removeUploadedImages(images)
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
 			return
 		}
 
+		services.RefreshSuggestIndex()
+
 		c.JSON(http.StatusCreated, gin.H{
 			"status":  "success",
 			"product": product,
@@ -64,24 +75,55 @@ func CreateProduct(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
-//all products (public endpoint)
+//all products (public endpoint), filters can be combined
+// ?category_id= ?brand= ?price_min= ?price_max= ?option_id= ?in_stock=true
+// ?sort=newest|price_asc|price_desc|popularity|rating|views ?limit= ?cursor=<next_cursor of the last page>
 
 func GetAllProducts(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
-		products := []models.Product{}
+		//X-Currency header or ?currency=, prices in and out are in it
+		currency, rate, ok := requestCurrency(c, db)
+		if !ok {
+			return
+		}
 
-		if err := db.Find(&products).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"status": "failed",
-				"error":  err.Error(),
-			})
+		filters, ok := productFilters(c, rate)
+		if !ok {
+			return
+		}
+
+		_, limit := pageParams(c)
+
+		result, err := services.ListProducts(db, services.ListParams{
+			ProductFilters: filters,
+			Sort:           c.Query("sort"),
+			Cursor:         c.Query("cursor"),
+			Limit:          limit,
+		})
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidSort) || errors.Is(err, services.ErrInvalidCursor) {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		unconverted, err := services.LocalizeProducts(db, result.Products, currency)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
 			return
 		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"status": "success",
-			"data":   products,
+			"status":      "success",
+			"currency":    currency,
+			"data":        result.Products,
+			"unconverted": unconverted, //ids of products still priced in their own currency
+			"total":       result.Total,
+			"limit":       limit,
+			"next_cursor": result.NextCursor,
 		})
 	}
 }
@@ -102,7 +144,12 @@ func GetProductByID(db *gorm.DB) gin.HandlerFunc {
 
 		var product models.Product
 
-		if err := db.First(&product, id).Error; err != nil {
+		err = db.Preload("Variants", func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("is_active = ?", true).Order("id ASC")
+		}).Preload("Images", func(tx *gorm.DB) *gorm.DB {
+			return tx.Order("position ASC, id ASC")
+		}).First(&product, id).Error
+		if err != nil {
 
 			if err == gorm.ErrRecordNotFound {
 				c.JSON(http.StatusNotFound, gin.H{
@@ -118,21 +165,94 @@ func GetProductByID(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		//on hand minus units held in carts / checkouts
+		available, err := services.AvailableStock(db, &product)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status": "failed",
+				"error":  err.Error(),
+			})
+			return
+		}
+
+		//variant id -> units left of it
+		variantStock := make(map[uint]int, len(product.Variants))
+		for i := range product.Variants {
+			variantAvailable, err := services.AvailableVariantStock(db, &product.Variants[i])
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"status": "failed",
+					"error":  err.Error(),
+				})
+				return
+			}
+			variantStock[product.Variants[i].ID] = variantAvailable
+		}
+
+		//average and stars histogram of the published reviews
+		rating, err := services.ProductRatingSummary(db, product.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status": "failed",
+				"error":  err.Error(),
+			})
+			return
+		}
+
+		//most answered questions, the rest through /product/:id/questions
+		questions, err := services.TopAnsweredQuestions(db, product.ID, 3)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status": "failed",
+				"error":  err.Error(),
+			})
+			return
+		}
+
+		//X-Currency header or ?currency=
+		currency, _, ok := requestCurrency(c, db)
+		if !ok {
+			return
+		}
+
+		//a currency without a rate keeps its own price, flagged like in the listing
+		unconverted := []uint{}
+		if err := services.LocalizeProduct(db, &product, currency); err != nil {
+			if !errors.Is(err, services.ErrUnknownCurrency) {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"status": "failed",
+					"error":  "db error",
+				})
+				return
+			}
+			unconverted = append(unconverted, product.ID)
+		}
+
+		//for /user/recently-viewed and view counts, guests are kept by a session cookie
+		recordProductView(c, db, product.ID)
+
 		c.JSON(http.StatusOK, gin.H{
-			"status": "success",
-			"data":   product,
+			"status":          "success",
+			"data":            product,
+			"available_stock": available,
+			"variant_stock":   variantStock,
+			"rating":          rating,
+			"questions":       questions,
+			"unconverted":     unconverted,
 		})
 	}
 }
 
 // struct for update product
 type ProductUpdateInput struct {
-	Name          *string  `json:"name"`
-	Description   *string  `json:"description"`
-	Price         *float64 `json:"price"`
-	StockQuantity *int     `json:"stock_quantity"`
-	CategoryID    *uint    `json:"category_id"`
-	Brand         *string  `json:"brand"`
+	Name          *string       `json:"name"`
+	Description   *string       `json:"description"`
+	Price         *models.Money `json:"price"`
+	Currency      *string       `json:"currency"`
+	StockQuantity *int          `json:"stock_quantity"`
+	CategoryID    *uint         `json:"category_id"`
+	Brand         *string       `json:"brand"`
+	WeightGrams   *int          `json:"weight_grams"`
 }
 
 //update product info by id
@@ -192,6 +312,14 @@ func UpdateProductByID(db *gorm.DB) gin.HandlerFunc {
 				product.Price = *input.Price
 			}
 
+			if input.Currency != nil {
+				currency, err := services.NormalizeCurrency(*input.Currency)
+				if err != nil {
+					return err
+				}
+				product.Currency = currency
+			}
+
 			if input.StockQuantity != nil {
 				product.StockQuantity = *input.StockQuantity
 			}
@@ -204,6 +332,13 @@ func UpdateProductByID(db *gorm.DB) gin.HandlerFunc {
 				product.Brand = *input.Brand
 			}
 
+			if input.WeightGrams != nil {
+				if *input.WeightGrams < 0 {
+					return fmt.Errorf("weight can't be less than zero")
+				}
+				product.WeightGrams = *input.WeightGrams
+			}
+
 			if product.Price < 0 {
 				return fmt.Errorf("price can't be less that zero")
 			}
@@ -219,6 +354,8 @@ func UpdateProductByID(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		services.RefreshSuggestIndex()
+
 		c.JSON(http.StatusOK, gin.H{"status": "success", "data": product})
 	}
 }
@@ -239,18 +376,20 @@ func DeleteProductByID(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		//soft delete, the gallery rows and their files are kept so a restored product comes back
+		//with its images
 		result := db.Delete(&models.Product{}, id)
-
 		if result.Error != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": result.Error.Error()})
 			return
 		}
-
 		if result.RowsAffected == 0 {
 			c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "product not found"})
 			return
 		}
 
+		services.RefreshSuggestIndex()
+
 		c.JSON(http.StatusOK, gin.H{"status": "success"})
 		//restore by db.unscoped update deleted_at to nil
 
@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/services"
+	"github.com/junaid9001/spectr_backend/utils"
+	"gorm.io/gorm"
+)
+
+// run every upload through the image pipeline, on an error the files already written are removed
+func saveImageUploads(files []*multipart.FileHeader, altText string, variantId *uint) ([]*models.ProductImage, error) {
+	var images []*models.ProductImage
+
+	for _, file := range files {
+		img, err := services.SaveProductImage(file)
+		if err != nil {
+			removeUploadedImages(images)
+			return nil, err
+		}
+		img.AltText = altText
+		img.VariantID = variantId
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+func removeUploadedImages(images []*models.ProductImage) {
+	for _, img := range images {
+		services.RemoveImageFiles(img.Files()...)
+	}
+}
+
+// "image" (single, older clients) and "images" (multiple) form files
+func imageUploads(c *gin.Context) []*multipart.FileHeader {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil
+	}
+	return append(form.File["image"], form.File["images"]...)
+}
+
+func respondImageError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrImageTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"status": "failed", "error": err.Error()})
+	case errors.Is(err, services.ErrImageType):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"status": "failed", "error": err.Error()})
+	case errors.Is(err, services.ErrImageInvalid), errors.Is(err, services.ErrImageOrder):
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+	}
+}
+
+// add images to a product gallery (admin)
+// multipart form: images (files), alt_text, variant_id (optional)
+func UploadProductImages(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		var product models.Product
+
+		if err := db.First(&product, productId).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "product not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		var variantId *uint
+		if raw := c.PostForm("variant_id"); raw != "" {
+			id, err := utils.StringToUint(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid variant_id"})
+				return
+			}
+			if _, err := services.ResolveVariant(db, &product, &id); err != nil {
+				if errors.Is(err, services.ErrVariantNotFound) {
+					c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+				return
+			}
+			variantId = &id
+		}
+
+		files := imageUploads(c)
+		if len(files) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "at least one image is required"})
+			return
+		}
+
+		images, err := saveImageUploads(files, strings.TrimSpace(c.PostForm("alt_text")), variantId)
+		if err != nil {
+			respondImageError(c, err)
+			return
+		}
+
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return services.AddProductImages(tx, product.ID, images)
+		}); err != nil {
+			removeUploadedImages(images)
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"status": "success", "data": images})
+	}
+}
+
+// body for update product image
+type ProductImageUpdateInput struct {
+	AltText   *string `json:"alt_text" binding:"omitempty,max=255"`
+	IsPrimary *bool   `json:"is_primary"`
+}
+
+//update alt text / primary flag of a gallery image (admin)
+
+func UpdateProductImage(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		imageId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		var input ProductImageUpdateInput
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		var img models.ProductImage
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.First(&img, imageId).Error; err != nil {
+				return err
+			}
+
+			if input.AltText != nil {
+				img.AltText = strings.TrimSpace(*input.AltText)
+				if err := tx.Model(&img).Update("alt_text", img.AltText).Error; err != nil {
+					return err
+				}
+			}
+
+			if input.IsPrimary != nil && *input.IsPrimary {
+				return services.SetPrimaryImage(tx, &img)
+			}
+			return nil
+		})
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "image not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": img})
+	}
+}
+
+// set the gallery order (admin), body: {"image_ids":[3,1,2]} with every image of the product
+func ReorderProductImages(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		var input struct {
+			ImageIDs []uint `json:"image_ids" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return services.ReorderProductImages(tx, productId, input.ImageIDs)
+		}); err != nil {
+			respondImageError(c, err)
+			return
+		}
+
+		var images []models.ProductImage
+
+		if err := db.Where("product_id=?", productId).Order("position ASC, id ASC").Find(&images).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": images})
+	}
+}
+
+// delete a gallery image and its files (admin)
+func DeleteProductImage(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		imageId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		var files []string
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var img models.ProductImage
+			if err := tx.First(&img, imageId).Error; err != nil {
+				return err
+			}
+
+			files, err = services.DeleteProductImage(tx, &img)
+			return err
+		})
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "image not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		//only after commit, a rolled back delete keeps its files
+		services.RemoveImageFiles(files...)
+
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/junaid9001/spectr_backend/services"
+	"github.com/junaid9001/spectr_backend/utils"
+	"gorm.io/gorm"
+)
+
+// cookie of anonymous visitors, their views move to the account on login
+const viewerSessionCookie = "viewer_session"
+
+const viewerSessionMaxAge = 30 * 24 * time.Hour
+
+// id of a logged-in user on routes where login is optional, 0 for guests
+func optionalUserId(c *gin.Context) uint {
+	uID, exists := c.Get("userId")
+	if !exists {
+		return 0
+	}
+	userId, _ := uID.(uint)
+	return userId
+}
+
+// session id from the viewer cookie, a new one is set when there is none
+func viewerSession(c *gin.Context) (string, error) {
+	if session, err := c.Cookie(viewerSessionCookie); err == nil && validViewerSession(session) {
+		return session, nil
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	session := hex.EncodeToString(b)
+
+	c.SetCookie(viewerSessionCookie, session, int(viewerSessionMaxAge.Seconds()), "/", "", false, true)
+	return session, nil
+}
+
+func validViewerSession(session string) bool {
+	if len(session) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(session)
+	return err == nil
+}
+
+// remember a product view, a failure is logged and doesn't fail the page
+func recordProductView(c *gin.Context, db *gorm.DB, productId uint) {
+	userId := optionalUserId(c)
+
+	session := ""
+	if userId == 0 {
+		var err error
+		if session, err = viewerSession(c); err != nil {
+			log.Println("viewer session:", err)
+			return
+		}
+	}
+
+	if err := services.RecordProductView(db, productId, userId, session); err != nil {
+		log.Println("record product view:", err)
+	}
+}
+
+// views of the guest session move to the user, the cookie is cleared
+func mergeViewerSession(c *gin.Context, db *gorm.DB, userId uint) {
+	session, err := c.Cookie(viewerSessionCookie)
+	if err != nil || !validViewerSession(session) {
+		return
+	}
+
+	if err := services.MergeProductViews(db, session, userId); err != nil {
+		log.Println("merge product views:", err)
+		return
+	}
+	c.SetCookie(viewerSessionCookie, "", -1, "/", "", false, true)
+}
+
+// products the user opened, the latest first (user) ?limit= (default 20, at most 50)
+func RecentlyViewed(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		limit, err := strconv.Atoi(c.Query("limit"))
+		if err != nil || limit < 1 {
+			limit = 20
+		}
+		if limit > services.RecentViewsKept {
+			limit = services.RecentViewsKept
+		}
+
+		//X-Currency header or ?currency=
+		currency, _, ok := requestCurrency(c, db)
+		if !ok {
+			return
+		}
+
+		products, err := services.RecentlyViewedProducts(db, userId, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		unconverted, err := services.LocalizeProducts(db, products, currency)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "currency": currency, "data": products, "unconverted": unconverted})
+	}
+}
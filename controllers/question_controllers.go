@@ -0,0 +1,260 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/services"
+	"github.com/junaid9001/spectr_backend/utils"
+	"gorm.io/gorm"
+)
+
+type QuestionInput struct {
+	Body string `json:"body" binding:"required,max=2000"`
+}
+
+func bindQuestionBody(c *gin.Context) (string, bool) {
+	var input QuestionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+		return "", false
+	}
+
+	body := strings.TrimSpace(input.Body)
+	if body == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "body is required"})
+		return "", false
+	}
+	return body, true
+}
+
+// published questions of a product with their published answers (public)
+// ?answered=true (only answered ones) ?page= ?limit=
+func GetProductQuestions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		answered, _ := strconv.ParseBool(c.Query("answered"))
+
+		published := func() *gorm.DB {
+			query := db.Model(&models.ProductQuestion{}).
+				Where("product_id=? AND status=?", productId, models.QuestionStatusPublished)
+			if answered {
+				query = query.Where("answer_count > 0")
+			}
+			return query
+		}
+
+		var total int64
+		if err := published().Count(&total).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		page, limit := pageParams(c)
+
+		questions := []models.ProductQuestion{}
+		if err := services.WithQuestionAnswers(published(), true).
+			Order("answer_count DESC, created_at DESC, id DESC").
+			Offset((page - 1) * limit).Limit(limit).
+			Find(&questions).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"data":   questions,
+			"total":  total,
+			"page":   page,
+			"limit":  limit,
+		})
+	}
+}
+
+// ask about a product (user) body: {"body": ""}
+func AskQuestion(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		body, ok := bindQuestionBody(c)
+		if !ok {
+			return
+		}
+
+		question, err := services.AskQuestion(db, userId, productId, body)
+		if err != nil {
+			respondQuestionError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"status": "success", "data": question})
+	}
+}
+
+// users own questions with every answer they got
+func GetUserQuestions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		questions := []models.ProductQuestion{}
+		if err := services.WithQuestionAnswers(db, true).Where("user_id=?", userId).
+			Order("created_at DESC").Find(&questions).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": questions})
+	}
+}
+
+// answer a question, as a customer who received the product (user routes) or as the store
+// (admin routes) body: {"body": ""}
+func AnswerQuestion(db *gorm.DB, role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		questionId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		body, ok := bindQuestionBody(c)
+		if !ok {
+			return
+		}
+
+		answer, err := services.AnswerQuestion(db, services.OrderActor{UserID: userId, Role: role}, questionId, body)
+		if err != nil {
+			respondQuestionError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"status": "success", "data": answer})
+	}
+}
+
+//---------------**---------------------
+
+// every question with all answers, ?status= ?product_id= ?unanswered=true (admin)
+func GetAllQuestions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := services.WithQuestionAnswers(db, false).Order("created_at DESC")
+
+		if status := c.Query("status"); status != "" {
+			query = query.Where("status=?", status)
+		}
+
+		if raw := c.Query("product_id"); raw != "" {
+			productId, err := utils.StringToUint(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid product_id"})
+				return
+			}
+			query = query.Where("product_id=?", productId)
+		}
+
+		if unanswered, _ := strconv.ParseBool(c.Query("unanswered")); unanswered {
+			query = query.Where("answer_count = 0")
+		}
+
+		questions := []models.ProductQuestion{}
+		if err := query.Find(&questions).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": questions})
+	}
+}
+
+type ModerationInput struct {
+	Status string `json:"status" binding:"required,oneof=published hidden"`
+}
+
+// publish or hide a question (admin) body: {"status": "published" | "hidden"}
+func ModerateQuestion(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		questionId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		var input ModerationInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		question, err := services.ModerateQuestion(db, questionId, input.Status)
+		if err != nil {
+			respondQuestionError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": question})
+	}
+}
+
+// publish or hide an answer (admin) body: {"status": "published" | "hidden"}
+func ModerateAnswer(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		answerId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		var input ModerationInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		answer, err := services.ModerateAnswer(db, answerId, input.Status)
+		if err != nil {
+			respondQuestionError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": answer})
+	}
+}
+
+func respondQuestionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "not found"})
+	case errors.Is(err, services.ErrCannotAnswer):
+		c.JSON(http.StatusForbidden, gin.H{"status": "failed", "error": err.Error()})
+	case errors.Is(err, services.ErrQuestionHidden):
+		c.JSON(http.StatusConflict, gin.H{"status": "failed", "error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+	}
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/services"
+	"github.com/junaid9001/spectr_backend/utils"
+	"gorm.io/gorm"
+)
+
+// ?limit= (default 10, at most 50)
+func recommendationLimit(c *gin.Context) int {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit < 1 {
+		limit = 10
+	}
+	if limit > 50 {
+		limit = 50
+	}
+	return limit
+}
+
+// products like this one (public) ?limit=
+func RelatedProducts(db *gorm.DB) gin.HandlerFunc {
+	return productRecommendations(db, services.RelatedProducts)
+}
+
+// products often ordered with this one (public) ?limit=
+func BoughtTogether(db *gorm.DB) gin.HandlerFunc {
+	return productRecommendations(db, services.BoughtTogether)
+}
+
+func productRecommendations(db *gorm.DB, recommend func(*gorm.DB, uint, int) ([]models.Product, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		//X-Currency header or ?currency=
+		currency, _, ok := requestCurrency(c, db)
+		if !ok {
+			return
+		}
+
+		if err := db.First(&models.Product{}, productId).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "product not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		products, err := recommend(db, productId, recommendationLimit(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		unconverted, err := services.LocalizeProducts(db, products, currency)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "currency": currency, "data": products, "unconverted": unconverted})
+	}
+}
+
+// products picked for the user from their orders and wishlist (user) ?limit=
+func UserRecommendations(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		//X-Currency header or ?currency=
+		currency, _, ok := requestCurrency(c, db)
+		if !ok {
+			return
+		}
+
+		products, err := services.UserRecommendations(db, userId, recommendationLimit(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		unconverted, err := services.LocalizeProducts(db, products, currency)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "currency": currency, "data": products, "unconverted": unconverted})
+	}
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/services"
+	"github.com/junaid9001/spectr_backend/utils"
+	"gorm.io/gorm"
+)
+
+// refund an order (admin), no items = full refund of what is left
+func CreateRefund(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		adminId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		var input struct {
+			Reason string                `json:"reason" binding:"required"`
+			Items  []services.RefundLine `json:"items" binding:"dive"`
+		}
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		var refund *models.Refund
+
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			refund, err = services.IssueRefund(tx, orderId, input.Items, input.Reason, adminId)
+			return err
+		}); err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "order not found"})
+			case errors.Is(err, services.ErrNoPaidPayment), errors.Is(err, services.ErrNothingToRefund):
+				c.JSON(http.StatusConflict, gin.H{"status": "failed", "error": err.Error()})
+			case errors.Is(err, services.ErrInvalidRefundQty):
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			}
+			return
+		}
+
+		//the money is sent once the refund is booked, a provider failure leaves it pending for the retry job
+		settleErr := services.SettleRefund(db, refund.ID)
+
+		var saved models.Refund
+		if err := db.Preload("Items").First(&saved, refund.ID).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		if settleErr != nil {
+			c.JSON(http.StatusAccepted, gin.H{"status": "success", "data": saved, "error": settleErr.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"status": "success", "data": saved})
+	}
+}
+
+//refunds of an order (admin)
+
+func GetOrderRefunds(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		var refunds []models.Refund
+
+		if err := db.Preload("Items").Where("order_id=?", orderId).Order("created_at ASC").Find(&refunds).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": refunds})
+	}
+}
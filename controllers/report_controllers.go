@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/junaid9001/spectr_backend/services"
+	"gorm.io/gorm"
+)
+
+// what shoppers searched for (admin) ?days= (default 30) ?limit= (default 20, at most 100)
+// most searched queries and the ones that found nothing
+func SearchQueryReport(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		days := 30
+		if raw := c.Query("days"); raw != "" {
+			value, err := strconv.Atoi(raw)
+			if err != nil || value < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "days must be a positive number"})
+				return
+			}
+			days = value
+		}
+
+		_, limit := pageParams(c)
+
+		report, err := services.SearchQueryReport(db, time.Now().AddDate(0, 0, -days), limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": report})
+	}
+}
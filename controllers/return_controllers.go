@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/services"
+	"github.com/junaid9001/spectr_backend/utils"
+	"gorm.io/gorm"
+)
+
+// request a return for items of a delivered order (user)
+// multipart form: reason, items = [{"order_item_id":1,"quantity":1}], photo (optional)
+func CreateReturnRequest(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		reason := strings.TrimSpace(c.PostForm("reason"))
+		if reason == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "reason is required"})
+			return
+		}
+
+		var lines []services.ReturnLine
+		if err := json.Unmarshal([]byte(c.PostForm("items")), &lines); err != nil || len(lines) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "items must be a json list of order_item_id and quantity"})
+			return
+		}
+
+		photoKey := ""
+		file, err := c.FormFile("photo")
+		if err == nil {
+			photoKey, err = services.SaveUploadedPhoto(file, "returns")
+			if err != nil {
+				respondImageError(c, err)
+				return
+			}
+		}
+
+		request, err := services.CreateReturnRequest(db, userId, orderId, lines, reason, photoKey)
+		if err != nil {
+			services.RemoveImageFiles(photoKey)
+			respondReturnError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"status": "success", "data": request})
+	}
+}
+
+//users returns
+
+func GetUserReturns(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		var requests []models.ReturnRequest
+
+		if err := db.Preload("Items").Where("user_id=?", userId).Order("created_at DESC").Find(&requests).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": requests})
+	}
+}
+
+//---------------**---------------------
+
+//all return requests, ?status= to filter (admin)
+
+func GetAllReturns(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var requests []models.ReturnRequest
+
+		query := db.Preload("Items").Order("created_at DESC")
+		if status := c.Query("status"); status != "" {
+			query = query.Where("status=?", status)
+		}
+
+		if err := query.Find(&requests).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": requests})
+	}
+}
+
+//approve or reject a requested return (admin) body: {"approve": true, "note": ""}
+
+func ReviewReturnRequest(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		returnId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		var input struct {
+			Approve *bool  `json:"approve" binding:"required"`
+			Note    string `json:"note"`
+		}
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		request, err := services.ReviewReturnRequest(db, returnId, *input.Approve, input.Note)
+		if err != nil {
+			respondReturnError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": request})
+	}
+}
+
+//goods arrived (admin) body: {"resolution": "restock" | "write_off", "note": ""}
+
+func ReceiveReturn(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		returnId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		adminId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		var input struct {
+			Resolution string `json:"resolution" binding:"required,oneof=restock write_off"`
+			Note       string `json:"note"`
+		}
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		request, err := services.ReceiveReturn(db, returnId, input.Resolution, input.Note, adminId)
+		if err != nil {
+			respondReturnError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": request})
+	}
+}
+
+func respondReturnError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "not found"})
+	case errors.Is(err, services.ErrInvalidReturnQty):
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+	case errors.Is(err, services.ErrReturnState), errors.Is(err, services.ErrReturnWindowClosed),
+		errors.Is(err, services.ErrOrderNotReturnable):
+		c.JSON(http.StatusConflict, gin.H{"status": "failed", "error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+	}
+}
@@ -0,0 +1,362 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/services"
+	"github.com/junaid9001/spectr_backend/utils"
+	"gorm.io/gorm"
+)
+
+// rating, title and body form fields, only the ones sent are set
+func reviewInput(c *gin.Context) (services.ReviewInput, bool) {
+	var input services.ReviewInput
+
+	if raw, ok := c.GetPostForm("rating"); ok {
+		rating, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": services.ErrInvalidRating.Error()})
+			return input, false
+		}
+		input.Rating = &rating
+	}
+
+	if raw, ok := c.GetPostForm("title"); ok {
+		title := strings.TrimSpace(raw)
+		if utf8.RuneCountInString(title) > 120 {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "title can have at most 120 characters"})
+			return input, false
+		}
+		input.Title = &title
+	}
+
+	if raw, ok := c.GetPostForm("body"); ok {
+		body := strings.TrimSpace(raw)
+		input.Body = &body
+	}
+
+	return input, true
+}
+
+// "photos" form files stored under reviews/
+func saveReviewPhotos(c *gin.Context) ([]string, bool) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, true
+	}
+
+	files := form.File["photos"]
+	if len(files) > services.MaxReviewPhotos {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": services.ErrTooManyPhotos.Error()})
+		return nil, false
+	}
+
+	var keys []string
+	for _, file := range files {
+		key, err := services.SaveUploadedPhoto(file, "reviews")
+		if err != nil {
+			services.RemoveImageFiles(keys...)
+			respondImageError(c, err)
+			return nil, false
+		}
+		keys = append(keys, key)
+	}
+	return keys, true
+}
+
+// review a received product (user)
+// multipart form: rating (1-5), title, body, photos (files, at most 5)
+func CreateReview(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		input, ok := reviewInput(c)
+		if !ok {
+			return
+		}
+
+		photos, ok := saveReviewPhotos(c)
+		if !ok {
+			return
+		}
+
+		review, err := services.CreateReview(db, userId, productId, input, photos)
+		if err != nil {
+			services.RemoveImageFiles(photos...)
+			respondReviewError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"status": "success", "data": review})
+	}
+}
+
+// edit own review (user), it is moderated again
+// multipart form: rating, title, body, photos (replace the old ones), clear_photos=true (remove them)
+func UpdateReview(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reviewId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		input, ok := reviewInput(c)
+		if !ok {
+			return
+		}
+
+		photos, ok := saveReviewPhotos(c)
+		if !ok {
+			return
+		}
+
+		clearPhotos, _ := strconv.ParseBool(c.PostForm("clear_photos"))
+
+		review, removed, err := services.UpdateReview(db, userId, reviewId, input, photos, clearPhotos || len(photos) > 0)
+		if err != nil {
+			services.RemoveImageFiles(photos...)
+			respondReviewError(c, err)
+			return
+		}
+
+		services.RemoveImageFiles(removed...)
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": review})
+	}
+}
+
+// delete own review (user)
+func DeleteReview(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reviewId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		photos, err := services.DeleteReview(db, userId, reviewId)
+		if err != nil {
+			respondReviewError(c, err)
+			return
+		}
+
+		services.RemoveImageFiles(photos...)
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "message": "review deleted"})
+	}
+}
+
+// users own reviews with their moderation state
+func GetUserReviews(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		reviews := []models.Review{}
+
+		if err := services.WithReviewPhotos(db).Where("user_id=?", userId).
+			Order("created_at DESC").Find(&reviews).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": reviews})
+	}
+}
+
+// POST marks a review helpful, DELETE takes the vote back (user)
+func VoteReviewHelpful(db *gorm.DB, helpful bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reviewId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		review, err := services.VoteReviewHelpful(db, userId, reviewId, helpful)
+		if err != nil {
+			respondReviewError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": review})
+	}
+}
+
+// published reviews of a product with the rating summary (public)
+// ?rating= (stars) ?sort=newest|helpful|rating_desc|rating_asc ?page= ?limit=
+func GetProductReviews(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		sorts := map[string]string{
+			"":            "created_at DESC, id DESC",
+			"newest":      "created_at DESC, id DESC",
+			"helpful":     "helpful_count DESC, created_at DESC, id DESC",
+			"rating_desc": "rating DESC, created_at DESC, id DESC",
+			"rating_asc":  "rating ASC, created_at DESC, id DESC",
+		}
+		order, ok := sorts[c.Query("sort")]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "sort must be one of newest, helpful, rating_desc, rating_asc"})
+			return
+		}
+
+		rating := 0
+		if raw := c.Query("rating"); raw != "" {
+			rating, err = strconv.Atoi(raw)
+			if err != nil || rating < 1 || rating > 5 {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": services.ErrInvalidRating.Error()})
+				return
+			}
+		}
+
+		published := func() *gorm.DB {
+			query := db.Model(&models.Review{}).Where("product_id=? AND status=?", productId, models.ReviewStatusApproved)
+			if rating != 0 {
+				query = query.Where("rating=?", rating)
+			}
+			return query
+		}
+
+		var total int64
+		if err := published().Count(&total).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		page, limit := pageParams(c)
+
+		reviews := []models.Review{}
+		if err := services.WithReviewPhotos(published()).Order(order).
+			Offset((page - 1) * limit).Limit(limit).Find(&reviews).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		summary, err := services.ProductRatingSummary(db, productId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"data":   reviews,
+			"rating": summary,
+			"total":  total,
+			"page":   page,
+			"limit":  limit,
+		})
+	}
+}
+
+//---------------**---------------------
+
+// reviews to moderate, ?status= (default pending) ?product_id= (admin)
+func GetAllReviews(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := c.DefaultQuery("status", models.ReviewStatusPending)
+
+		query := services.WithReviewPhotos(db).Where("status=?", status).Order("created_at ASC")
+
+		if raw := c.Query("product_id"); raw != "" {
+			productId, err := utils.StringToUint(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid product_id"})
+				return
+			}
+			query = query.Where("product_id=?", productId)
+		}
+
+		reviews := []models.Review{}
+		if err := query.Find(&reviews).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": reviews})
+	}
+}
+
+// approve or hide a review (admin) body: {"status": "approved" | "hidden", "note": ""}
+func ModerateReview(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reviewId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		var input struct {
+			Status string `json:"status" binding:"required,oneof=approved hidden"`
+			Note   string `json:"note"`
+		}
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		review, err := services.ModerateReview(db, reviewId, input.Status, input.Note)
+		if err != nil {
+			respondReviewError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": review})
+	}
+}
+
+func respondReviewError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "not found"})
+	case errors.Is(err, services.ErrInvalidRating), errors.Is(err, services.ErrTooManyPhotos),
+		errors.Is(err, services.ErrOwnReview):
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+	case errors.Is(err, services.ErrNotPurchased):
+		c.JSON(http.StatusForbidden, gin.H{"status": "failed", "error": err.Error()})
+	case errors.Is(err, services.ErrReviewExists), errors.Is(err, services.ErrReviewNotShown):
+		c.JSON(http.StatusConflict, gin.H{"status": "failed", "error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+	}
+}
@@ -1,52 +1,215 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/services"
 	"github.com/junaid9001/spectr_backend/utils"
 	"gorm.io/gorm"
 )
 
 //done postman
 
-// search product by name (public) (done by query  parameter) tested
+// full text product search (public) ?q=
+// optional: category_id, brand (repeat or comma separated), price_min, price_max, option_id (repeat), in_stock, page, limit
 func SearchProduct(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
-		name := c.Query("name")
+		query := strings.TrimSpace(c.Query("q"))
+		if query == "" {
+			query = strings.TrimSpace(c.Query("name")) //older clients
+		}
 
-		if name == "" {
+		if query == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "search something"})
 			return
 		}
 
-		//partial match 2*% == 1*%
-
-		search := fmt.Sprintf("%%%s%%", name)
+		//X-Currency header or ?currency=, prices in and out are in it
+		currency, rate, ok := requestCurrency(c, db)
+		if !ok {
+			return
+		}
 
-		var products []models.Product
+		filters, ok := productFilters(c, rate)
+		if !ok {
+			return
+		}
 
-		// ILIKE is caseintensice
+		params := services.SearchParams{Query: query, ProductFilters: filters}
+		params.Page, params.Limit = pageParams(c)
 
-		if err := db.Where("name ILIKE ?", search).Find(&products).Error; err != nil {
+		result, err := services.SearchProducts(db, params)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
 			return
 		}
 
-		if len(products) == 0 {
+		//zero result searches too, they show what shoppers can't find. only the first page, paging
+		//through the results is the same search
+		if params.Page == 1 {
+			if err := services.LogSearchQuery(db, query, result.Total); err != nil {
+				log.Println("search log:", err)
+			}
+		}
+
+		if result.Total == 0 {
 			c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "no products found"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"status": "success", "data": products})
+		//hits whose currency has no rate keep their own price, like in the listing
+		localizer := services.NewProductLocalizer(db, currency)
+		unconverted := []uint{}
+		for i := range result.Hits {
+			if err := localizer.Localize(&result.Hits[i].Product); err != nil {
+				if !errors.Is(err, services.ErrUnknownCurrency) {
+					c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+					return
+				}
+				unconverted = append(unconverted, result.Hits[i].Product.ID)
+			}
+		}
+		for i := range result.Facets.PriceBuckets {
+			bucket := &result.Facets.PriceBuckets[i]
+			bucket.Min, bucket.Max = bucket.Min.Convert(rate), bucket.Max.Convert(rate)
+		}
 
+		c.JSON(http.StatusOK, gin.H{
+			"status":      "success",
+			"currency":    currency,
+			"data":        result.Hits,
+			"total":       result.Total,
+			"page":        result.Page,
+			"limit":       result.Limit,
+			"facets":      result.Facets,
+			"unconverted": unconverted,
+		})
 	}
 }
 
+// autocomplete for the search box (public) ?q= ?limit= (per type, default 5, at most 10)
+// prefix matches of product names, brands and categories from memory, most sold first
+func SearchSuggestions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, err := strconv.Atoi(c.Query("limit"))
+		if err != nil || limit < 1 {
+			limit = 5
+		}
+		if limit > 10 {
+			limit = 10
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"data":   services.Suggest(c.Query("q"), limit),
+		})
+	}
+}
+
+// ?category_id= ?brand= ?price_min= ?price_max= (request currency) ?option_id= ?in_stock=true
+// shared by search and the product listing, writes the error response when a value is invalid
+func productFilters(c *gin.Context, rate float64) (services.ProductFilters, bool) {
+	filters := services.ProductFilters{Brands: queryList(c, "brand")}
+
+	if raw := c.Query("category_id"); raw != "" {
+		categoryId, err := utils.StringToUint(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid category_id"})
+			return filters, false
+		}
+		filters.CategoryID = &categoryId
+	}
+
+	var err error
+	if filters.MinPrice, err = queryPrice(c, "price_min", rate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid price range"})
+		return filters, false
+	}
+	if filters.MaxPrice, err = queryPrice(c, "price_max", rate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid price range"})
+		return filters, false
+	}
+	if filters.MinPrice != nil && filters.MaxPrice != nil && *filters.MinPrice > *filters.MaxPrice {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid price range"})
+		return filters, false
+	}
+
+	for _, raw := range queryList(c, "option_id") {
+		optionId, err := utils.StringToUint(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid option_id"})
+			return filters, false
+		}
+		filters.OptionIDs = append(filters.OptionIDs, optionId)
+	}
+
+	if raw := c.Query("in_stock"); raw != "" {
+		inStock, err := strconv.ParseBool(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid in_stock"})
+			return filters, false
+		}
+		filters.InStock = inStock
+	}
+
+	return filters, true
+}
+
+// ?key=a&key=b and ?key=a,b
+func queryList(c *gin.Context, key string) []string {
+	var values []string
+	for _, raw := range c.QueryArray(key) {
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// price query param in the request currency, returned in the store currency
+func queryPrice(c *gin.Context, key string, rate float64) (*models.Money, error) {
+	raw := c.Query(key)
+	if raw == "" {
+		return nil, nil
+	}
+
+	price, err := models.ParseMoney(raw)
+	if err != nil || price < 0 {
+		return nil, models.ErrInvalidMoney
+	}
+
+	price = price.Convert(1 / rate)
+	return &price, nil
+}
+
+// ?page= (from 1) and ?limit= (default 20, at most 100)
+func pageParams(c *gin.Context) (int, int) {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit < 1 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	return page, limit
+}
+
 //filter product by category(smart/luxury) query based ?category=
+// superseded by GET /products?category_id=, kept for older clients
 
 func FilterProductByCategoryID(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -77,44 +240,12 @@ func FilterProductByCategoryID(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		var Categories []models.Category
-
-		if err := db.Find(&Categories).Error; err != nil {
+		allIDs, err := services.CategoryWithDescendants(db, categoryId)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
 			return
 		}
 
-		childrenMap := make(map[uint][]uint)
-		for _, cat := range Categories {
-			if cat.ParentID != nil {
-				parent := *cat.ParentID
-				childrenMap[parent] = append(childrenMap[parent], cat.ID)
-			}
-		}
-
-		toVisit := []uint{categoryId}
-		visited := make(map[uint]bool)
-		var allIDs []uint
-
-		for len(toVisit) > 0 {
-
-			n := len(toVisit) - 1
-			current := toVisit[n]
-			toVisit = toVisit[:n]
-
-			if visited[current] {
-				continue
-			}
-			visited[current] = true
-			allIDs = append(allIDs, current)
-
-			for _, childID := range childrenMap[current] {
-				if !visited[childID] {
-					toVisit = append(toVisit, childID)
-				}
-			}
-		}
-
 		var products []models.Product
 		if err := db.Where("category_id IN ?", allIDs).Find(&products).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
@@ -130,6 +261,7 @@ func FilterProductByCategoryID(db *gorm.DB) gin.HandlerFunc {
 }
 
 // filter product by brand (query based) ?brand=
+// superseded by GET /products?brand=, kept for older clients
 func FilterProductByBrand(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		brand := c.Query("brand")
@@ -158,6 +290,7 @@ func FilterProductByBrand(db *gorm.DB) gin.HandlerFunc {
 }
 
 //filter by price (query based) ?min_price=xx&,max_price=xx (tested)
+// superseded by GET /products?price_min=&price_max=, kept for older clients
 
 func FilterProductByPrice(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -169,9 +302,28 @@ func FilterProductByPrice(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		//prices are stored in minor units
+		query := db.Model(&models.Product{})
+		if priceMin != "" {
+			minPrice, err := models.ParseMoney(priceMin)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid price range"})
+				return
+			}
+			query = query.Where("price >= ?", minPrice)
+		}
+		if pricrMax != "" {
+			maxPrice, err := models.ParseMoney(pricrMax)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid price range"})
+				return
+			}
+			query = query.Where("price <= ?", maxPrice)
+		}
+
 		var products []models.Product
 
-		if err := db.Where("price >= ? AND price <= ?", priceMin, pricrMax).Find(&products).Error; err != nil {
+		if err := query.Find(&products).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
 			return
 		}
@@ -0,0 +1,236 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/services"
+	"github.com/junaid9001/spectr_backend/utils"
+	"gorm.io/gorm"
+)
+
+// add a variant to a product (admin)
+// multipart form: sku, options = {"size":"M"}, price (optional), stock_quantity, images (files)
+func CreateVariant(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		var product models.Product
+
+		if err := db.First(&product, productId).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "product not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		variant := models.ProductVariant{
+			ProductID: product.ID,
+			SKU:       strings.TrimSpace(c.PostForm("sku")),
+			IsActive:  true,
+		}
+
+		if variant.SKU == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "sku is required"})
+			return
+		}
+
+		if options := c.PostForm("options"); options != "" {
+			if err := json.Unmarshal([]byte(options), &variant.Options); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "options must be a json object of strings"})
+				return
+			}
+		}
+
+		if price := c.PostForm("price"); price != "" {
+			amount, err := models.ParseMoney(price)
+			if err != nil || amount < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid price"})
+				return
+			}
+			variant.Price = &amount
+		}
+
+		if stock := c.PostForm("stock_quantity"); stock != "" {
+			variant.StockQuantity, err = strconv.Atoi(stock)
+			if err != nil || variant.StockQuantity < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid stock_quantity"})
+				return
+			}
+		}
+
+		var images []*models.ProductImage
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&variant).Error; err != nil {
+				return err
+			}
+
+			//variant pictures join the product gallery, tagged with the variant
+			files := imageUploads(c)
+			if len(files) == 0 {
+				return nil
+			}
+
+			images, err = saveImageUploads(files, product.Name+" "+variant.SKU, &variant.ID)
+			if err != nil {
+				return err
+			}
+			if err := services.AddProductImages(tx, product.ID, images); err != nil {
+				return err
+			}
+
+			for _, img := range images {
+				variant.Images = append(variant.Images, *img)
+			}
+			return nil
+		})
+		if err != nil {
+			removeUploadedImages(images)
+
+			if errors.Is(err, gorm.ErrDuplicatedKey) || strings.Contains(err.Error(), "duplicate key") {
+				c.JSON(http.StatusConflict, gin.H{"status": "failed", "error": "sku already exists"})
+				return
+			}
+			respondImageError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"status": "success", "data": variant})
+	}
+}
+
+// struct for update variant
+type VariantUpdateInput struct {
+	SKU           *string           `json:"sku"`
+	Options       map[string]string `json:"options"`
+	Price         *models.Money     `json:"price"`
+	ClearPrice    bool              `json:"clear_price"` //go back to the product price
+	StockQuantity *int              `json:"stock_quantity"`
+	IsActive      *bool             `json:"is_active"`
+}
+
+//update variant by id (admin)
+
+func UpdateVariant(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		variantId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		var input VariantUpdateInput
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		var variant models.ProductVariant
+
+		if err := db.First(&variant, variantId).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "variant not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": "db error"})
+			return
+		}
+
+		if input.SKU != nil {
+			variant.SKU = strings.TrimSpace(*input.SKU)
+			if variant.SKU == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "sku can't be empty"})
+				return
+			}
+		}
+
+		if input.Options != nil {
+			variant.Options = input.Options
+		}
+
+		if input.ClearPrice {
+			variant.Price = nil
+		} else if input.Price != nil {
+			if *input.Price < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "price can't be less than zero"})
+				return
+			}
+			variant.Price = input.Price
+		}
+
+		if input.StockQuantity != nil {
+			if *input.StockQuantity < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "stock can't be less than zero"})
+				return
+			}
+			variant.StockQuantity = *input.StockQuantity
+		}
+
+		if input.IsActive != nil {
+			variant.IsActive = *input.IsActive
+		}
+
+		if err := db.Save(&variant).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) || strings.Contains(err.Error(), "duplicate key") {
+				c.JSON(http.StatusConflict, gin.H{"status": "failed", "error": "sku already exists"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": variant})
+	}
+}
+
+//soft delete variant by id (admin), order history keeps its sku, its pictures are removed
+
+func DeleteVariant(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		variantId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		var files []string
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var variant models.ProductVariant
+			if err := tx.First(&variant, variantId).Error; err != nil {
+				return err
+			}
+			if err := tx.Delete(&variant).Error; err != nil {
+				return err
+			}
+
+			files, err = services.DeleteVariantImages(tx, variant.ProductID, variant.ID)
+			return err
+		})
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": "variant not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		services.RemoveImageFiles(files...)
+
+		c.Status(http.StatusNoContent)
+	}
+}
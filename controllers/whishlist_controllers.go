@@ -6,6 +6,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/services"
 	"github.com/junaid9001/spectr_backend/utils"
 	"gorm.io/gorm"
 )
@@ -21,7 +22,8 @@ func AddToWishlist(db *gorm.DB) gin.HandlerFunc {
 		}
 
 		var input struct {
-			ProductId uint `json:"product_id" binding:"required"`
+			ProductId uint  `json:"product_id" binding:"required"`
+			VariantID *uint `json:"variant_id"` //optional, a product can be saved without picking one
 		}
 
 		if err := c.ShouldBindJSON(&input); err != nil {
@@ -40,9 +42,17 @@ func AddToWishlist(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		if input.VariantID != nil {
+			if _, err := services.ResolveVariant(db, &product, input.VariantID); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+				return
+			}
+		}
+		variantId := services.VariantKey(input.VariantID)
+
 		var checkAlreadyExists models.Wishlist
 		//if already in wish list
-		if err := db.Where("user_id=? AND product_id=?", userId, input.ProductId).
+		if err := db.Where("user_id=? AND product_id=? AND variant_id=?", userId, input.ProductId, variantId).
 			First(&checkAlreadyExists).Error; err == nil {
 			c.JSON(http.StatusConflict, gin.H{"status": "failed", "error": "product already in wishlist"})
 			return
@@ -55,6 +65,8 @@ func AddToWishlist(db *gorm.DB) gin.HandlerFunc {
 		usersWishlist := models.Wishlist{
 			UserId:    userId,
 			ProductId: product.ID,
+			VariantID: variantId,
+			Quantity:  1,
 		}
 
 		if err := db.Create(&usersWishlist).Error; err != nil {
@@ -103,7 +115,19 @@ func DeleteFromWishList(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		result := db.Unscoped().Where("user_id=? AND product_id=?", userId, ProdId).Delete(&models.Wishlist{})
+		query := db.Unscoped().Where("user_id=? AND product_id=?", userId, ProdId)
+
+		//?variant_id= removes only that variant, otherwise every entry of the product
+		if v := c.Query("variant_id"); v != "" {
+			variantId, err := utils.StringToUint(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid variant_id"})
+				return
+			}
+			query = query.Where("variant_id=?", variantId)
+		}
+
+		result := query.Delete(&models.Wishlist{})
 		if result.Error != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": result.Error.Error()})
 			return
@@ -117,3 +141,105 @@ func DeleteFromWishList(db *gorm.DB) gin.HandlerFunc {
 		c.Status(http.StatusNoContent)
 	}
 }
+
+//move a cart line to the wishlist (save for later)
+
+func SaveCartItemForLater(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cartItemId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		saved, err := services.SaveForLater(db, userId, cartItemId)
+		if err != nil {
+			respondSavedForLaterError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "data": saved})
+	}
+}
+
+//move a wishlist entry into the cart, body is optional
+
+func MoveWishlistItemToCart(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		wishlistId, err := utils.StringToUint(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": "invalid id format"})
+			return
+		}
+
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		var input struct {
+			VariantID *uint `json:"variant_id"`               //for entries saved without a variant
+			Quantity  int   `json:"quantity" binding:"gte=0"` //0 = the saved quantity
+		}
+
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&input); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+				return
+			}
+		}
+
+		reservation, err := services.MoveToCart(db, userId, wishlistId, input.VariantID, input.Quantity)
+		if err != nil {
+			respondSavedForLaterError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"status": "success", "reserved_until": reservation.ExpiresAt})
+	}
+}
+
+//add every wishlist entry to the cart, with what happened to each
+
+func AddWishlistToCart(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := utils.GetUserId(c)
+		if !ok {
+			return
+		}
+
+		results, err := services.AddWishlistToCart(db, userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+			return
+		}
+
+		added := 0
+		for _, result := range results {
+			if result.Status == services.WishlistAdded {
+				added++
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "added": added, "count": len(results), "data": results})
+	}
+}
+
+func respondSavedForLaterError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrCartItemNotFound) || errors.Is(err, services.ErrWishlistItemNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"status": "failed", "error": err.Error()})
+	case errors.Is(err, services.ErrInsufficientStock) || errors.Is(err, services.ErrVariantRequired) ||
+		errors.Is(err, services.ErrVariantNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "error": err.Error()})
+	case errors.Is(err, services.ErrProductUnavailable):
+		c.JSON(http.StatusGone, gin.H{"status": "failed", "error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "failed", "error": err.Error()})
+	}
+}
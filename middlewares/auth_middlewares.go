@@ -68,3 +68,22 @@ func AdminAuthMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// sets the user when a valid token is sent, anyone else goes through as a guest
+func OptionalUserAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+
+		if authHeader != "" {
+			tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
+
+			//an expired token shouldn't block public pages
+			if userId, role, err := utils.ValidateJwt(tokenStr); err == nil {
+				c.Set("userId", userId)
+				c.Set("role", role)
+			}
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// postal fields shared by saved addresses and the copy kept on orders
+type AddressSnapshot struct {
+	Name       string `gorm:"size:50" json:"name"`
+	Phone      string `gorm:"size:20" json:"phone"`
+	Line1      string `gorm:"size:255" json:"line1"`
+	Line2      string `gorm:"size:255" json:"line2"`
+	City       string `gorm:"size:100" json:"city"`
+	State      string `gorm:"size:100" json:"state"`
+	PostalCode string `gorm:"size:20" json:"postal_code"`
+	Country    string `gorm:"size:60" json:"country"`
+}
+
+// one line text version, used for the orders address column
+func (a AddressSnapshot) Format() string {
+	parts := []string{a.Name, a.Line1, a.Line2, a.City, a.State, a.PostalCode, a.Country, a.Phone}
+
+	nonEmpty := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	return strings.Join(nonEmpty, ", ")
+}
+
+// saved shipping address of a user
+type Address struct {
+	ID              uint `gorm:"primaryKey" json:"id"`
+	UserID          uint `gorm:"index;not null" json:"user_id"`
+	AddressSnapshot `gorm:"embedded"`
+	IsDefault       bool      `gorm:"default:false" json:"is_default"`
+	CreatedAt       time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt       time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+const (
+	CartChangePriceUp         = "price_up"
+	CartChangePriceDown       = "price_down"
+	CartChangeQuantityReduced = "quantity_reduced" //less stock left than the line asked for
+	CartChangeOutOfStock      = "out_of_stock"     //line removed, nothing left to sell
+	CartChangeRemoved         = "removed"          //line removed, product or variant no longer sold
+)
+
+// difference between a cart line and the current catalog, found when the cart is revalidated.
+// kept until the shopper accepts it at checkout, one row per line and kind (price / quantity / removal)
+type CartChange struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       uint      `gorm:"not null;default:0;index" json:"-"`
+	GuestCartID  uint      `gorm:"not null;default:0;index" json:"-"`
+	CartItemID   uint      `gorm:"not null;index" json:"cart_item_id"`
+	ProductID    uint      `gorm:"not null" json:"product_id"`
+	VariantID    *uint     `json:"variant_id,omitempty"`
+	ProductName  string    `gorm:"size:255" json:"product_name"`
+	Type         string    `gorm:"size:30;not null" json:"type"`
+	OldUnitPrice Money     `gorm:"not null;default:0" json:"old_unit_price"`
+	NewUnitPrice Money     `gorm:"not null;default:0" json:"new_unit_price"`
+	OldQuantity  int       `gorm:"not null;default:0" json:"old_quantity"`
+	NewQuantity  int       `gorm:"not null;default:0" json:"new_quantity"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -4,11 +4,14 @@ import "gorm.io/gorm"
 
 type CartItem struct {
 	gorm.Model
-	UserId     uint    `gorm:"not null;index" json:"user_id"`
-	ProductId  uint    `gorm:"not null;index" json:"product_id"`
-	Quantity   int     `gorm:"default:1" json:"quantity"`
-	UnitPrice  float64 `gorm:"type:decimal(10,2);not null" json:"unit_price"`
-	TotalPrice float64 `gorm:"type:decimal(10,2);not null" json:"total_price"`
-	Product    Product `gorm:"foreignKey:ProductId;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"product"`
+	UserId      uint            `gorm:"not null;index" json:"user_id"`                           //0 on guest carts
+	GuestCartID uint            `gorm:"not null;default:0;index" json:"guest_cart_id,omitempty"` //0 on user carts
+	ProductId   uint            `gorm:"not null;index" json:"product_id"`
+	VariantID   *uint           `gorm:"index" json:"variant_id"`
+	Quantity    int             `gorm:"default:1" json:"quantity"`
+	UnitPrice   Money           `gorm:"not null" json:"unit_price"`
+	TotalPrice  Money           `gorm:"not null" json:"total_price"`
+	Product     Product         `gorm:"foreignKey:ProductId;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"product"`
+	Variant     *ProductVariant `gorm:"foreignKey:VariantID;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"variant,omitempty"`
 	//clean cart if Pro or USe deleted
 }
@@ -1,7 +1,8 @@
 package models
 
 type Category struct {
-	ID           uint   `gorm:"primaryKey" json:"id"`
-	CategoryName string `gorm:"size:50;not null;unique" json:"category_name"`
-	ParentID     *uint  `gorm:"constraint:OnDelete:CASCADE" json:"parent_id"` //nil = root
+	ID               uint   `gorm:"primaryKey" json:"id"`
+	CategoryName     string `gorm:"size:50;not null;unique" json:"category_name"`
+	ParentID         *uint  `gorm:"constraint:OnDelete:CASCADE" json:"parent_id"` //nil = root
+	ReturnWindowDays *int   `json:"return_window_days"`                           //days after delivery, nil = default, 0 = not returnable
 }
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// coupon kinds
+const (
+	CouponTypePercentage   = "percentage"
+	CouponTypeFixed        = "fixed"
+	CouponTypeFreeShipping = "free_shipping"
+)
+
+// discount code, scope fields left empty = whole cart
+type Coupon struct {
+	ID           uint           `gorm:"primaryKey" json:"id"`
+	Code         string         `gorm:"size:40;not null;uniqueIndex" json:"code"`
+	Description  string         `gorm:"type:text" json:"description"`
+	Type         string         `gorm:"size:20;not null" json:"type"`
+	Value        Money          `gorm:"not null;default:0" json:"value"`        //fixed amount, or percent for percentage coupons in hundredths (stored 1000, sent as 10.00 = 10%)
+	MaxDiscount  Money          `gorm:"not null;default:0" json:"max_discount"` //cap for percentage, 0 = none
+	MinCartValue Money          `gorm:"not null;default:0" json:"min_cart_value"`
+	UsageLimit   int            `gorm:"not null;default:0" json:"usage_limit"`    //all users together, 0 = unlimited
+	PerUserLimit int            `gorm:"not null;default:0" json:"per_user_limit"` //0 = unlimited
+	UsedCount    int            `gorm:"not null;default:0" json:"used_count"`
+	StartsAt     *time.Time     `json:"starts_at"`
+	EndsAt       *time.Time     `json:"ends_at"`
+	IsActive     bool           `gorm:"default:true" json:"is_active"`
+	CategoryID   *uint          `json:"category_id"` //includes sub categories
+	ProductID    *uint          `json:"product_id"`
+	Brand        string         `gorm:"size:30" json:"brand"`
+	CreatedAt    time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// one use of a coupon on an order
+type CouponRedemption struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	CouponID       uint      `gorm:"index;not null" json:"coupon_id"`
+	UserID         uint      `gorm:"index;not null" json:"user_id"`
+	OrderID        uint      `gorm:"index;not null" json:"order_id"`
+	DiscountAmount Money     `gorm:"not null" json:"discount_amount"`
+	CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// coupon currently applied to a users cart
+type CartCoupon struct {
+	UserID    uint      `gorm:"primaryKey" json:"user_id"`
+	CouponID  uint      `gorm:"not null" json:"coupon_id"`
+	Coupon    Coupon    `gorm:"foreignKey:CouponID;constraint:OnDelete:CASCADE" json:"coupon"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
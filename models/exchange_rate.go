@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// 1 unit of BaseCurrency = Rate units of QuoteCurrency
+type ExchangeRate struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	BaseCurrency  string    `gorm:"size:3;not null;uniqueIndex:idx_currency_pair" json:"base_currency"`
+	QuoteCurrency string    `gorm:"size:3;not null;uniqueIndex:idx_currency_pair" json:"quote_currency"`
+	Rate          float64   `gorm:"type:decimal(18,8);not null" json:"rate"`
+	CreatedAt     time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
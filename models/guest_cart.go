@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// cart of a visitor who isn't logged in, its lines are CartItem rows with GuestCartID set
+// and the visitor holds it through the signed cart_token cookie
+type GuestCart struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime;index" json:"updated_at"` //last change, stale carts are removed
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// amount in minor units (paisa), Money(12345) = 123.45
+// stored as bigint, written to json as a plain decimal number
+type Money int64
+
+var ErrInvalidMoney = errors.New("invalid money amount")
+
+// store currency (CURRENCY, default INR)
+func DefaultCurrency() string {
+	if currency := strings.ToUpper(strings.TrimSpace(os.Getenv("CURRENCY"))); currency != "" {
+		return currency
+	}
+	return "INR"
+}
+
+// ISO 4217 currencies whose minor unit isn't a hundredth (JPY has none, KWD thousandths),
+// Money always has 2 decimals so amounts in them would be off by a factor of 10 or 100
+var nonCentCurrencies = map[string]bool{
+	"BIF": true, "CLP": true, "DJF": true, "GNF": true, "ISK": true, "JPY": true, "KMF": true,
+	"KRW": true, "PYG": true, "RWF": true, "UGX": true, "UYI": true, "VND": true, "VUV": true,
+	"XAF": true, "XOF": true, "XPF": true,
+	"BHD": true, "IQD": true, "JOD": true, "KWD": true, "LYD": true, "OMR": true, "TND": true,
+	"CLF": true, "UYW": true,
+}
+
+// whether amounts of the currency can be kept as Money
+func HasCentMinorUnit(code string) bool {
+	return !nonCentCurrencies[strings.ToUpper(code)]
+}
+
+// exact parse of "123", "123.4", "123.45", "-1.50", more than 2 decimals is rejected
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, ErrInvalidMoney
+	}
+
+	//one sign at most
+	negative := strings.HasPrefix(s, "-")
+	if negative || strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if (whole == "" && frac == "") || strings.ContainsAny(whole+frac, "+-") {
+		return 0, ErrInvalidMoney
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	if len(frac) > 2 {
+		return 0, fmt.Errorf("%w: at most 2 decimals", ErrInvalidMoney)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+
+	major, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidMoney
+	}
+	minor, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidMoney
+	}
+
+	amount := Money(major*100 + minor)
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
+
+func (m Money) Times(quantity int) Money {
+	return m * Money(quantity)
+}
+
+// percent of the amount rounded half away from zero, 12.5 = 12.5%
+func (m Money) Percent(percent float64) Money {
+	return Money(math.Round(float64(m) * percent / 100))
+}
+
+// amount in another currency, rate = units of the other currency per unit of this one
+func (m Money) Convert(rate float64) Money {
+	return Money(math.Round(float64(m) * rate))
+}
+
+// m * part / whole in integer math, rounded half up (amounts and parts are not negative)
+func (m Money) Share(part, whole int64) Money {
+	if whole == 0 {
+		return 0
+	}
+	return Money((int64(m)*part*2 + whole) / (whole * 2))
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// accepts 12.34 and "12.34"
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "null" {
+		return nil
+	}
+
+	amount, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = amount
+	return nil
+}
+
+// form and query binding
+func (m *Money) UnmarshalParam(param string) error {
+	amount, err := ParseMoney(param)
+	if err != nil {
+		return err
+	}
+	*m = amount
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return int64(m), nil
+}
+
+func (m *Money) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*m = 0
+	case int64:
+		*m = Money(v)
+	case []byte:
+		n, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidMoney, v)
+		}
+		*m = Money(n)
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidMoney, v)
+		}
+		*m = Money(n)
+	default:
+		return fmt.Errorf("%w: can't scan %T", ErrInvalidMoney, value)
+	}
+	return nil
+}
+
+// always a bigint column
+func (Money) GormDataType() string {
+	return "bigint"
+}
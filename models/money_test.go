@@ -0,0 +1,133 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want Money
+		err  bool
+	}{
+		{"0", 0, false},
+		{"123", 12300, false},
+		{"123.4", 12340, false},
+		{"123.45", 12345, false},
+		{"0.05", 5, false},
+		{".5", 50, false},
+		{" 7.10 ", 710, false},
+		{"-1.50", -150, false},
+		{"+2.00", 200, false},
+		{"", 0, true},
+		{"1.234", 0, true},
+		{"abc", 0, true},
+		{"1.x", 0, true},
+		{"1.-5", 0, true},
+		{"1.+5", 0, true},
+		{"--1", 0, true},
+		{"-+1", 0, true},
+		{"1-", 0, true},
+		{"-", 0, true},
+		{".", 0, true},
+		{"1.", 100, false},
+	} {
+		got, err := ParseMoney(tc.in)
+		if tc.err {
+			if !errors.Is(err, ErrInvalidMoney) {
+				t.Errorf("ParseMoney(%q) = %d, %v, want ErrInvalidMoney", tc.in, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("ParseMoney(%q) = %d, %v, want %d", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	for _, tc := range []struct {
+		in   Money
+		want string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{12345, "123.45"},
+		{100000, "1000.00"},
+		{-5, "-0.05"},
+		{-150, "-1.50"},
+	} {
+		if got := tc.in.String(); got != tc.want {
+			t.Errorf("Money(%d).String() = %q, want %q", tc.in, got, tc.want)
+		}
+
+		//what is written is read back the same
+		back, err := ParseMoney(tc.in.String())
+		if err != nil || back != tc.in {
+			t.Errorf("ParseMoney(%q) = %d, %v, want %d", tc.in.String(), back, err, tc.in)
+		}
+	}
+}
+
+func TestMoneyShare(t *testing.T) {
+	for _, tc := range []struct {
+		m           Money
+		part, whole int64
+		want        Money
+	}{
+		{1000, 1, 2, 500},
+		{1000, 1, 3, 333},
+		{1000, 2, 3, 667},          //666.67 rounds up
+		{5, 1, 2, 3},               //half rounds up
+		{12345, 1000, 10000, 1235}, //10% coupon value in hundredths
+		{12345, 0, 100, 0},
+		{12345, 100, 100, 12345},
+		{12345, 1, 0, 0}, //nothing to share by
+	} {
+		if got := tc.m.Share(tc.part, tc.whole); got != tc.want {
+			t.Errorf("Money(%d).Share(%d, %d) = %d, want %d", tc.m, tc.part, tc.whole, got, tc.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var body struct {
+		Price  Money `json:"price"`
+		Quoted Money `json:"quoted"`
+	}
+	if err := json.Unmarshal([]byte(`{"price": 499.99, "quoted": "12.5"}`), &body); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if body.Price != 49999 || body.Quoted != 1250 {
+		t.Fatalf("read %d and %d, want 49999 and 1250", body.Price, body.Quoted)
+	}
+
+	out, err := json.Marshal(body)
+	if err != nil || string(out) != `{"price":499.99,"quoted":12.50}` {
+		t.Fatalf("Marshal = %s, %v", out, err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"price": 1.999}`), &body); !errors.Is(err, ErrInvalidMoney) {
+		t.Fatalf("3 decimals = %v, want ErrInvalidMoney", err)
+	}
+}
+
+func TestHasCentMinorUnit(t *testing.T) {
+	for code, want := range map[string]bool{
+		"INR": true,
+		"USD": true,
+		"EUR": true,
+		"JPY": false,
+		"jpy": false,
+		"KRW": false,
+		"KWD": false,
+		"BHD": false,
+		"OMR": false,
+	} {
+		if got := HasCentMinorUnit(code); got != want {
+			t.Errorf("HasCentMinorUnit(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
@@ -7,13 +7,19 @@ import (
 )
 
 type OrderItem struct {
-	ID         uint           `gorm:"primaryKey" json:"id"`
-	OrderID    uint           `gorm:"index;not null" json:"order_id"`
-	ProductID  uint           `gorm:"index;not null" json:"product_id"`
-	UnitPrice  float64        `gorm:"not null" json:"unit_price"`
-	Quantity   int            `gorm:"not null" json:"quantity"`
-	TotalPrice float64        `gorm:"not null" json:"total_price"`
-	CreatedAt  time.Time      `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt  time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
-	DeletedAt  gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+	ID               uint           `gorm:"primaryKey" json:"id"`
+	OrderID          uint           `gorm:"index;not null" json:"order_id"`
+	ProductID        uint           `gorm:"index;not null" json:"product_id"`
+	VariantID        *uint          `gorm:"index" json:"variant_id"`
+	SKU              string         `gorm:"size:64" json:"sku"` //copied from the variant
+	UnitPrice        Money          `gorm:"not null" json:"unit_price"`
+	Quantity         int            `gorm:"not null" json:"quantity"`
+	RefundedQuantity int            `gorm:"not null;default:0" json:"refunded_quantity"`
+	ReturnedQuantity int            `gorm:"not null;default:0" json:"returned_quantity"` //received back through a return
+	TotalPrice       Money          `gorm:"not null" json:"total_price"`
+	DiscountAmount   Money          `gorm:"not null;default:0" json:"discount_amount"` //coupon share of this line
+	TaxAmount        Money          `gorm:"not null;default:0" json:"tax_amount"`
+	CreatedAt        time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt        time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"deleted_at"`
 }
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// order lifecycle states
+const (
+	OrderStatusPending        = "pending"
+	OrderStatusConfirmed      = "confirmed"
+	OrderStatusPacked         = "packed"
+	OrderStatusShipped        = "shipped"
+	OrderStatusOutForDelivery = "out_for_delivery"
+	OrderStatusDelivered      = "delivered"
+	OrderStatusCancelled      = "cancelled"
+	OrderStatusReturned       = "returned"
+)
+
+// one row per status change of an order (audit trail)
+type OrderStatusHistory struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	OrderID     uint      `gorm:"index;not null" json:"order_id"`
+	FromStatus  string    `gorm:"size:30" json:"from_status"` //empty for the first entry
+	ToStatus    string    `gorm:"size:30;not null" json:"to_status"`
+	ChangedBy   uint      `json:"changed_by"` //user id, 0 = system
+	ChangedRole string    `gorm:"size:10" json:"changed_role"`
+	Note        string    `gorm:"type:text" json:"note"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
@@ -7,15 +7,33 @@ import (
 )
 
 type Order struct {
-	ID            uint           `gorm:"primaryKey" json:"id"`
-	UserID        uint           `gorm:"not null" json:"user_id"`
-	TotalAmount   float64        `gorm:"not null" json:"total_amount"`
-	Address       string         `gorm:"type:text;not null" json:"address"`
-	Status        string         `gorm:"default:'pending';not null" json:"status"`
-	PaymentStatus string         `gorm:"size:30;default:'pending;not null" json:"payment_status"`
-	CreatedAt     time.Time      `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt     time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
-	DeletedAt     gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+	ID             uint            `gorm:"primaryKey" json:"id"`
+	UserID         uint            `gorm:"not null" json:"user_id"`
+	Subtotal       Money           `gorm:"not null;default:0" json:"subtotal"` //items before discount
+	DiscountAmount Money           `gorm:"not null;default:0" json:"discount_amount"`
+	CouponCode     string          `gorm:"size:40" json:"coupon_code"`
+	FreeShipping   bool            `gorm:"default:false" json:"free_shipping"`
+	TaxAmount      Money           `gorm:"not null;default:0" json:"tax_amount"`
+	ShippingFee    Money           `gorm:"not null;default:0" json:"shipping_fee"`
+	TotalAmount    Money           `gorm:"not null" json:"total_amount"` //subtotal - discount + tax + shipping
+	Currency       string          `gorm:"size:3;not null;default:'INR'" json:"currency"`
+	BaseCurrency   string          `gorm:"size:3;not null;default:'INR'" json:"base_currency"`         //store currency at order time
+	ExchangeRate   float64         `gorm:"type:decimal(18,8);not null;default:1" json:"exchange_rate"` //base -> currency, locked at PlaceOrder
+	Address        string          `gorm:"type:text;not null" json:"address"`
+	AddressID      *uint           `json:"address_id"` //saved address the snapshot was taken from
+	ShipTo         AddressSnapshot `gorm:"embedded;embeddedPrefix:ship_" json:"ship_to"`
+	Status         string          `gorm:"default:'pending';not null" json:"status"`
+	PaymentStatus  string          `gorm:"size:30;default:'pending;not null" json:"payment_status"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+	CreatedAt      time.Time       `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time       `gorm:"autoUpdateTime" json:"updated_at"`
+	DeletedAt      gorm.DeletedAt  `gorm:"index" json:"deleted_at"`
+
+	OrderNumber string `gorm:"size:20;not null;default:'';uniqueIndex:idx_order_number,where:order_number <> ''" json:"order_number"` //shown to customers
+	GuestEmail  string `gorm:"size:255;index" json:"guest_email,omitempty"`                                                           //set on guest orders, user_id stays 0 until claimed
+	LookupToken string `gorm:"size:64;index" json:"-"`                                                                                //sha256 of the lookup token mailed to a guest
+
 	//relation
-	OrderItems []OrderItem `gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE" json:"order_items"`
+	OrderItems    []OrderItem          `gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE" json:"order_items"`
+	StatusHistory []OrderStatusHistory `gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE" json:"status_history,omitempty"`
 }
@@ -7,11 +7,17 @@ import (
 )
 
 type Payment struct {
-	ID            uint    `gorm:"primaryKey" json:"id"`
-	OrderID       uint    `gorm:"index;not null" json:"order_id"`
-	Amount        float64 `gorm:"not null" json:"amount"`
-	PaymentStatus string  `gorm:"size:32;not null" json:"payment_status"`
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
-	DeletedAt     gorm.DeletedAt `gorm:"index"`
+	ID             uint           `gorm:"primaryKey" json:"id"`
+	OrderID        uint           `gorm:"index;not null" json:"order_id"`
+	Amount         Money          `gorm:"not null" json:"amount"`
+	Currency       string         `gorm:"size:3;not null;default:'INR'" json:"currency"`
+	RefundedAmount Money          `gorm:"not null;default:0" json:"refunded_amount"`
+	PaymentStatus  string         `gorm:"size:32;not null" json:"payment_status"`
+	Provider       string         `gorm:"size:30;not null;default:'mock'" json:"provider"`
+	ProviderRef    string         `gorm:"size:100;index" json:"provider_ref"` //id of the payment at the provider
+	Method         string         `gorm:"size:30" json:"method"`
+	FailureReason  string         `gorm:"type:text" json:"failure_reason"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
 }
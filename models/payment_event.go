@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// webhook event received from a payment provider, stored once per provider event id
+type PaymentEvent struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	Provider        string     `gorm:"size:30;not null;uniqueIndex:idx_provider_event" json:"provider"`
+	ProviderEventID string     `gorm:"size:100;not null;uniqueIndex:idx_provider_event" json:"provider_event_id"`
+	EventType       string     `gorm:"size:50;not null" json:"event_type"`
+	ProviderRef     string     `gorm:"size:100;index" json:"provider_ref"` //payment the event is about
+	Payload         string     `gorm:"type:text" json:"payload"`
+	FailureReason   string     `gorm:"type:text" json:"failure_reason,omitempty"`               //read from the payload when stored
+	Status          string     `gorm:"size:20;not null;default:'received';index" json:"status"` //received, processed, failed
+	Error           string     `gorm:"type:text" json:"error"`
+	Attempts        int        `gorm:"not null;default:0" json:"attempts"`
+	ProcessedAt     *time.Time `json:"processed_at"`
+	CreatedAt       time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt       time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// shipping rule kinds
+const (
+	ShippingRuleFlat     = "flat"
+	ShippingRuleWeight   = "weight"
+	ShippingRuleFreeOver = "free_over"
+)
+
+// tax percentage, empty category / country / state = applies to any
+type TaxRate struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Name       string    `gorm:"size:60;not null" json:"name"`
+	Rate       float64   `gorm:"type:decimal(6,3);not null" json:"rate"` //percent
+	CategoryID *uint     `gorm:"index" json:"category_id"`               //includes sub categories
+	Country    string    `gorm:"size:60" json:"country"`
+	State      string    `gorm:"size:100" json:"state"`
+	IsActive   bool      `gorm:"default:true" json:"is_active"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// how shipping is charged for a destination, empty country / state = anywhere
+type ShippingRule struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"size:60;not null" json:"name"`
+	Type      string    `gorm:"size:20;not null" json:"type"`
+	Amount    Money     `gorm:"not null;default:0" json:"amount"`    //flat fee, base fee for weight rules
+	PerKg     Money     `gorm:"not null;default:0" json:"per_kg"`    //weight rules
+	Threshold Money     `gorm:"not null;default:0" json:"threshold"` //free_over rules, after discount
+	Country   string    `gorm:"size:60" json:"country"`
+	State     string    `gorm:"size:100" json:"state"`
+	IsActive  bool      `gorm:"default:true" json:"is_active"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -1,15 +1,38 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"encoding/json"
+
+	"github.com/junaid9001/spectr_backend/storage"
+	"gorm.io/gorm"
+)
 
 type Product struct {
 	gorm.Model //adds id,Create,updated,deletedAt automatically
 
-	Name          string  `gorm:"size:255;not null" json:"name" binding:"required" form:"name"`
-	Description   string  `gorm:"type:text" json:"description" form:"description"`
-	Price         float64 `gorm:"type:decimal(10,2);not null" json:"price" binding:"required" form:"price" `
-	StockQuantity int     `gorm:"not null;default:0" json:"stock_quantity" binding:"required,gte=0" form:"stock_quantity"`
-	ImageUrl      string  `gorm:"type:text" json:"image"`
-	CategoryID    *uint   `gorm:"constraint:OnDelete:SET NULL;" json:"category_id" form:"category_id"`
-	Brand         string  `gorm:"size:30;default:'spectr';index" json:"brand" form:"brand"`
+	Name          string `gorm:"size:255;not null" json:"name" binding:"required" form:"name"`
+	Description   string `gorm:"type:text" json:"description" form:"description"`
+	Price         Money  `gorm:"not null" json:"price" binding:"required" form:"price" `
+	Currency      string `gorm:"size:3;not null;default:'INR'" json:"currency" form:"currency"` //base currency of price
+	StockQuantity int    `gorm:"not null;default:0" json:"stock_quantity" binding:"required,gte=0" form:"stock_quantity"`
+	ImageUrl      string `gorm:"type:text" json:"image" form:"-"` //storage key of the primary gallery image (medium size)
+	CategoryID    *uint  `gorm:"constraint:OnDelete:SET NULL;" json:"category_id" form:"category_id"`
+	Brand         string `gorm:"size:30;default:'spectr';index" json:"brand" form:"brand"`
+	WeightGrams   int    `gorm:"not null;default:0" json:"weight_grams" binding:"gte=0" form:"weight_grams"` //used for shipping
+
+	SoldCount     int     `gorm:"not null;default:0;index" json:"sold_count" form:"-"` //units sold, minus cancelled and returned ones
+	RatingAverage float64 `gorm:"not null;default:0" json:"rating_average" form:"-"`
+	RatingCount   int     `gorm:"not null;default:0" json:"rating_count" form:"-"`
+	ViewCount     int     `gorm:"not null;default:0;index" json:"view_count" form:"-"` //detail page views
+
+	Variants []ProductVariant `gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE" json:"variants,omitempty"`
+	Images   []ProductImage   `gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE" json:"images,omitempty"`
+}
+
+// image keys are sent as addresses of the configured storage
+func (p Product) MarshalJSON() ([]byte, error) {
+	type product Product
+	out := product(p)
+	out.ImageUrl = storage.URL(p.ImageUrl)
+	return json.Marshal(out)
 }
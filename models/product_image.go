@@ -0,0 +1,42 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/junaid9001/spectr_backend/storage"
+)
+
+// one picture in a product gallery, the upload plus its resized copies (storage keys)
+type ProductImage struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	ProductID uint      `gorm:"index;not null" json:"product_id"`
+	VariantID *uint     `gorm:"index;constraint:OnDelete:SET NULL;" json:"variant_id"` //picture of one variant, nil = whole product
+	Original  string    `gorm:"type:text;not null" json:"original"`
+	Thumbnail string    `gorm:"type:text" json:"thumbnail"` //150px
+	Medium    string    `gorm:"type:text" json:"medium"`    //600px
+	Large     string    `gorm:"type:text" json:"large"`     //1200px
+	MimeType  string    `gorm:"size:30" json:"mime_type"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	AltText   string    `gorm:"size:255" json:"alt_text"`
+	Position  int       `gorm:"not null;default:0" json:"position"` //gallery order, lowest first
+	IsPrimary bool      `gorm:"default:false" json:"is_primary"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// every file written for this image
+func (img *ProductImage) Files() []string {
+	return []string{img.Original, img.Thumbnail, img.Medium, img.Large}
+}
+
+func (img ProductImage) MarshalJSON() ([]byte, error) {
+	type productImage ProductImage
+	out := productImage(img)
+	out.Original = storage.URL(img.Original)
+	out.Thumbnail = storage.URL(img.Thumbnail)
+	out.Medium = storage.URL(img.Medium)
+	out.Large = storage.URL(img.Large)
+	return json.Marshal(out)
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// how two products relate, rebuilt by the recommendation job, stored in both directions
+type ProductPair struct {
+	ProductID      uint      `gorm:"primaryKey" json:"product_id"`
+	RelatedID      uint      `gorm:"primaryKey;index" json:"related_id"`
+	BoughtTogether int       `gorm:"not null;default:0" json:"bought_together"` //orders containing both
+	Similarity     float64   `gorm:"not null;default:0" json:"similarity"`      //shared category, brand and filter options
+	UpdatedAt      time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
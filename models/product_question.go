@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// question and answer states, hidden ones are only seen by admins
+const (
+	QuestionStatusPublished = "published"
+	QuestionStatusHidden    = "hidden"
+)
+
+// shopper question about a product, answered by the store or by customers who bought it
+type ProductQuestion struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	ProductID   uint            `gorm:"not null;index" json:"product_id"`
+	UserID      uint            `gorm:"not null;index" json:"user_id"`
+	Body        string          `gorm:"type:text;not null" json:"body"`
+	Status      string          `gorm:"size:20;not null;default:'published';index" json:"status"`
+	AnswerCount int             `gorm:"not null;default:0" json:"answer_count"` //published answers
+	CreatedAt   time.Time       `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time       `gorm:"autoUpdateTime" json:"updated_at"`
+	Answers     []ProductAnswer `gorm:"foreignKey:QuestionID;constraint:OnDelete:CASCADE" json:"answers"`
+}
+
+type ProductAnswer struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	QuestionID uint      `gorm:"not null;index" json:"question_id"`
+	UserID     uint      `gorm:"not null;index" json:"user_id"`
+	Body       string    `gorm:"type:text;not null" json:"body"`
+	ByStore    bool      `gorm:"not null;default:false" json:"by_store"` //written by an admin
+	Status     string    `gorm:"size:20;not null;default:'published';index" json:"status"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// one sellable version of a product (size M / red ...), has its own sku and stock
+// products that have variants are only sold through them
+type ProductVariant struct {
+	ID            uint              `gorm:"primaryKey" json:"id"`
+	ProductID     uint              `gorm:"index;not null" json:"product_id"`
+	SKU           string            `gorm:"size:64;not null;uniqueIndex" json:"sku"`
+	Options       map[string]string `gorm:"type:jsonb;serializer:json" json:"options"` //{"size":"M","colour":"red"}
+	Price         *Money            `json:"price"`                                     //nil = product price
+	StockQuantity int               `gorm:"not null;default:0" json:"stock_quantity"`
+	IsActive      bool              `gorm:"default:true" json:"is_active"`
+	CreatedAt     time.Time         `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time         `gorm:"autoUpdateTime" json:"updated_at"`
+	DeletedAt     gorm.DeletedAt    `gorm:"index" json:"-"`
+
+	Images []ProductImage `gorm:"foreignKey:VariantID" json:"images,omitempty"` //part of the product gallery
+}
+
+// price the variant sells for
+func (v *ProductVariant) EffectivePrice(product *Product) Money {
+	if v.Price != nil {
+		return *v.Price
+	}
+	return product.Price
+}
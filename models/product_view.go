@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// last time a viewer opened a product, one row per user (or anonymous session) and product
+type ProductView struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	ProductID uint      `gorm:"not null;index;uniqueIndex:idx_product_view_user,where:user_id IS NOT NULL;uniqueIndex:idx_product_view_session,where:session_id <> ''" json:"product_id"`
+	UserID    *uint     `gorm:"uniqueIndex:idx_product_view_user,priority:1,where:user_id IS NOT NULL" json:"user_id"`
+	SessionID string    `gorm:"size:64;not null;default:'';uniqueIndex:idx_product_view_session,priority:1,where:session_id <> ''" json:"-"` //viewer_session cookie of anonymous visitors
+	ViewedAt  time.Time `gorm:"not null;index" json:"viewed_at"`
+}
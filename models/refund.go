@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// money given back on a payment, whole order or some of its lines
+type Refund struct {
+	ID          uint         `gorm:"primaryKey" json:"id"`
+	OrderID     uint         `gorm:"index;not null" json:"order_id"`
+	PaymentID   uint         `gorm:"index;not null" json:"payment_id"`
+	Amount      Money        `gorm:"not null" json:"amount"`
+	Currency    string       `gorm:"size:3;not null;default:'INR'" json:"currency"`
+	Reason      string       `gorm:"type:text" json:"reason"`
+	Status      string       `gorm:"size:20;not null" json:"status"`
+	ProviderRef string       `gorm:"size:100" json:"provider_ref"`
+	CreatedBy   uint         `json:"created_by"` //admin id, 0 = automatic
+	CreatedAt   time.Time    `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time    `gorm:"autoUpdateTime" json:"updated_at"`
+	Items       []RefundItem `gorm:"foreignKey:RefundID;constraint:OnDelete:CASCADE" json:"items"`
+
+	//pending refunds are sent to the provider after the transaction that booked them
+	Attempts      int    `gorm:"not null;default:0" json:"attempts"`
+	FailureReason string `gorm:"type:text" json:"failure_reason,omitempty"`
+}
+
+// refunded units of one order line
+type RefundItem struct {
+	ID          uint  `gorm:"primaryKey" json:"id"`
+	RefundID    uint  `gorm:"index;not null" json:"refund_id"`
+	OrderItemID uint  `gorm:"index;not null" json:"order_item_id"`
+	Quantity    int   `gorm:"not null" json:"quantity"`
+	Amount      Money `gorm:"not null" json:"amount"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/junaid9001/spectr_backend/storage"
+)
+
+// return (rma) states
+const (
+	ReturnStatusRequested = "requested"
+	ReturnStatusApproved  = "approved"
+	ReturnStatusRejected  = "rejected"
+	ReturnStatusCompleted = "completed" //goods received, stock handled and refunded
+)
+
+// what happens with returned goods
+const (
+	ReturnResolutionRestock  = "restock"
+	ReturnResolutionWriteOff = "write_off"
+)
+
+// customer asking to send back items of a delivered order
+type ReturnRequest struct {
+	ID         uint         `gorm:"primaryKey" json:"id"`
+	OrderID    uint         `gorm:"index;not null" json:"order_id"`
+	UserID     uint         `gorm:"index;not null" json:"user_id"`
+	Status     string       `gorm:"size:20;not null;index" json:"status"`
+	Reason     string       `gorm:"type:text;not null" json:"reason"`
+	PhotoUrl   string       `gorm:"type:text" json:"photo"` //storage key, sent as a signed link
+	AdminNote  string       `gorm:"type:text" json:"admin_note"`
+	Resolution string       `gorm:"size:20" json:"resolution"`
+	RefundID   *uint        `json:"refund_id"`
+	ReceivedAt *time.Time   `json:"received_at"`
+	CreatedAt  time.Time    `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time    `gorm:"autoUpdateTime" json:"updated_at"`
+	Items      []ReturnItem `gorm:"foreignKey:ReturnRequestID;constraint:OnDelete:CASCADE" json:"items"`
+}
+
+// customer photos are private, the link works for an hour
+func (r ReturnRequest) MarshalJSON() ([]byte, error) {
+	type returnRequest ReturnRequest
+	out := returnRequest(r)
+	out.PhotoUrl = storage.SignedURL(r.PhotoUrl, time.Hour)
+	return json.Marshal(out)
+}
+
+// units of one order line that are being returned
+type ReturnItem struct {
+	ID              uint `gorm:"primaryKey" json:"id"`
+	ReturnRequestID uint `gorm:"index;not null" json:"return_request_id"`
+	OrderItemID     uint `gorm:"index;not null" json:"order_item_id"`
+	Quantity        int  `gorm:"not null" json:"quantity"`
+}
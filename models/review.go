@@ -0,0 +1,53 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/junaid9001/spectr_backend/storage"
+)
+
+// review states, only approved reviews are shown and counted in the product rating
+const (
+	ReviewStatusPending  = "pending" //new or edited, waiting for a moderator
+	ReviewStatusApproved = "approved"
+	ReviewStatusHidden   = "hidden"
+)
+
+// rating and opinion of a customer who received the product, one per user per product
+type Review struct {
+	ID           uint          `gorm:"primaryKey" json:"id"`
+	ProductID    uint          `gorm:"not null;uniqueIndex:idx_review_user_product;index" json:"product_id"`
+	UserID       uint          `gorm:"not null;uniqueIndex:idx_review_user_product" json:"user_id"`
+	Rating       int           `gorm:"not null;check:rating BETWEEN 1 AND 5" json:"rating"`
+	Title        string        `gorm:"size:120" json:"title"`
+	Body         string        `gorm:"type:text" json:"body"`
+	Status       string        `gorm:"size:20;not null;default:'pending';index" json:"status"`
+	AdminNote    string        `gorm:"type:text" json:"admin_note,omitempty"`
+	HelpfulCount int           `gorm:"not null;default:0" json:"helpful_count"`
+	CreatedAt    time.Time     `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time     `gorm:"autoUpdateTime" json:"updated_at"`
+	Photos       []ReviewPhoto `gorm:"foreignKey:ReviewID;constraint:OnDelete:CASCADE" json:"photos"`
+}
+
+type ReviewPhoto struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	ReviewID uint   `gorm:"index;not null" json:"review_id"`
+	Key      string `gorm:"type:text;not null" json:"url"` //storage key, sent as its address
+	Position int    `gorm:"not null;default:0" json:"position"`
+}
+
+func (p ReviewPhoto) MarshalJSON() ([]byte, error) {
+	type reviewPhoto ReviewPhoto
+	out := reviewPhoto(p)
+	out.Key = storage.URL(p.Key)
+	return json.Marshal(out)
+}
+
+// a user finding a review helpful, counted in Review.HelpfulCount
+type ReviewVote struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	ReviewID  uint      `gorm:"not null;uniqueIndex:idx_review_vote" json:"review_id"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_review_vote" json:"user_id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
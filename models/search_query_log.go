@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// one search made through GET /search, kept for the search report
+type SearchQueryLog struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Query       string    `gorm:"size:255;not null;index" json:"query"` //trimmed and lower case
+	ResultCount int64     `gorm:"not null;default:0" json:"result_count"`
+	CreatedAt   time.Time `gorm:"autoCreateTime;index" json:"created_at"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// time limited hold on product units while they sit in a cart / checkout
+type StockReservation struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	UserId      uint      `gorm:"not null;index:idx_reservation_owner_product,unique" json:"user_id"`                 //0 for guest carts
+	GuestCartID uint      `gorm:"not null;default:0;index:idx_reservation_owner_product,unique" json:"guest_cart_id"` //0 for users
+	ProductId   uint      `gorm:"not null;index:idx_reservation_owner_product,unique;index" json:"product_id"`
+	VariantId   uint      `gorm:"not null;default:0;index:idx_reservation_owner_product,unique" json:"variant_id"` //0 = product without variants
+	Quantity    int       `gorm:"not null" json:"quantity"`
+	ExpiresAt   time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -2,9 +2,14 @@ package models
 
 import "gorm.io/gorm"
 
+// VariantID is a key like StockReservation.VariantId, not an optional id like on cart and order
+// lines: it is part of the unique (user, product, variant) index and postgres treats NULLs as
+// distinct there, so "no variant" is stored as 0 to keep one entry per product
 type Wishlist struct {
 	gorm.Model
-	UserId    uint    `gorm:"not null;index:idx_user_product,unique" json:"user_id"`
-	ProductId uint    `gorm:"not null;index:idx_user_product,unique" json:"product_id"`
+	UserId    uint    `gorm:"not null;index:idx_user_product_variant,unique" json:"user_id"`
+	ProductId uint    `gorm:"not null;index:idx_user_product_variant,unique" json:"product_id"`
+	VariantID uint    `gorm:"not null;default:0;index:idx_user_product_variant,unique" json:"variant_id"` //0 = no variant picked
+	Quantity  int     `gorm:"not null;default:1" json:"quantity"`                                         //units to put back in the cart
 	Product   Product `gorm:"foreignKey:ProductId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"product"`
 }
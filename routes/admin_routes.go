@@ -30,12 +30,24 @@ func AdminRoutes(r *gin.Engine) {
 
 		admin.DELETE("/product/:id", controllers.DeleteProductByID(db))
 
+		//gallery
+		admin.POST("/product/:id/images", controllers.UploadProductImages(db))
+		admin.PUT("/product/:id/images/order", controllers.ReorderProductImages(db))
+		admin.PATCH("/product-images/:id", controllers.UpdateProductImage(db))
+		admin.DELETE("/product-images/:id", controllers.DeleteProductImage(db))
+
+		//variants (size, colour ...)
+		admin.POST("/product/:id/variants", controllers.CreateVariant(db))
+		admin.PUT("/variants/:id", controllers.UpdateVariant(db))
+		admin.DELETE("/variants/:id", controllers.DeleteVariant(db))
+
 		//product public
 
 		//done Postman
 		r.GET("/products", controllers.GetAllProducts(db))
 
-		r.GET("/product/:id", controllers.GetProductByID(db))
+		//logged-in users views are kept on their account
+		r.GET("/product/:id", middlewares.OptionalUserAuthMiddleware(), controllers.GetProductByID(db))
 	}
 
 	//orders related
@@ -43,6 +55,69 @@ func AdminRoutes(r *gin.Engine) {
 	{ //done postman
 		admin.GET("/orders", controllers.GetAllOrders(db))
 		admin.PATCH("/order/:id", controllers.UpdateOrderStatus(db))
+		admin.GET("/order/:id/history", controllers.GetOrderStatusHistory(db))
+		admin.POST("/order/:id/refunds", controllers.CreateRefund(db))
+		admin.GET("/order/:id/refunds", controllers.GetOrderRefunds(db))
+	}
+
+	//returns
+	{
+		admin.GET("/returns", controllers.GetAllReturns(db))
+		admin.PATCH("/returns/:id/review", controllers.ReviewReturnRequest(db))
+		admin.PATCH("/returns/:id/receive", controllers.ReceiveReturn(db))
+	}
+
+	//coupons
+	{
+		admin.POST("/coupons", controllers.CreateCoupon(db))
+		admin.GET("/coupons", controllers.GetAllCoupons(db))
+		admin.PUT("/coupons/:id", controllers.UpdateCoupon(db))
+		admin.DELETE("/coupons/:id", controllers.DeleteCoupon(db))
+	}
+
+	//tax and shipping
+	{
+		admin.POST("/tax-rates", controllers.CreateTaxRate(db))
+		admin.GET("/tax-rates", controllers.GetTaxRates(db))
+		admin.PUT("/tax-rates/:id", controllers.UpdateTaxRate(db))
+		admin.DELETE("/tax-rates/:id", controllers.DeleteTaxRate(db))
+		admin.POST("/shipping-rules", controllers.CreateShippingRule(db))
+		admin.GET("/shipping-rules", controllers.GetShippingRules(db))
+		admin.PUT("/shipping-rules/:id", controllers.UpdateShippingRule(db))
+		admin.DELETE("/shipping-rules/:id", controllers.DeleteShippingRule(db))
+	}
+
+	//exchange rates
+	{
+		admin.POST("/exchange-rates", controllers.SaveExchangeRate(db))
+		admin.POST("/exchange-rates/import", controllers.ImportExchangeRates(db))
+		admin.GET("/exchange-rates", controllers.GetExchangeRates(db))
+		admin.DELETE("/exchange-rates/:id", controllers.DeleteExchangeRate(db))
+	}
+
+	//payment webhooks
+	{
+		admin.GET("/payment-events", controllers.GetPaymentEvents(db))
+		admin.POST("/payment-events/:id/retry", controllers.RetryPaymentEvent(db))
+	}
+
+	//review moderation
+	{
+		admin.GET("/reviews", controllers.GetAllReviews(db))
+		admin.PATCH("/reviews/:id", controllers.ModerateReview(db))
+	}
+
+	//product questions
+	{
+		admin.GET("/questions", controllers.GetAllQuestions(db))
+		admin.POST("/questions/:id/answers", controllers.AnswerQuestion(db, "admin"))
+		admin.PATCH("/questions/:id", controllers.ModerateQuestion(db))
+		admin.PATCH("/answers/:id", controllers.ModerateAnswer(db))
+	}
+
+	//reports
+	{
+		admin.GET("/reports/search", controllers.SearchQueryReport(db))
 	}
 
 	//category related
@@ -4,12 +4,39 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/junaid9001/spectr_backend/config"
 	"github.com/junaid9001/spectr_backend/controllers"
+	"github.com/junaid9001/spectr_backend/middlewares"
 )
 
 func PublicRoutes(r *gin.Engine) {
 	db := config.DB
 	r.GET("/search", controllers.SearchProduct(db))
+	r.GET("/search/suggest", controllers.SearchSuggestions())
+	r.GET("/product/:id/reviews", controllers.GetProductReviews(db))
+	r.GET("/product/:id/questions", controllers.GetProductQuestions(db))
+	r.GET("/product/:id/related", controllers.RelatedProducts(db))
+	r.GET("/product/:id/bought-together", controllers.BoughtTogether(db))
 	r.GET("/products/filter-by-category_id", controllers.FilterProductByCategoryID(db))
 	r.GET("/products/filter-by-brand", controllers.FilterProductByBrand(db))
 	r.GET("/products/filter-by-price", controllers.FilterProductByPrice(db))
+
+	//cart of a guest (cart_token cookie) or, with a token, of the user
+	cart := r.Group("/cart")
+	cart.Use(middlewares.OptionalUserAuthMiddleware())
+	{
+		cart.POST("", controllers.AddProductToCart(db))
+		cart.GET("", controllers.GetUserCart(db))
+		cart.PATCH("/:id", controllers.UpdateQuantityInCartByID(db))
+		cart.DELETE("/:id", controllers.DeleteCartItemByID(db))
+	}
+
+	//guest checkout, guests follow their order with the mailed lookup token
+	{
+		r.POST("/orders/guest", controllers.PlaceGuestOrder(db))
+		r.GET("/orders/lookup", controllers.LookupOrder(db))
+		r.POST("/orders/lookup/payments", controllers.CreateGuestPayment(db))
+		r.POST("/orders/lookup/payments/:payment_id/confirm", controllers.ConfirmGuestPayment(db))
+	}
+
+	//signed links of the local file storage
+	r.GET("/files/*key", controllers.ServeSignedFile())
 }
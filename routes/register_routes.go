@@ -10,4 +10,5 @@ func RegisterRoutes(r *gin.Engine) {
 	AdminRoutes(r)
 	PublicRoutes(r)
 	ViewRoutes(r)
+	WebhookRoutes(r)
 }
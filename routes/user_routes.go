@@ -18,17 +18,31 @@ func UserRoutes(r *gin.Engine) {
 	user.GET("/profile", controllers.GetUserProfile(db))
 	user.PUT("/profile", controllers.UpdateUserProfile(db))
 
+	{ //saved addresses
+		user.POST("/addresses", controllers.CreateAddress(db))
+		user.GET("/addresses", controllers.GetAddresses(db))
+		user.PUT("/addresses/:id", controllers.UpdateAddress(db))
+		user.PATCH("/addresses/:id/default", controllers.SetDefaultAddress(db))
+		user.DELETE("/addresses/:id", controllers.DeleteAddress(db))
+	}
+
 	{ //user cart related (done) postman
 		user.POST("/cart", controllers.AddProductToCart(db))
 		user.GET("/cart", controllers.GetUserCart(db))
 		user.PATCH("/cart/:id", controllers.UpdateQuantityInCartByID(db))
 		user.DELETE("/cart/:id", controllers.DeleteCartItemByID(db))
+		user.POST("/cart/coupon", controllers.ApplyCartCoupon(db))
+		user.DELETE("/cart/coupon", controllers.RemoveCartCoupon(db))
+		user.POST("/cart/:id/save-for-later", controllers.SaveCartItemForLater(db))
+		user.POST("/checkout", controllers.StartCheckout(db))
 	}
 
 	{ //wishlist related (done) postman
 		user.POST("/wishlist", controllers.AddToWishlist(db))
 		user.GET("/wishlist", controllers.GetWishList(db))
 		user.DELETE("/wishlist/:product_id", controllers.DeleteFromWishList(db))
+		user.POST("/wishlist/:id/move-to-cart", controllers.MoveWishlistItemToCart(db))
+		user.POST("/wishlist/cart", controllers.AddWishlistToCart(db))
 	}
 
 	{ //order related (done) postman
@@ -37,9 +51,31 @@ func UserRoutes(r *gin.Engine) {
 		user.GET("/order/:id", controllers.GetDetailsOfOrder(db))
 		user.DELETE("/order/:id", controllers.DeleteOrderById(db))
 		user.PATCH("/order/:id/cancel", controllers.CancelOrderAndRestock(db))
+		user.POST("/order/:id/returns", controllers.CreateReturnRequest(db))
+		user.GET("/returns", controllers.GetUserReturns(db))
+		user.POST("/orders/claim", controllers.ClaimGuestOrder(db))
+
+	}
 
+	{ //reviews of delivered products
+		user.POST("/products/:id/reviews", controllers.CreateReview(db))
+		user.GET("/reviews", controllers.GetUserReviews(db))
+		user.PUT("/reviews/:id", controllers.UpdateReview(db))
+		user.DELETE("/reviews/:id", controllers.DeleteReview(db))
+		user.POST("/reviews/:id/helpful", controllers.VoteReviewHelpful(db, true))
+		user.DELETE("/reviews/:id/helpful", controllers.VoteReviewHelpful(db, false))
 	}
 
+	{ //product questions, answers only from customers who received the product
+		user.POST("/products/:id/questions", controllers.AskQuestion(db))
+		user.GET("/questions", controllers.GetUserQuestions(db))
+		user.POST("/questions/:id/answers", controllers.AnswerQuestion(db, "user"))
+	}
+
+	//picked from orders and wishlist
+	user.GET("/recommendations", controllers.UserRecommendations(db))
+	user.GET("/recently-viewed", controllers.RecentlyViewed(db))
+
 	{ //payment
 
 		user.POST("/order/:id/payments", controllers.CreatePayment(db))
@@ -23,7 +23,14 @@ func ViewRoutes(r *gin.Engine) {
 		var stats models.AppStats
 		db.First(&stats, 1)
 
-		c.HTML(http.StatusOK, "dashboard.html", gin.H{"data": stats})
+		//detail page views, all time
+		var totalViews int64
+		db.Model(&models.Product{}).Select("COALESCE(SUM(view_count), 0)").Scan(&totalViews)
+
+		mostViewed := []models.Product{}
+		db.Where("view_count > 0").Order("view_count DESC, id DESC").Limit(10).Find(&mostViewed)
+
+		c.HTML(http.StatusOK, "dashboard.html", gin.H{"data": stats, "total_views": totalViews, "most_viewed": mostViewed})
 	})
 
 	//users
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/junaid9001/spectr_backend/config"
+	"github.com/junaid9001/spectr_backend/controllers"
+)
+
+// called by third parties, requests are verified by signature not jwt
+func WebhookRoutes(r *gin.Engine) {
+	db := config.DB
+	webhooks := r.Group("/webhooks")
+
+	webhooks.POST("/payments", controllers.PaymentWebhook(db))
+}
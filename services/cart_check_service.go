@@ -0,0 +1,244 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrCartChanged = errors.New("cart changed, review the changes and place the order again with their ids in accepted_changes")
+
+// bring every line of the owners cart in line with the catalog: the current price, what other
+// shoppers left to hold and whether the product (variant) is still sold. lines are updated or
+// removed and every difference is kept as a pending CartChange
+func RevalidateCart(db *gorm.DB, owner CartOwner) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var items []models.CartItem
+		if err := owner.Scope(tx).Order("id ASC").Find(&items).Error; err != nil {
+			return err
+		}
+
+		for i := range items {
+			if err := revalidateCartLine(tx, owner, &items[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func revalidateCartLine(tx *gorm.DB, owner CartOwner, item *models.CartItem) error {
+	//soft deleted products are no longer sold
+	var product models.Product
+	err := tx.Unscoped().First(&product, item.ProductId).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if err != nil || product.DeletedAt.Valid {
+		return dropCartLine(tx, owner, item, product.Name, models.CartChangeRemoved)
+	}
+
+	variant, err := ResolveVariant(tx, &product, item.VariantID)
+	if err != nil {
+		if errors.Is(err, ErrVariantRequired) || errors.Is(err, ErrVariantNotFound) {
+			return dropCartLine(tx, owner, item, product.Name, models.CartChangeRemoved)
+		}
+		return err
+	}
+
+	variantId := VariantKey(item.VariantID)
+
+	//locked so the hold below can't fail on a concurrent order
+	stock, err := lockStock(tx, product.ID, variantId)
+	if err != nil {
+		return err
+	}
+
+	heldByOthers, err := ReservedQuantity(tx, product.ID, variantId, owner)
+	if err != nil {
+		return err
+	}
+
+	left := stock - heldByOthers
+	if left <= 0 {
+		return dropCartLine(tx, owner, item, product.Name, models.CartChangeOutOfStock)
+	}
+
+	unitPrice, err := StorePrice(tx, &product, variant)
+	if err != nil {
+		return err
+	}
+
+	quantity := item.Quantity
+	if left < quantity {
+		quantity = left
+
+		if _, err := ReserveStock(tx, owner, product.ID, variantId, quantity); err != nil {
+			return err
+		}
+		if err := noteQuantityChange(tx, owner, item, product.Name, quantity); err != nil {
+			return err
+		}
+	}
+
+	if unitPrice != item.UnitPrice {
+		if err := notePriceChange(tx, owner, item, product.Name, unitPrice); err != nil {
+			return err
+		}
+	}
+
+	if quantity == item.Quantity && unitPrice == item.UnitPrice {
+		return nil
+	}
+
+	return tx.Model(item).Updates(map[string]interface{}{
+		"quantity":    quantity,
+		"unit_price":  unitPrice,
+		"total_price": unitPrice.Times(quantity),
+	}).Error
+}
+
+// line kept as it was last accepted, the start of a new pending change
+func newCartChange(owner CartOwner, item *models.CartItem, name, changeType string) models.CartChange {
+	return models.CartChange{
+		UserID:       owner.UserID,
+		GuestCartID:  owner.GuestCartID,
+		CartItemID:   item.ID,
+		ProductID:    item.ProductId,
+		VariantID:    item.VariantID,
+		ProductName:  name,
+		Type:         changeType,
+		OldUnitPrice: item.UnitPrice,
+		NewUnitPrice: item.UnitPrice,
+		OldQuantity:  item.Quantity,
+		NewQuantity:  item.Quantity,
+	}
+}
+
+// pending change of a line, merged so it shows what changed since the shopper last accepted
+func pendingCartChange(tx *gorm.DB, owner CartOwner, item *models.CartItem, types ...string) (*models.CartChange, error) {
+	var change models.CartChange
+	err := owner.Scope(tx).Where("cart_item_id=? AND type IN ?", item.ID, types).First(&change).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &change, nil
+}
+
+func notePriceChange(tx *gorm.DB, owner CartOwner, item *models.CartItem, name string, unitPrice models.Money) error {
+	change, err := pendingCartChange(tx, owner, item, models.CartChangePriceUp, models.CartChangePriceDown)
+	if err != nil {
+		return err
+	}
+	if change == nil {
+		created := newCartChange(owner, item, name, "")
+		change = &created
+	}
+
+	change.NewUnitPrice = unitPrice
+
+	switch {
+	case change.NewUnitPrice == change.OldUnitPrice:
+		//back at the accepted price, nothing to show
+		if change.ID == 0 {
+			return nil
+		}
+		return tx.Delete(change).Error
+	case change.NewUnitPrice > change.OldUnitPrice:
+		change.Type = models.CartChangePriceUp
+	default:
+		change.Type = models.CartChangePriceDown
+	}
+
+	return replaceCartChange(tx, change)
+}
+
+func noteQuantityChange(tx *gorm.DB, owner CartOwner, item *models.CartItem, name string, quantity int) error {
+	change, err := pendingCartChange(tx, owner, item, models.CartChangeQuantityReduced)
+	if err != nil {
+		return err
+	}
+	if change == nil {
+		created := newCartChange(owner, item, name, models.CartChangeQuantityReduced)
+		change = &created
+	}
+
+	change.NewQuantity = quantity
+	return replaceCartChange(tx, change)
+}
+
+// a change the shopper may have seen is never edited, the merged one is stored under a new id
+// so accepted ids always stand for what was shown
+func replaceCartChange(tx *gorm.DB, change *models.CartChange) error {
+	if change.ID != 0 {
+		if err := tx.Delete(change).Error; err != nil {
+			return err
+		}
+		change.ID = 0
+		change.CreatedAt = time.Time{}
+	}
+	return tx.Create(change).Error
+}
+
+// drop a line that can't be bought anymore and give its held units back, the removal replaces
+// other pending changes of the line
+func dropCartLine(tx *gorm.DB, owner CartOwner, item *models.CartItem, name, changeType string) error {
+	if err := RemoveCartLine(tx, owner, item); err != nil {
+		return err
+	}
+
+	change := newCartChange(owner, item, name, changeType)
+	change.NewUnitPrice = 0
+	change.NewQuantity = 0
+	return tx.Create(&change).Error
+}
+
+// changes the shopper hasn't accepted yet, oldest first
+func PendingCartChanges(db *gorm.DB, owner CartOwner) ([]models.CartChange, error) {
+	changes := []models.CartChange{}
+	if err := owner.Scope(db).Order("id ASC").Find(&changes).Error; err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// true when accepted holds the ids of exactly the pending changes, in any order
+func CartChangesAccepted(changes []models.CartChange, accepted []uint) bool {
+	pending := make(map[uint]bool, len(changes))
+	for _, change := range changes {
+		pending[change.ID] = true
+	}
+
+	seen := make(map[uint]bool, len(accepted))
+	for _, id := range accepted {
+		if !pending[id] {
+			return false
+		}
+		seen[id] = true
+	}
+	return len(seen) == len(pending)
+}
+
+// the shopper saw the changes with these ids, clear them. ErrCartChanged when one of them is gone
+// or another change came up since they were shown
+func AcceptCartChanges(tx *gorm.DB, owner CartOwner, accepted []uint) error {
+	var changes []models.CartChange
+	if err := owner.Scope(tx).Clauses(clause.Locking{Strength: "UPDATE"}).Find(&changes).Error; err != nil {
+		return err
+	}
+	if !CartChangesAccepted(changes, accepted) {
+		return ErrCartChanged
+	}
+	return ClearCartChanges(tx, owner)
+}
+
+// drop every pending change of the owner
+func ClearCartChanges(tx *gorm.DB, owner CartOwner) error {
+	return owner.Scope(tx).Delete(&models.CartChange{}).Error
+}
@@ -0,0 +1,376 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidCartToken = errors.New("invalid cart token")
+
+// guest carts untouched for this long are removed, also the lifetime of the cart_token cookie
+const GuestCartTTL = 30 * 24 * time.Hour
+
+// whose cart lines and stock holds these are, a user or a guest cart (the other id is 0)
+type CartOwner struct {
+	UserID      uint
+	GuestCartID uint
+}
+
+func UserCart(userId uint) CartOwner {
+	return CartOwner{UserID: userId}
+}
+
+func GuestCartOwner(guestCartId uint) CartOwner {
+	return CartOwner{GuestCartID: guestCartId}
+}
+
+func (o CartOwner) IsGuest() bool {
+	return o.UserID == 0
+}
+
+// rows of the owner, for cart_items and stock_reservations
+func (o CartOwner) Scope(db *gorm.DB) *gorm.DB {
+	return db.Where("user_id=? AND guest_cart_id=?", o.UserID, o.GuestCartID)
+}
+
+// CART_TOKEN_SECRET, falling back to JWT_SECRETKEY
+func cartTokenKey() []byte {
+	key := os.Getenv("CART_TOKEN_SECRET")
+	if key == "" {
+		key = os.Getenv("JWT_SECRETKEY")
+	}
+	return []byte(key)
+}
+
+func cartTokenSignature(guestCartId uint) string {
+	mac := hmac.New(sha256.New, cartTokenKey())
+	mac.Write([]byte("guest-cart:" + strconv.FormatUint(uint64(guestCartId), 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// "<guest cart id>.<hmac>", so visitors can't pick another cart by changing the id
+func CartToken(guestCartId uint) string {
+	return strconv.FormatUint(uint64(guestCartId), 10) + "." + cartTokenSignature(guestCartId)
+}
+
+func ParseCartToken(token string) (uint, error) {
+	rawId, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, ErrInvalidCartToken
+	}
+
+	id, err := strconv.ParseUint(rawId, 10, 64)
+	if err != nil || id == 0 {
+		return 0, ErrInvalidCartToken
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(cartTokenSignature(uint(id)))) {
+		return 0, ErrInvalidCartToken
+	}
+	return uint(id), nil
+}
+
+// the guest cart of a token, ErrInvalidCartToken for forged tokens and removed carts
+func FindGuestCart(db *gorm.DB, token string) (*models.GuestCart, error) {
+	id, err := ParseCartToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var cart models.GuestCart
+	if err := db.First(&cart, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidCartToken
+		}
+		return nil, err
+	}
+	return &cart, nil
+}
+
+func CreateGuestCart(db *gorm.DB) (*models.GuestCart, error) {
+	cart := models.GuestCart{}
+	if err := db.Create(&cart).Error; err != nil {
+		return nil, err
+	}
+	return &cart, nil
+}
+
+// keep a guest cart that is still in use from being removed as stale
+func TouchGuestCart(db *gorm.DB, owner CartOwner) error {
+	if !owner.IsGuest() {
+		return nil
+	}
+	return db.Model(&models.GuestCart{}).Where("id=?", owner.GuestCartID).
+		UpdateColumn("updated_at", time.Now()).Error
+}
+
+// move a guest cart into the users cart, quantities of the same product (variant) are summed and
+// capped by the stock other shoppers don't hold, lines of removed products are dropped
+func MergeGuestCart(db *gorm.DB, guestCartId, userId uint) error {
+	guest := GuestCartOwner(guestCartId)
+	user := UserCart(userId)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var items []models.CartItem
+		if err := guest.Scope(tx).Order("id ASC").Find(&items).Error; err != nil {
+			return err
+		}
+
+		//the guests holds go first so they don't count against the user
+		if err := ReleaseCartReservations(tx, guest); err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			if err := mergeCartLine(tx, user, item); err != nil {
+				return err
+			}
+		}
+
+		if err := guest.Scope(tx.Unscoped()).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		//pending changes went with the guest lines, merged lines are checked again in the users cart
+		if err := ClearCartChanges(tx, guest); err != nil {
+			return err
+		}
+		return tx.Delete(&models.GuestCart{}, guestCartId).Error
+	})
+}
+
+// add units of a product (variant) to the owners cart, the whole line is held and priced at the
+// current store price. a line that was priced differently gets a pending price change, so the
+// shopper sees it before ordering
+func AddCartLine(tx *gorm.DB, owner CartOwner, product *models.Product, variant *models.ProductVariant, quantity int) (*models.StockReservation, error) {
+	var variantId *uint
+	if variant != nil {
+		variantId = &variant.ID
+	}
+
+	//the stock row is locked before the line is read, so two adds of the same product (variant)
+	//queue up and the second one sees the line of the first instead of inserting another
+	if _, err := lockStock(tx, product.ID, VariantKey(variantId)); err != nil {
+		return nil, err
+	}
+
+	//the product (variant) may already be in the cart, then the quantity grows
+	var item models.CartItem
+	err := owner.Scope(tx).Scopes(WhereVariant(variantId)).
+		Where("product_id=?", product.ID).First(&item).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	newQuantity := item.Quantity + quantity
+
+	//carts are kept in the store currency
+	unitPrice, err := StorePrice(tx, product, variant)
+	if err != nil {
+		return nil, err
+	}
+
+	reservation, err := ReserveStock(tx, owner, product.ID, VariantKey(variantId), newQuantity)
+	if err != nil {
+		return nil, err
+	}
+
+	if item.ID == 0 {
+		item = models.CartItem{
+			UserId:      owner.UserID,
+			GuestCartID: owner.GuestCartID,
+			ProductId:   product.ID,
+			VariantID:   variantId,
+		}
+	} else if item.UnitPrice != unitPrice {
+		if err := notePriceChange(tx, owner, &item, product.Name, unitPrice); err != nil {
+			return nil, err
+		}
+	}
+
+	item.Quantity = newQuantity
+	item.UnitPrice = unitPrice
+	item.TotalPrice = unitPrice.Times(newQuantity)
+
+	if err := tx.Omit("Product", "Variant").Save(&item).Error; err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
+
+// set the quantity of a cart line, the hold is resized and the line priced at the current store
+// price, a changed price is kept as a pending change like in AddCartLine
+func SetCartLineQuantity(tx *gorm.DB, owner CartOwner, item *models.CartItem, product *models.Product, variant *models.ProductVariant, quantity int) error {
+	unitPrice, err := StorePrice(tx, product, variant)
+	if err != nil {
+		return err
+	}
+
+	if _, err := ReserveStock(tx, owner, product.ID, VariantKey(item.VariantID), quantity); err != nil {
+		return err
+	}
+
+	if item.UnitPrice != unitPrice {
+		if err := notePriceChange(tx, owner, item, product.Name, unitPrice); err != nil {
+			return err
+		}
+	}
+
+	return tx.Model(item).Updates(map[string]interface{}{
+		"quantity":    quantity,
+		"unit_price":  unitPrice,
+		"total_price": unitPrice.Times(quantity),
+	}).Error
+}
+
+// units of a product (variant) the owner can still add: stock not held by others minus the
+// owners own line. the stock row stays locked for the rest of the transaction
+func CartStockLeft(tx *gorm.DB, owner CartOwner, productId uint, variantId *uint) (int, error) {
+	stock, err := lockStock(tx, productId, VariantKey(variantId))
+	if err != nil {
+		return 0, err
+	}
+
+	heldByOthers, err := ReservedQuantity(tx, productId, VariantKey(variantId), owner)
+	if err != nil {
+		return 0, err
+	}
+
+	var inCart int
+	if err := owner.Scope(tx.Model(&models.CartItem{})).Scopes(WhereVariant(variantId)).
+		Where("product_id=?", productId).Select("COALESCE(SUM(quantity), 0)").Scan(&inCart).Error; err != nil {
+		return 0, err
+	}
+
+	left := stock - heldByOthers - inCart
+	if left < 0 {
+		left = 0
+	}
+	return left, nil
+}
+
+// take a line out of the owners cart, its pending changes go with it and its held units are
+// given back
+func RemoveCartLine(tx *gorm.DB, owner CartOwner, item *models.CartItem) error {
+	if err := tx.Delete(item).Error; err != nil {
+		return err
+	}
+
+	if err := owner.Scope(tx).Where("cart_item_id=?", item.ID).Delete(&models.CartChange{}).Error; err != nil {
+		return err
+	}
+
+	return ReleaseReservation(tx, owner, item.ProductId, VariantKey(item.VariantID))
+}
+
+func mergeCartLine(tx *gorm.DB, owner CartOwner, guestItem models.CartItem) error {
+	var product models.Product
+	if err := tx.First(&product, guestItem.ProductId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	variant, err := ResolveVariant(tx, &product, guestItem.VariantID)
+	if err != nil {
+		if errors.Is(err, ErrVariantRequired) || errors.Is(err, ErrVariantNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	variantId := VariantKey(guestItem.VariantID)
+
+	//locked before the users line is read, like in AddCartLine
+	stock, err := lockStock(tx, product.ID, variantId)
+	if err != nil {
+		return err
+	}
+
+	var item models.CartItem
+	err = owner.Scope(tx).Scopes(WhereVariant(guestItem.VariantID)).
+		Where("product_id=?", product.ID).First(&item).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	heldByOthers, err := ReservedQuantity(tx, product.ID, variantId, owner)
+	if err != nil {
+		return err
+	}
+
+	quantity := item.Quantity + guestItem.Quantity
+	if left := stock - heldByOthers; quantity > left {
+		quantity = left
+	}
+
+	//nothing more can be held, the users own line stays as it is
+	if quantity <= item.Quantity {
+		return nil
+	}
+
+	unitPrice, err := StorePrice(tx, &product, variant)
+	if err != nil {
+		return err
+	}
+
+	if _, err := ReserveStock(tx, owner, product.ID, variantId, quantity); err != nil {
+		return err
+	}
+
+	if item.ID == 0 {
+		item = models.CartItem{
+			UserId:      owner.UserID,
+			GuestCartID: owner.GuestCartID,
+			ProductId:   product.ID,
+			VariantID:   guestItem.VariantID,
+		}
+	} else if item.UnitPrice != unitPrice {
+		//the users line is repriced like in AddCartLine
+		if err := notePriceChange(tx, owner, &item, product.Name, unitPrice); err != nil {
+			return err
+		}
+	}
+
+	item.Quantity = quantity
+	item.UnitPrice = unitPrice
+	item.TotalPrice = unitPrice.Times(quantity)
+
+	return tx.Omit("Product", "Variant").Save(&item).Error
+}
+
+// remove guest carts nobody touched for GuestCartTTL with their lines and holds
+func DeleteStaleGuestCarts(db *gorm.DB) (int64, error) {
+	var removed int64
+	cutoff := time.Now().Add(-GuestCartTTL)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		stale := func() *gorm.DB {
+			return tx.Model(&models.GuestCart{}).Select("id").Where("updated_at <= ?", cutoff)
+		}
+
+		if err := tx.Unscoped().Where("guest_cart_id IN (?)", stale()).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("guest_cart_id IN (?)", stale()).Delete(&models.StockReservation{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("guest_cart_id IN (?)", stale()).Delete(&models.CartChange{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Where("updated_at <= ?", cutoff).Delete(&models.GuestCart{})
+		removed = result.RowsAffected
+		return result.Error
+	})
+	return removed, err
+}
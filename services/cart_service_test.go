@@ -0,0 +1,81 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/junaid9001/spectr_backend/models"
+)
+
+func TestCartToken(t *testing.T) {
+	t.Setenv("CART_TOKEN_SECRET", "cart secret")
+
+	token := CartToken(42)
+	if !strings.HasPrefix(token, "42.") {
+		t.Fatalf("token %q doesn't start with the cart id", token)
+	}
+
+	id, err := ParseCartToken(token)
+	if err != nil || id != 42 {
+		t.Fatalf("ParseCartToken(%q) = %d, %v, want 42", token, id, err)
+	}
+
+	_, signature, _ := strings.Cut(token, ".")
+
+	for name, forged := range map[string]string{
+		"other id":        "43." + signature,
+		"no signature":    "42",
+		"empty signature": "42.",
+		"tampered":        "42." + strings.Repeat("0", len(signature)),
+		"zero id":         "0." + cartTokenSignature(0),
+		"negative id":     "-42." + signature,
+		"not a number":    "abc." + signature,
+		"empty":           "",
+	} {
+		if id, err := ParseCartToken(forged); !errors.Is(err, ErrInvalidCartToken) {
+			t.Errorf("%s: ParseCartToken(%q) = %d, %v, want ErrInvalidCartToken", name, forged, id, err)
+		}
+	}
+
+	//tokens of another secret don't open the cart
+	t.Setenv("CART_TOKEN_SECRET", "rotated")
+	if _, err := ParseCartToken(token); !errors.Is(err, ErrInvalidCartToken) {
+		t.Errorf("token accepted after the secret changed: %v", err)
+	}
+
+	//JWT_SECRETKEY signs when no cart secret is set
+	t.Setenv("CART_TOKEN_SECRET", "")
+	t.Setenv("JWT_SECRETKEY", "cart secret")
+	if id, err := ParseCartToken(token); err != nil || id != 42 {
+		t.Errorf("token signed with the same key through JWT_SECRETKEY = %d, %v", id, err)
+	}
+}
+
+func TestCartChangesAccepted(t *testing.T) {
+	changes := make([]models.CartChange, 3)
+	for i := range changes {
+		changes[i].ID = uint(10 + i)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		changes  []models.CartChange
+		accepted []uint
+		want     bool
+	}{
+		{"nothing pending", nil, nil, true},
+		{"all shown", changes, []uint{10, 11, 12}, true},
+		{"other order", changes, []uint{12, 10, 11}, true},
+		{"repeated id", changes, []uint{10, 11, 12, 11}, true},
+		{"none accepted", changes, nil, false},
+		{"one missing", changes, []uint{10, 12}, false},
+		{"unknown id", changes, []uint{10, 11, 12, 13}, false},
+		{"replaced change", changes[:2], []uint{10, 11, 12}, false},
+		{"accepted without changes", nil, []uint{10}, false},
+	} {
+		if got := CartChangesAccepted(tc.changes, tc.accepted); got != tc.want {
+			t.Errorf("%s: CartChangesAccepted = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+)
+
+// the category itself plus every category below it
+func CategoryWithDescendants(db *gorm.DB, categoryId uint) ([]uint, error) {
+	var categories []models.Category
+
+	if err := db.Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	childrenMap := make(map[uint][]uint)
+	for _, cat := range categories {
+		if cat.ParentID != nil {
+			parent := *cat.ParentID
+			childrenMap[parent] = append(childrenMap[parent], cat.ID)
+		}
+	}
+
+	toVisit := []uint{categoryId}
+	visited := make(map[uint]bool)
+	var allIDs []uint
+
+	for len(toVisit) > 0 {
+
+		n := len(toVisit) - 1
+		current := toVisit[n]
+		toVisit = toVisit[:n]
+
+		if visited[current] {
+			continue
+		}
+		visited[current] = true
+		allIDs = append(allIDs, current)
+
+		for _, childID := range childrenMap[current] {
+			if !visited[childID] {
+				toVisit = append(toVisit, childID)
+			}
+		}
+	}
+
+	return allIDs, nil
+}
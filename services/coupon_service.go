@@ -0,0 +1,236 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCouponInvalid       = errors.New("coupon is not valid")
+	ErrCouponNotApplicable = errors.New("coupon does not apply to this cart")
+	ErrCouponUsedUp        = errors.New("coupon usage limit reached")
+)
+
+// what a coupon takes off a cart
+type CouponResult struct {
+	Coupon        *models.Coupon `json:"coupon"`
+	Discount      models.Money   `json:"discount"`
+	ItemDiscounts []models.Money `json:"item_discounts"` //same order as the cart items
+	FreeShipping  bool           `json:"free_shipping"`
+}
+
+// active coupon by its code (case insensitive)
+func FindCoupon(db *gorm.DB, code string) (*models.Coupon, error) {
+	var coupon models.Coupon
+
+	if err := db.Where("UPPER(code)=?", strings.ToUpper(strings.TrimSpace(code))).First(&coupon).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCouponInvalid
+		}
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+// check coupon rules against a user and cart and split the discount over the lines
+func EvaluateCoupon(db *gorm.DB, coupon *models.Coupon, userId uint, items []models.CartItem) (*CouponResult, error) {
+	now := time.Now()
+
+	if !coupon.IsActive || (coupon.StartsAt != nil && now.Before(*coupon.StartsAt)) ||
+		(coupon.EndsAt != nil && now.After(*coupon.EndsAt)) {
+		return nil, ErrCouponInvalid
+	}
+
+	if coupon.UsageLimit > 0 && coupon.UsedCount >= coupon.UsageLimit {
+		return nil, ErrCouponUsedUp
+	}
+
+	if coupon.PerUserLimit > 0 {
+		var used int64
+		if err := db.Model(&models.CouponRedemption{}).
+			Where("coupon_id=? AND user_id=?", coupon.ID, userId).Count(&used).Error; err != nil {
+			return nil, err
+		}
+		if int(used) >= coupon.PerUserLimit {
+			return nil, ErrCouponUsedUp
+		}
+	}
+
+	var cartTotal models.Money
+	for _, item := range items {
+		cartTotal += item.TotalPrice
+	}
+
+	if cartTotal < coupon.MinCartValue {
+		return nil, fmt.Errorf("%w: cart must be at least %s", ErrCouponNotApplicable, coupon.MinCartValue)
+	}
+
+	var categoryIds map[uint]bool
+	if coupon.CategoryID != nil {
+		ids, err := CategoryWithDescendants(db, *coupon.CategoryID)
+		if err != nil {
+			return nil, err
+		}
+		categoryIds = make(map[uint]bool, len(ids))
+		for _, id := range ids {
+			categoryIds[id] = true
+		}
+	}
+
+	//lines the coupon is scoped to
+	eligible := make([]bool, len(items))
+	var eligibleTotal models.Money
+
+	for i, item := range items {
+		if coupon.ProductID != nil && item.ProductId != *coupon.ProductID {
+			continue
+		}
+		if coupon.Brand != "" && !strings.EqualFold(item.Product.Brand, coupon.Brand) {
+			continue
+		}
+		if categoryIds != nil && (item.Product.CategoryID == nil || !categoryIds[*item.Product.CategoryID]) {
+			continue
+		}
+
+		eligible[i] = true
+		eligibleTotal += item.TotalPrice
+	}
+
+	if eligibleTotal <= 0 {
+		return nil, ErrCouponNotApplicable
+	}
+
+	result := &CouponResult{
+		Coupon:        coupon,
+		ItemDiscounts: make([]models.Money, len(items)),
+	}
+
+	switch coupon.Type {
+	case models.CouponTypePercentage:
+		//value holds the percent in hundredths, 1000 = 10%
+		result.Discount = eligibleTotal.Share(int64(coupon.Value), 100*100)
+		if coupon.MaxDiscount > 0 && result.Discount > coupon.MaxDiscount {
+			result.Discount = coupon.MaxDiscount
+		}
+
+	case models.CouponTypeFixed:
+		result.Discount = coupon.Value
+		if result.Discount > eligibleTotal {
+			result.Discount = eligibleTotal
+		}
+
+	case models.CouponTypeFreeShipping:
+		result.FreeShipping = true
+		return result, nil
+
+	default:
+		return nil, ErrCouponInvalid
+	}
+
+	//share discount by line value, the last eligible line takes the rounding rest
+	remaining := result.Discount
+	last := -1
+	for i := range items {
+		if eligible[i] {
+			last = i
+		}
+	}
+
+	for i, item := range items {
+		if !eligible[i] {
+			continue
+		}
+		if i == last {
+			result.ItemDiscounts[i] = remaining
+			break
+		}
+		share := result.Discount.Share(int64(item.TotalPrice), int64(eligibleTotal))
+		result.ItemDiscounts[i] = share
+		remaining -= share
+	}
+
+	return result, nil
+}
+
+// the coupon applied to a users cart, nil when there is none
+func CartCouponFor(db *gorm.DB, userId uint) (*models.Coupon, error) {
+	var applied models.CartCoupon
+
+	if err := db.Preload("Coupon").Where("user_id=?", userId).First(&applied).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	//coupon was deleted after it was applied
+	if applied.Coupon.ID == 0 {
+		return nil, nil
+	}
+	return &applied.Coupon, nil
+}
+
+// count a coupon as used by an order, call inside the order transaction
+func RedeemCoupon(tx *gorm.DB, coupon *models.Coupon, userId, orderId uint, discount models.Money) error {
+	//limits are checked again while the coupon row is locked, two checkouts can't both take the last use
+	var locked models.Coupon
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, coupon.ID).Error; err != nil {
+		return err
+	}
+
+	if locked.UsageLimit > 0 && locked.UsedCount >= locked.UsageLimit {
+		return ErrCouponUsedUp
+	}
+
+	if locked.PerUserLimit > 0 {
+		var used int64
+		if err := tx.Model(&models.CouponRedemption{}).
+			Where("coupon_id=? AND user_id=?", locked.ID, userId).Count(&used).Error; err != nil {
+			return err
+		}
+		if int(used) >= locked.PerUserLimit {
+			return ErrCouponUsedUp
+		}
+	}
+
+	if err := tx.Model(&locked).UpdateColumn("used_count", gorm.Expr("used_count + ?", 1)).Error; err != nil {
+		return err
+	}
+
+	redemption := models.CouponRedemption{
+		CouponID:       coupon.ID,
+		UserID:         userId,
+		OrderID:        orderId,
+		DiscountAmount: discount,
+	}
+	if err := tx.Create(&redemption).Error; err != nil {
+		return err
+	}
+
+	return tx.Where("user_id=?", userId).Delete(&models.CartCoupon{}).Error
+}
+
+// undo the redemption of an order, used when it is cancelled
+func ReleaseCouponRedemption(tx *gorm.DB, orderId uint) error {
+	var redemption models.CouponRedemption
+
+	if err := tx.Where("order_id=?", orderId).First(&redemption).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if err := tx.Delete(&redemption).Error; err != nil {
+		return err
+	}
+
+	return tx.Unscoped().Model(&models.Coupon{}).Where("id=? AND used_count > 0", redemption.CouponID).
+		UpdateColumn("used_count", gorm.Expr("used_count - ?", 1)).Error
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/junaid9001/spectr_backend/models"
+)
+
+func cartLine(productId uint, brand string, total models.Money) models.CartItem {
+	item := models.CartItem{ProductId: productId, TotalPrice: total}
+	item.Product.Brand = brand
+	return item
+}
+
+// coupons without a per user limit or category scope are checked without the database
+func TestEvaluateCoupon(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	shoe := uint(2)
+
+	cart := []models.CartItem{
+		cartLine(1, "spectr", 30000),
+		cartLine(2, "acme", 10000),
+		cartLine(3, "spectr", 20000),
+	}
+
+	for _, tc := range []struct {
+		name     string
+		coupon   models.Coupon
+		discount models.Money
+		lines    []models.Money
+		free     bool
+		err      error
+	}{
+		{
+			name:     "percentage",
+			coupon:   models.Coupon{Type: models.CouponTypePercentage, Value: 1000},
+			discount: 6000,
+			lines:    []models.Money{3000, 1000, 2000},
+		},
+		{
+			name:     "percentage capped",
+			coupon:   models.Coupon{Type: models.CouponTypePercentage, Value: 5000, MaxDiscount: 4500},
+			discount: 4500,
+			lines:    []models.Money{2250, 750, 1500},
+		},
+		{
+			name:     "fixed split by line value",
+			coupon:   models.Coupon{Type: models.CouponTypeFixed, Value: 1000},
+			discount: 1000,
+			lines:    []models.Money{500, 167, 333}, //the last line takes the rounding rest
+		},
+		{
+			name:     "fixed above the cart",
+			coupon:   models.Coupon{Type: models.CouponTypeFixed, Value: 100000},
+			discount: 60000,
+			lines:    []models.Money{30000, 10000, 20000},
+		},
+		{
+			name:     "brand scope",
+			coupon:   models.Coupon{Type: models.CouponTypeFixed, Value: 1000, Brand: "SPECTR"},
+			discount: 1000,
+			lines:    []models.Money{600, 0, 400},
+		},
+		{
+			name:     "product scope",
+			coupon:   models.Coupon{Type: models.CouponTypePercentage, Value: 2500, ProductID: &shoe},
+			discount: 2500,
+			lines:    []models.Money{0, 2500, 0},
+		},
+		{
+			name:   "free shipping",
+			coupon: models.Coupon{Type: models.CouponTypeFreeShipping},
+			free:   true,
+			lines:  []models.Money{0, 0, 0},
+		},
+		{
+			name:     "min cart value met",
+			coupon:   models.Coupon{Type: models.CouponTypeFixed, Value: 500, MinCartValue: 60000},
+			discount: 500,
+			lines:    []models.Money{250, 83, 167},
+		},
+		{
+			name:     "window open",
+			coupon:   models.Coupon{Type: models.CouponTypeFixed, Value: 600, StartsAt: &past, EndsAt: &future},
+			discount: 600,
+			lines:    []models.Money{300, 100, 200},
+		},
+		{name: "inactive", coupon: models.Coupon{Type: models.CouponTypeFixed, Value: 500}, err: ErrCouponInvalid},
+		{name: "not started", coupon: models.Coupon{Type: models.CouponTypeFixed, Value: 500, StartsAt: &future}, err: ErrCouponInvalid},
+		{name: "expired", coupon: models.Coupon{Type: models.CouponTypeFixed, Value: 500, EndsAt: &past}, err: ErrCouponInvalid},
+		{name: "used up", coupon: models.Coupon{Type: models.CouponTypeFixed, Value: 500, UsageLimit: 3, UsedCount: 3}, err: ErrCouponUsedUp},
+		{name: "min cart value missed", coupon: models.Coupon{Type: models.CouponTypeFixed, Value: 500, MinCartValue: 60001}, err: ErrCouponNotApplicable},
+		{name: "brand not in cart", coupon: models.Coupon{Type: models.CouponTypeFixed, Value: 500, Brand: "other"}, err: ErrCouponNotApplicable},
+		{name: "unknown type", coupon: models.Coupon{Type: "bogus", Value: 500}, err: ErrCouponInvalid},
+	} {
+		coupon := tc.coupon
+		coupon.IsActive = tc.name != "inactive"
+
+		result, err := EvaluateCoupon(nil, &coupon, 1, cart)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: error = %v, want %v", tc.name, err, tc.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if result.Discount != tc.discount || result.FreeShipping != tc.free {
+			t.Errorf("%s: discount %d, free shipping %v, want %d, %v", tc.name, result.Discount, result.FreeShipping, tc.discount, tc.free)
+		}
+		if !slices.Equal(result.ItemDiscounts, tc.lines) {
+			t.Errorf("%s: line discounts %v, want %v", tc.name, result.ItemDiscounts, tc.lines)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrUnknownCurrency  = errors.New("no exchange rate for currency")
+	ErrInvalidCurrency  = errors.New("currency must be a 3 letter code")
+	ErrCurrencyDecimals = errors.New("only currencies with 2 decimals are supported")
+)
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// upper case ISO code, empty = store currency. currencies without cents (JPY) or with 3
+// decimals (KWD) are refused, Money can't hold their amounts
+func NormalizeCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return models.DefaultCurrency(), nil
+	}
+	if !currencyCode.MatchString(code) {
+		return "", ErrInvalidCurrency
+	}
+	if !models.HasCentMinorUnit(code) {
+		return "", fmt.Errorf("%w: %s", ErrCurrencyDecimals, code)
+	}
+	return code, nil
+}
+
+// units of to per unit of from: direct pair, inverse pair or through the store currency
+func ExchangeRateFor(db *gorm.DB, from, to string) (float64, error) {
+	return exchangeRate(from, to, func(from, to string) ([]models.ExchangeRate, error) {
+		var rates []models.ExchangeRate
+		err := db.Where("(base_currency=? AND quote_currency=?) OR (base_currency=? AND quote_currency=?)",
+			from, to, to, from).Find(&rates).Error
+		return rates, err
+	})
+}
+
+// rates stored for a pair, in either direction
+type pairRates func(from, to string) ([]models.ExchangeRate, error)
+
+func exchangeRate(from, to string, load pairRates) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+
+	rates, err := load(from, to)
+	if err != nil {
+		return 0, err
+	}
+	if rate, ok := pairRate(rates, from, to); ok {
+		return rate, nil
+	}
+
+	base := models.DefaultCurrency()
+	if from != base && to != base {
+		toBase, err := exchangeRate(from, base, load)
+		if err != nil {
+			return 0, err
+		}
+		fromBase, err := exchangeRate(base, to, load)
+		if err != nil {
+			return 0, err
+		}
+		return toBase * fromBase, nil
+	}
+
+	return 0, fmt.Errorf("%w %s/%s", ErrUnknownCurrency, from, to)
+}
+
+// the direct rate wins over the inverse of the opposite pair, rates <= 0 are ignored
+func pairRate(rates []models.ExchangeRate, from, to string) (float64, bool) {
+	for _, rate := range rates {
+		if rate.BaseCurrency == from && rate.QuoteCurrency == to && rate.Rate > 0 {
+			return rate.Rate, true
+		}
+	}
+	for _, rate := range rates {
+		if rate.BaseCurrency == to && rate.QuoteCurrency == from && rate.Rate > 0 {
+			return 1 / rate.Rate, true
+		}
+	}
+	return 0, false
+}
+
+// price of a product (variant) in the store currency, what carts and orders are priced in
+func StorePrice(db *gorm.DB, product *models.Product, variant *models.ProductVariant) (models.Money, error) {
+	rate, err := ExchangeRateFor(db, productCurrency(product), models.DefaultCurrency())
+	if err != nil {
+		return 0, err
+	}
+
+	price := product.Price
+	if variant != nil {
+		price = variant.EffectivePrice(product)
+	}
+	return price.Convert(rate), nil
+}
+
+// sql expression of <table>.price in the store currency, so prices in different currencies can be
+// filtered and sorted together, NULL for currencies without a rate
+func StorePriceSQL(db *gorm.DB, table string) (string, error) {
+	var currencies []string
+	if err := db.Model(&models.Product{}).Distinct("currency").Pluck("currency", &currencies).Error; err != nil {
+		return "", err
+	}
+
+	base := models.DefaultCurrency()
+
+	var cases strings.Builder
+	for _, currency := range currencies {
+		if currency == base || !currencyCode.MatchString(currency) {
+			continue
+		}
+
+		rate, err := ExchangeRateFor(db, currency, base)
+		if err != nil && !errors.Is(err, ErrUnknownCurrency) {
+			return "", err
+		}
+
+		if err != nil {
+			fmt.Fprintf(&cases, " WHEN '%s' THEN NULL", currency)
+		} else {
+			fmt.Fprintf(&cases, " WHEN '%s' THEN ROUND(%s.price * %s)", currency, table, strconv.FormatFloat(rate, 'f', -1, 64))
+		}
+	}
+
+	if cases.Len() == 0 {
+		return table + ".price", nil
+	}
+	return fmt.Sprintf("(CASE %s.currency%s ELSE %s.price END)", table, cases.String(), table), nil
+}
+
+func productCurrency(product *models.Product) string {
+	if product.Currency == "" {
+		return models.DefaultCurrency()
+	}
+	return product.Currency
+}
+
+// rewrite a product price into currency, only for responses, never save it afterwards
+func LocalizeProduct(db *gorm.DB, product *models.Product, currency string) error {
+	rate, err := ExchangeRateFor(db, productCurrency(product), currency)
+	if err != nil {
+		return err
+	}
+	convertProduct(product, rate, currency)
+	return nil
+}
+
+// a product whose currency has no rate keeps its own price and currency instead of failing the
+// whole list, the ids of those products are returned so the response can flag them
+func LocalizeProducts(db *gorm.DB, products []models.Product, currency string) ([]uint, error) {
+	localizer := NewProductLocalizer(db, currency)
+	unconverted := []uint{}
+
+	for i := range products {
+		if err := localizer.Localize(&products[i]); err != nil {
+			if !errors.Is(err, ErrUnknownCurrency) {
+				return nil, err
+			}
+			unconverted = append(unconverted, products[i].ID)
+		}
+	}
+	return unconverted, nil
+}
+
+// converts many products to one currency, every source currency is looked up once
+type ProductLocalizer struct {
+	db       *gorm.DB
+	currency string
+	rates    map[string]float64
+	missing  map[string]error //currencies without a rate, not looked up again
+}
+
+func NewProductLocalizer(db *gorm.DB, currency string) *ProductLocalizer {
+	return &ProductLocalizer{db: db, currency: currency, rates: make(map[string]float64), missing: make(map[string]error)}
+}
+
+func (l *ProductLocalizer) Localize(product *models.Product) error {
+	from := productCurrency(product)
+
+	if err, ok := l.missing[from]; ok {
+		return err
+	}
+
+	rate, ok := l.rates[from]
+	if !ok {
+		var err error
+		if rate, err = ExchangeRateFor(l.db, from, l.currency); err != nil {
+			if errors.Is(err, ErrUnknownCurrency) {
+				l.missing[from] = err
+			}
+			return err
+		}
+		l.rates[from] = rate
+	}
+
+	convertProduct(product, rate, l.currency)
+	return nil
+}
+
+func convertProduct(product *models.Product, rate float64, currency string) {
+	product.Price = product.Price.Convert(rate)
+	product.Currency = currency
+
+	for i := range product.Variants {
+		if variant := &product.Variants[i]; variant.Price != nil {
+			price := variant.Price.Convert(rate)
+			variant.Price = &price
+		}
+	}
+}
+
+// breakdown in another currency, every line is converted and the totals are summed again
+func ConvertBreakdown(breakdown *PriceBreakdown, rate float64, currency string) *PriceBreakdown {
+	converted := &PriceBreakdown{
+		Items:        make([]LinePrice, len(breakdown.Items)),
+		Shipping:     breakdown.Shipping.Convert(rate),
+		WeightGrams:  breakdown.WeightGrams,
+		FreeShipping: breakdown.FreeShipping,
+		Currency:     currency,
+	}
+
+	for i, line := range breakdown.Items {
+		line.UnitPrice = line.UnitPrice.Convert(rate)
+		line.Subtotal = line.UnitPrice.Times(line.Quantity)
+		line.Discount = line.Discount.Convert(rate)
+		if line.Discount > line.Subtotal {
+			line.Discount = line.Subtotal
+		}
+		line.Tax = line.Tax.Convert(rate)
+		line.Total = line.Subtotal - line.Discount + line.Tax
+
+		converted.Items[i] = line
+		converted.Subtotal += line.Subtotal
+		converted.Discount += line.Discount
+		converted.Tax += line.Tax
+	}
+
+	converted.Total = converted.Subtotal - converted.Discount + converted.Tax + converted.Shipping
+	return converted
+}
+
+// csv with base_currency,quote_currency,rate rows, a header row is optional
+// existing pairs are overwritten, returns the number of rows stored
+func ImportExchangeRates(db *gorm.DB, r io.Reader) (int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	var rates []models.ExchangeRate
+
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "base_currency") {
+			continue
+		}
+
+		rate, err := parseExchangeRate(record)
+		if err != nil {
+			return 0, fmt.Errorf("line %d: %w", line, err)
+		}
+		rates = append(rates, rate)
+	}
+
+	if len(rates) == 0 {
+		return 0, errors.New("no exchange rates in file")
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i := range rates {
+			if err := SaveExchangeRate(tx, &rates[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(rates), nil
+}
+
+func parseExchangeRate(record []string) (models.ExchangeRate, error) {
+	base, err := NormalizeCurrency(record[0])
+	if err != nil || strings.TrimSpace(record[0]) == "" {
+		return models.ExchangeRate{}, ErrInvalidCurrency
+	}
+	quote, err := NormalizeCurrency(record[1])
+	if err != nil || strings.TrimSpace(record[1]) == "" {
+		return models.ExchangeRate{}, ErrInvalidCurrency
+	}
+	if base == quote {
+		return models.ExchangeRate{}, errors.New("base and quote currency are the same")
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(record[2]), 64)
+	if err != nil || value <= 0 {
+		return models.ExchangeRate{}, errors.New("rate must be a positive number")
+	}
+
+	return models.ExchangeRate{BaseCurrency: base, QuoteCurrency: quote, Rate: value}, nil
+}
+
+// insert or update the rate of a currency pair
+func SaveExchangeRate(tx *gorm.DB, rate *models.ExchangeRate) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "base_currency"}, {Name: "quote_currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "updated_at"}),
+	}).Create(rate).Error
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/junaid9001/spectr_backend/models"
+)
+
+// rates kept in memory, looked up like ExchangeRateFor does in the table
+func fakeRates(rows ...models.ExchangeRate) pairRates {
+	return func(from, to string) ([]models.ExchangeRate, error) {
+		var found []models.ExchangeRate
+		for _, row := range rows {
+			if (row.BaseCurrency == from && row.QuoteCurrency == to) ||
+				(row.BaseCurrency == to && row.QuoteCurrency == from) {
+				found = append(found, row)
+			}
+		}
+		return found, nil
+	}
+}
+
+func rate(base, quote string, value float64) models.ExchangeRate {
+	return models.ExchangeRate{BaseCurrency: base, QuoteCurrency: quote, Rate: value}
+}
+
+func TestExchangeRate(t *testing.T) {
+	t.Setenv("CURRENCY", "INR")
+
+	load := fakeRates(
+		rate("INR", "USD", 0.012),
+		rate("EUR", "INR", 90),
+		rate("GBP", "USD", 1.25),
+		rate("USD", "GBP", 0.8),  //both directions stored, the direct one is used
+		rate("INR", "AED", 0),    //not set yet
+		rate("INR", "CHF", -0.5), //broken row
+	)
+
+	for _, tc := range []struct {
+		from, to string
+		want     float64
+		err      error
+	}{
+		{"INR", "INR", 1, nil},
+		{"INR", "USD", 0.012, nil},     //direct
+		{"USD", "INR", 1 / 0.012, nil}, //inverse
+		{"EUR", "INR", 90, nil},
+		{"INR", "EUR", 1.0 / 90, nil},
+		{"EUR", "USD", 90 * 0.012, nil}, //cross through the store currency
+		{"USD", "EUR", (1 / 0.012) * (1.0 / 90), nil},
+		{"GBP", "USD", 1.25, nil},
+		{"USD", "GBP", 0.8, nil},
+		{"INR", "AED", 0, ErrUnknownCurrency},
+		{"INR", "CHF", 0, ErrUnknownCurrency},
+		{"INR", "SGD", 0, ErrUnknownCurrency},
+		{"EUR", "SGD", 0, ErrUnknownCurrency}, //second leg of the cross rate is missing
+		{"SGD", "EUR", 0, ErrUnknownCurrency}, //first leg is missing
+	} {
+		got, err := exchangeRate(tc.from, tc.to, load)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s/%s error = %v, want %v", tc.from, tc.to, err, tc.err)
+			continue
+		}
+		if math.Abs(got-tc.want) > 1e-12*math.Max(1, tc.want) {
+			t.Errorf("%s/%s = %v, want %v", tc.from, tc.to, got, tc.want)
+		}
+	}
+}
+
+func TestExchangeRateLoadError(t *testing.T) {
+	failed := errors.New("db down")
+	load := func(from, to string) ([]models.ExchangeRate, error) { return nil, failed }
+
+	if _, err := exchangeRate("INR", "USD", load); !errors.Is(err, failed) {
+		t.Fatalf("error = %v, want the load error", err)
+	}
+}
+
+func TestNormalizeCurrency(t *testing.T) {
+	t.Setenv("CURRENCY", "INR")
+
+	for _, tc := range []struct {
+		in, want string
+		err      error
+	}{
+		{"", "INR", nil},
+		{" usd ", "USD", nil},
+		{"EUR", "EUR", nil},
+		{"US", "", ErrInvalidCurrency},
+		{"US1", "", ErrInvalidCurrency},
+		{"dollar", "", ErrInvalidCurrency},
+		{"JPY", "", ErrCurrencyDecimals},
+		{"kwd", "", ErrCurrencyDecimals},
+	} {
+		got, err := NormalizeCurrency(tc.in)
+		if got != tc.want || !errors.Is(err, tc.err) {
+			t.Errorf("NormalizeCurrency(%q) = %q, %v, want %q, %v", tc.in, got, err, tc.want, tc.err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrOrderNotClaimable = errors.New("order not found or already claimed")
+	ErrClaimNotAllowed   = errors.New("order was placed with another email, send its lookup token to claim it")
+)
+
+// no 0/O or 1/I, and never clashing with numbers of older orders (SP + zero padded id)
+const orderNumberAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// random order number like SP7K2QX9MD4A, shown to customers and used with their email for lookups
+func NewOrderNumber() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	number := make([]byte, len(b))
+	for i, v := range b {
+		number[i] = orderNumberAlphabet[int(v)%len(orderNumberAlphabet)]
+	}
+	return "SP" + string(number), nil
+}
+
+// token mailed to a guest to look up and pay their order, only its hash is stored
+func NewLookupToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(b)
+	return token, hashLookupToken(token), nil
+}
+
+func hashLookupToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func withOrderDetails(db *gorm.DB) *gorm.DB {
+	return db.Preload("OrderItems").Preload("StatusHistory", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("created_at ASC, id ASC")
+	})
+}
+
+// order of a lookup token from the guest order email
+func FindOrderByLookupToken(db *gorm.DB, token string) (*models.Order, error) {
+	if strings.TrimSpace(token) == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var order models.Order
+	if err := withOrderDetails(db).Where("lookup_token=?", hashLookupToken(token)).First(&order).Error; err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// guest order by the email it was placed with and its order number
+func FindGuestOrder(db *gorm.DB, email, orderNumber string) (*models.Order, error) {
+	var order models.Order
+	if err := withOrderDetails(db).
+		Where("LOWER(guest_email)=LOWER(?) AND order_number=?", strings.TrimSpace(email), strings.ToUpper(strings.TrimSpace(orderNumber))).
+		First(&order).Error; err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// move a guest order into a users account, the account must have the orders email or the
+// caller must send the orders lookup token
+func ClaimGuestOrder(db *gorm.DB, userId uint, orderNumber, token string) (*models.Order, error) {
+	var user models.User
+	if err := db.First(&user, userId).Error; err != nil {
+		return nil, err
+	}
+
+	var order models.Order
+	if err := db.Where("order_number=? AND user_id=0 AND guest_email <> ''", strings.ToUpper(strings.TrimSpace(orderNumber))).
+		First(&order).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOrderNotClaimable
+		}
+		return nil, err
+	}
+
+	sameEmail := strings.EqualFold(order.GuestEmail, user.Email)
+	if !sameEmail && (token == "" || hashLookupToken(token) != order.LookupToken) {
+		return nil, ErrClaimNotAllowed
+	}
+
+	//user_id=0 again so two accounts can't both claim it
+	result := db.Model(&models.Order{}).Where("id=? AND user_id=0", order.ID).Update("user_id", userId)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrOrderNotClaimable
+	}
+
+	if err := withOrderDetails(db).First(&order, order.ID).Error; err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// order number and lookup token for a guest, errors are only logged
+func SendGuestOrderEmail(order *models.Order, token string) {
+	subject := fmt.Sprintf("Your order %s", order.OrderNumber)
+
+	//STORE_URL turns the token into a link, otherwise it is sent as it is
+	lookup := "Your order lookup token: " + token
+	if storeURL := strings.TrimRight(os.Getenv("STORE_URL"), "/"); storeURL != "" {
+		lookup = "Track your order: " + storeURL + "/orders/lookup?token=" + token
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nThanks for your order %s of %s %s.\n\n%s\n\nYou can also look it up with this email address and the order number. To keep it with your other orders, create an account with this email and claim the order.",
+		order.ShipTo.Name, order.OrderNumber, order.Currency, order.TotalAmount, lookup)
+
+	if err := SendEmail(order.GuestEmail, subject, body); err != nil {
+		log.Println("guest order mail:", err)
+	}
+}
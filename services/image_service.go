@@ -0,0 +1,399 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif" //registers the gif decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"github.com/junaid9001/spectr_backend/storage"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrImageTooLarge = errors.New("image is larger than the upload limit")
+	ErrImageType     = errors.New("only jpeg, png and gif images are allowed")
+	ErrImageInvalid  = errors.New("image could not be read")
+	ErrImageOrder    = errors.New("image_ids must list every image of the product once")
+)
+
+const (
+	productImageDir = "products" //storage key prefix
+	maxImagePixels  = 40_000_000 //decoded size guard, 40 megapixels
+)
+
+// longest side of each resized copy
+const (
+	thumbnailSide = 150
+	mediumSide    = 600
+	largeSide     = 1200
+)
+
+// sniffed content type -> extension of the stored original
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// upload limit per image (IMAGE_MAX_MB, default 5)
+func MaxImageBytes() int64 {
+	mb, err := strconv.Atoi(os.Getenv("IMAGE_MAX_MB"))
+	if err != nil || mb <= 0 {
+		mb = 5
+	}
+	return int64(mb) << 20
+}
+
+// check an upload by its content (not its name), store it and its thumbnail / medium / large copies
+// the returned image is not saved yet, on any error nothing is left in storage
+func SaveProductImage(file *multipart.FileHeader) (*models.ProductImage, error) {
+	data, mimeType, config, err := readImageUpload(file)
+	if err != nil {
+		return nil, err
+	}
+	ext := imageTypes[mimeType]
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrImageInvalid
+	}
+
+	base, err := storage.NewKey(productImageDir, "")
+	if err != nil {
+		return nil, err
+	}
+
+	img := &models.ProductImage{
+		MimeType: mimeType,
+		Width:    config.Width,
+		Height:   config.Height,
+	}
+
+	var written []string
+	fail := func(err error) (*models.ProductImage, error) {
+		RemoveImageFiles(written...)
+		return nil, err
+	}
+
+	img.Original = base + ext
+	if err := storage.Default().Put(img.Original, bytes.NewReader(data), mimeType); err != nil {
+		return fail(err)
+	}
+	written = append(written, img.Original)
+
+	//each copy is made from the previous one, largest first
+	resized := toRGBA(decoded)
+	for _, size := range []struct {
+		side int
+		name string
+		dest *string
+	}{
+		{largeSide, "large", &img.Large},
+		{mediumSide, "medium", &img.Medium},
+		{thumbnailSide, "thumb", &img.Thumbnail},
+	} {
+		resized = resizeImage(resized, size.side)
+
+		key, err := putResized(resized, base+"_"+size.name, mimeType)
+		if err != nil {
+			return fail(err)
+		}
+		written = append(written, key)
+		*size.dest = key
+	}
+
+	return img, nil
+}
+
+// a customer photo (returns), checked like gallery images but stored as uploaded, returns its key
+func SaveUploadedPhoto(file *multipart.FileHeader, dir string) (string, error) {
+	data, mimeType, _, err := readImageUpload(file)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := storage.NewKey(dir, imageTypes[mimeType])
+	if err != nil {
+		return "", err
+	}
+	if err := storage.Default().Put(key, bytes.NewReader(data), mimeType); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// upload content with its sniffed type and dimensions, size and type limits applied
+func readImageUpload(file *multipart.FileHeader) ([]byte, string, image.Config, error) {
+	limit := MaxImageBytes()
+	if file.Size > limit {
+		return nil, "", image.Config{}, ErrImageTooLarge
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return nil, "", image.Config{}, ErrImageInvalid
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(io.LimitReader(src, limit+1))
+	if err != nil {
+		return nil, "", image.Config{}, ErrImageInvalid
+	}
+	if int64(len(data)) > limit {
+		return nil, "", image.Config{}, ErrImageTooLarge
+	}
+
+	mimeType := http.DetectContentType(data)
+	if _, ok := imageTypes[mimeType]; !ok {
+		return nil, "", image.Config{}, ErrImageType
+	}
+
+	//read the header first so a tiny file can't decode into a huge bitmap
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width <= 0 || config.Height <= 0 {
+		return nil, "", image.Config{}, ErrImageInvalid
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, "", image.Config{}, ErrImageTooLarge
+	}
+
+	return data, mimeType, config, nil
+}
+
+// jpeg stays jpeg, png and gif become png to keep transparency
+func putResized(img image.Image, name, mimeType string) (string, error) {
+	var buf bytes.Buffer
+
+	key, contentType := name+".png", "image/png"
+	if mimeType == "image/jpeg" {
+		key, contentType = name+".jpg", "image/jpeg"
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+			return "", err
+		}
+	} else if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+
+	if err := storage.Default().Put(key, &buf, contentType); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func toRGBA(src image.Image) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+	return dst
+}
+
+// box filter: every target pixel is the average of the source pixels it covers
+// keeps the aspect ratio, never upscales
+func resizeImage(src *image.RGBA, maxSide int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if sw <= maxSide && sh <= maxSide {
+		return src
+	}
+
+	dw, dh := maxSide, maxSide
+	if sw >= sh {
+		dh = max(1, sh*maxSide/sw)
+	} else {
+		dw = max(1, sw*maxSide/sh)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+
+			var sum [4]uint64
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					sum[0] += uint64(src.Pix[i])
+					sum[1] += uint64(src.Pix[i+1])
+					sum[2] += uint64(src.Pix[i+2])
+					sum[3] += uint64(src.Pix[i+3])
+					i += 4
+				}
+			}
+
+			n := uint64((x1 - x0) * (y1 - y0))
+			o := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[o+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+
+	return dst
+}
+
+// delete stored files by key, missing files are fine
+func RemoveImageFiles(keys ...string) {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := storage.Default().Delete(key); err != nil {
+			log.Println("removing image file failed:", err)
+		}
+	}
+}
+
+// add new images at the end of a product gallery, the first image of an empty gallery is primary
+func AddProductImages(tx *gorm.DB, productId uint, images []*models.ProductImage) error {
+	var last struct{ Position *int }
+	if err := tx.Model(&models.ProductImage{}).Select("MAX(position) AS position").
+		Where("product_id=?", productId).Scan(&last).Error; err != nil {
+		return err
+	}
+
+	next := 0
+	if last.Position != nil {
+		next = *last.Position + 1
+	}
+
+	for _, img := range images {
+		img.ProductID = productId
+		img.Position = next
+		next++
+
+		if err := tx.Create(img).Error; err != nil {
+			return err
+		}
+	}
+
+	return SyncPrimaryImage(tx, productId)
+}
+
+// make img the only primary image of its product
+func SetPrimaryImage(tx *gorm.DB, img *models.ProductImage) error {
+	if err := tx.Model(&models.ProductImage{}).Where("product_id=? AND id<>?", img.ProductID, img.ID).
+		Update("is_primary", false).Error; err != nil {
+		return err
+	}
+	img.IsPrimary = true
+	if err := tx.Model(img).Update("is_primary", true).Error; err != nil {
+		return err
+	}
+	return SyncPrimaryImage(tx, img.ProductID)
+}
+
+// gallery order, imageIds has to hold every image of the product exactly once
+func ReorderProductImages(tx *gorm.DB, productId uint, imageIds []uint) error {
+	var existing []uint
+	if err := tx.Model(&models.ProductImage{}).Where("product_id=?", productId).
+		Pluck("id", &existing).Error; err != nil {
+		return err
+	}
+
+	if len(existing) != len(imageIds) {
+		return ErrImageOrder
+	}
+	known := make(map[uint]bool, len(existing))
+	for _, id := range existing {
+		known[id] = true
+	}
+	for _, id := range imageIds {
+		if !known[id] {
+			return ErrImageOrder
+		}
+		delete(known, id) //twice is an error too
+	}
+
+	for position, id := range imageIds {
+		if err := tx.Model(&models.ProductImage{}).Where("id=?", id).
+			Update("position", position).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delete a gallery image, returns the files to remove once the transaction commits
+func DeleteProductImage(tx *gorm.DB, img *models.ProductImage) ([]string, error) {
+	if err := tx.Delete(img).Error; err != nil {
+		return nil, err
+	}
+	if err := SyncPrimaryImage(tx, img.ProductID); err != nil {
+		return nil, err
+	}
+	return img.Files(), nil
+}
+
+// drop the pictures of a removed variant, returns the files to remove once the transaction commits
+func DeleteVariantImages(tx *gorm.DB, productId, variantId uint) ([]string, error) {
+	files, err := deleteImagesWhere(tx, "variant_id=?", variantId)
+	if err != nil {
+		return nil, err
+	}
+	return files, SyncPrimaryImage(tx, productId)
+}
+
+func deleteImagesWhere(tx *gorm.DB, query string, args ...interface{}) ([]string, error) {
+	var images []models.ProductImage
+	if err := tx.Where(query, args...).Find(&images).Error; err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, nil
+	}
+
+	var files []string
+	for i := range images {
+		files = append(files, images[i].Files()...)
+	}
+
+	if err := tx.Delete(&images).Error; err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// keep exactly one primary image and mirror it into products.image_url
+func SyncPrimaryImage(tx *gorm.DB, productId uint) error {
+	var images []models.ProductImage
+	if err := tx.Where("product_id=?", productId).Order("position ASC, id ASC").Find(&images).Error; err != nil {
+		return err
+	}
+
+	imageUrl := ""
+	if len(images) > 0 {
+		primary := &images[0]
+		for i := range images {
+			if images[i].IsPrimary {
+				primary = &images[i]
+				break
+			}
+		}
+
+		if err := tx.Model(&models.ProductImage{}).Where("product_id=? AND id<>?", productId, primary.ID).
+			Update("is_primary", false).Error; err != nil {
+			return err
+		}
+		if !primary.IsPrimary {
+			if err := tx.Model(primary).Update("is_primary", true).Error; err != nil {
+				return err
+			}
+		}
+		imageUrl = primary.Medium
+	}
+
+	return tx.Model(&models.Product{}).Where("id=?", productId).Update("image_url", imageUrl).Error
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidSort   = errors.New("sort must be one of newest, price_asc, price_desc, popularity, rating, views")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+const (
+	SortNewest     = "newest"
+	SortPriceAsc   = "price_asc"
+	SortPriceDesc  = "price_desc"
+	SortPopularity = "popularity"
+	SortRating     = "rating"
+	SortViews      = "views"
+)
+
+type ListParams struct {
+	ProductFilters
+	Sort   string //empty = newest
+	Cursor string //next_cursor of the previous page, empty = first page
+	Limit  int
+}
+
+type ListResult struct {
+	Products   []models.Product `json:"products"`
+	Total      int64            `json:"total"` //matches of the filters over all pages
+	NextCursor string           `json:"next_cursor"`
+}
+
+// how a sort orders products, values of the last row are carried in the cursor
+type listSort struct {
+	expr string
+	desc bool
+	kind string //int, float or time, the type of expr
+}
+
+// position after the last product of a page
+type listCursor struct {
+	Sort  string    `json:"s"`
+	Int   int64     `json:"i,omitempty"`
+	Float float64   `json:"f,omitempty"`
+	Time  time.Time `json:"t,omitempty"`
+	ID    uint      `json:"id"`
+}
+
+func listSorts(priceSQL string) map[string]listSort {
+	return map[string]listSort{
+		SortNewest: {expr: "products.created_at", desc: true, kind: "time"},
+		//products without a rate to the store currency can't be compared, they go last either way
+		//and are listed in their own currency
+		SortPriceAsc:   {expr: fmt.Sprintf("COALESCE(%s, %d)", priceSQL, int64(math.MaxInt64)), kind: "int"},
+		SortPriceDesc:  {expr: fmt.Sprintf("COALESCE(%s, -1)", priceSQL), desc: true, kind: "int"},
+		SortPopularity: {expr: "products.sold_count", desc: true, kind: "int"},
+		SortRating:     {expr: "products.rating_average", desc: true, kind: "float"},
+		SortViews:      {expr: "products.view_count", desc: true, kind: "int"},
+	}
+}
+
+// products matching every filter in the chosen order, a page at a time (keyset pagination, so
+// pages don't shift when products are added while a shopper scrolls)
+func ListProducts(db *gorm.DB, params ListParams) (*ListResult, error) {
+	if params.Sort == "" {
+		params.Sort = SortNewest
+	}
+
+	search := &productSearch{db: db, params: params.ProductFilters}
+	if err := search.prepare(); err != nil {
+		return nil, err
+	}
+
+	order, ok := listSorts(search.priceSQL)[params.Sort]
+	if !ok {
+		return nil, ErrInvalidSort
+	}
+
+	result := &ListResult{Products: []models.Product{}}
+
+	if err := search.matches(facetNone).Count(&result.Total).Error; err != nil {
+		return nil, err
+	}
+
+	direction, compare := "ASC", ">"
+	if order.desc {
+		direction, compare = "DESC", "<"
+	}
+
+	q := search.matches(facetNone)
+
+	if params.Cursor != "" {
+		cursor, err := decodeListCursor(params.Cursor, params.Sort)
+		if err != nil {
+			return nil, err
+		}
+		q = q.Where(fmt.Sprintf("(%s, products.id) %s (?, ?)", order.expr, compare), cursor.value(order.kind), cursor.ID)
+	}
+
+	var rows []struct {
+		ID         uint
+		IntValue   int64
+		FloatValue float64
+		TimeValue  time.Time
+	}
+
+	//one extra row tells whether there is a next page
+	if err := q.Select(fmt.Sprintf("products.id, %s AS %s_value", order.expr, order.kind)).
+		Order(fmt.Sprintf("%s %s, products.id %s", order.expr, direction, direction)).
+		Limit(params.Limit + 1).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	if len(rows) > params.Limit {
+		rows = rows[:params.Limit]
+		last := rows[len(rows)-1]
+		result.NextCursor = encodeListCursor(listCursor{
+			Sort:  params.Sort,
+			Int:   last.IntValue,
+			Float: last.FloatValue,
+			Time:  last.TimeValue,
+			ID:    last.ID,
+		})
+	}
+
+	ids := make([]uint, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+
+	products, err := productsInOrder(db, ids)
+	if err != nil {
+		return nil, err
+	}
+	result.Products = products
+
+	return result, nil
+}
+
+func (c listCursor) value(kind string) interface{} {
+	switch kind {
+	case "float":
+		return c.Float
+	case "time":
+		return c.Time
+	default:
+		return c.Int
+	}
+}
+
+func encodeListCursor(cursor listCursor) string {
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// a cursor only continues the sort it was made for
+func decodeListCursor(raw, sort string) (listCursor, error) {
+	var cursor listCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Sort != sort || cursor.ID == 0 {
+		return cursor, ErrInvalidCursor
+	}
+	return cursor, nil
+}
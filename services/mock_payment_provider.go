@@ -0,0 +1,262 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/junaid9001/spectr_backend/models"
+)
+
+// methods understood by the mock provider
+const (
+	MockMethodSuccess = "mock_card"         //captures straight away
+	MockMethodDecline = "mock_card_decline" //always declined
+	MockMethodDelayed = "mock_card_delayed" //settles after MOCK_SETTLEMENT_SECONDS
+)
+
+type mockIntent struct {
+	amount    models.Money
+	currency  string
+	method    string
+	status    string
+	settleAt  time.Time
+	refunded  models.Money
+	cancelled bool
+}
+
+// delayed payments complete once their settlement time passed
+func (i *mockIntent) settle() {
+	if i.settleAt.IsZero() {
+		return
+	}
+	if time.Now().Before(i.settleAt) {
+		i.status = PaymentStatusProcessing
+	} else {
+		i.status = PaymentStatusCompleted
+	}
+}
+
+// in memory provider for local development, nothing leaves the process
+type MockPaymentProvider struct {
+	mu      sync.Mutex
+	intents map[string]*mockIntent
+	refunds map[string]*RefundResult //by idempotency key
+}
+
+func NewMockPaymentProvider() *MockPaymentProvider {
+	return &MockPaymentProvider{intents: make(map[string]*mockIntent), refunds: make(map[string]*RefundResult)}
+}
+
+func (m *MockPaymentProvider) Name() string {
+	return "mock"
+}
+
+func (m *MockPaymentProvider) CreateIntent(amount models.Money, currency, reference, method string) (*PaymentIntent, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("amount must be greater than zero")
+	}
+
+	switch method {
+	case "":
+		method = MockMethodSuccess
+	case MockMethodSuccess, MockMethodDecline, MockMethodDelayed:
+	default:
+		return nil, fmt.Errorf("unsupported payment method %q", method)
+	}
+
+	ref, err := randomRef("mock_pi")
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.intents[ref] = &mockIntent{amount: amount, currency: currency, method: method, status: PaymentStatusPending}
+	m.mu.Unlock()
+
+	return &PaymentIntent{
+		ProviderRef:  ref,
+		ClientSecret: ref + "_secret_" + reference,
+		Status:       PaymentStatusPending,
+	}, nil
+}
+
+func (m *MockPaymentProvider) Capture(providerRef string) (*PaymentResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	intent, ok := m.intents[providerRef]
+	if !ok {
+		return nil, fmt.Errorf("unknown payment %s", providerRef)
+	}
+
+	result := &PaymentResult{ProviderRef: providerRef}
+
+	switch {
+	case intent.cancelled:
+		result.FailureReason = "payment was cancelled"
+
+	case intent.method == MockMethodDecline:
+		intent.status = PaymentStatusFailed
+		result.FailureReason = "card declined"
+
+	case intent.method == MockMethodDelayed:
+		//first capture starts settlement, later ones finish it once the delay passed
+		if intent.settleAt.IsZero() {
+			intent.settleAt = time.Now().Add(mockSettlementDelay())
+		}
+		intent.settle()
+
+	default:
+		intent.status = PaymentStatusCompleted
+	}
+
+	result.Status = intent.status
+	return result, nil
+}
+
+func (m *MockPaymentProvider) Cancel(providerRef string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	intent, ok := m.intents[providerRef]
+	if !ok {
+		return fmt.Errorf("unknown payment %s", providerRef)
+	}
+
+	intent.settle()
+	if intent.status == PaymentStatusCompleted || intent.status == PaymentStatusProcessing {
+		return fmt.Errorf("payment %s is already captured", providerRef)
+	}
+
+	intent.status = PaymentStatusFailed
+	intent.cancelled = true
+	return nil
+}
+
+func (m *MockPaymentProvider) Refund(providerRef string, amount models.Money, idempotencyKey string) (*RefundResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if done, ok := m.refunds[idempotencyKey]; ok && idempotencyKey != "" {
+		return done, nil
+	}
+
+	intent, ok := m.intents[providerRef]
+	if !ok {
+		return nil, fmt.Errorf("unknown payment %s", providerRef)
+	}
+
+	intent.settle()
+	if intent.status != PaymentStatusCompleted {
+		return nil, fmt.Errorf("payment %s is not captured", providerRef)
+	}
+
+	if intent.refunded+amount > intent.amount {
+		return nil, fmt.Errorf("refund exceeds captured amount")
+	}
+	intent.refunded += amount
+
+	ref, err := randomRef("mock_re")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RefundResult{ProviderRef: ref, Status: PaymentStatusCompleted}
+	if idempotencyKey != "" {
+		m.refunds[idempotencyKey] = result
+	}
+	return result, nil
+}
+
+// hex hmac-sha256 of the body with PAYMENT_WEBHOOK_SECRET
+func (m *MockPaymentProvider) VerifyWebhookSignature(payload []byte, signature string) bool {
+	expected := SignWebhookPayload(payload)
+	if expected == "" {
+		return false
+	}
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// mock body: {"id": "evt_1", "type": "payment.succeeded", "data": {"provider_ref": "...", "failure_reason": ""}}
+func (m *MockPaymentProvider) ParseWebhookEvent(payload []byte) (*WebhookEvent, error) {
+	var body struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			ProviderRef   string `json:"provider_ref"`
+			FailureReason string `json:"failure_reason"`
+		} `json:"data"`
+	}
+
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return nil, err
+	}
+
+	if body.ID == "" || body.Type == "" {
+		return nil, fmt.Errorf("event id and type are required")
+	}
+
+	return &WebhookEvent{
+		EventID:       body.ID,
+		Type:          body.Type,
+		ProviderRef:   body.Data.ProviderRef,
+		FailureReason: body.Data.FailureReason,
+	}, nil
+}
+
+// callbacks to the mock are simulated, so an applied event is what settles the intent, like it
+// would have at a real provider (a payment that succeeded by webhook can be refunded later)
+func (m *MockPaymentProvider) ObservePaymentEvent(event WebhookEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	intent, ok := m.intents[event.ProviderRef]
+	if !ok {
+		return
+	}
+
+	switch event.Type {
+	case PaymentEventSucceeded:
+		intent.status = PaymentStatusCompleted
+		intent.settleAt = time.Time{}
+	case PaymentEventFailed:
+		intent.status = PaymentStatusFailed
+		intent.settleAt = time.Time{}
+	}
+}
+
+// signature the mock expects, handy for simulating provider callbacks
+func SignWebhookPayload(payload []byte) string {
+	secret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+	if secret == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func mockSettlementDelay() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("MOCK_SETTLEMENT_SECONDS"))
+	if err != nil || seconds < 0 {
+		seconds = 30
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func randomRef(prefix string) (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + "_" + hex.EncodeToString(b), nil
+}
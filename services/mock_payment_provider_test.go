@@ -0,0 +1,71 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	t.Setenv("PAYMENT_WEBHOOK_SECRET", "whsec_test")
+	provider := NewMockPaymentProvider()
+
+	payload := []byte(`{"id":"evt_1","type":"payment.succeeded","data":{"provider_ref":"mock_pi_1"}}`)
+	signature := SignWebhookPayload(payload)
+
+	//hex hmac-sha256 of the body
+	if len(signature) != 64 || strings.Trim(signature, "0123456789abcdef") != "" {
+		t.Fatalf("signature %q is not hex sha256", signature)
+	}
+	if SignWebhookPayload(payload) != signature {
+		t.Fatal("signing the same body twice differs")
+	}
+
+	for name, tc := range map[string]struct {
+		payload   []byte
+		signature string
+		want      bool
+	}{
+		"valid":           {payload, signature, true},
+		"changed body":    {[]byte(strings.Replace(string(payload), "succeeded", "failed", 1)), signature, false},
+		"upper case":      {payload, strings.ToUpper(signature), false},
+		"truncated":       {payload, signature[:63], false},
+		"empty signature": {payload, "", false},
+		"other body":      {[]byte(`{}`), signature, false},
+	} {
+		if got := provider.VerifyWebhookSignature(tc.payload, tc.signature); got != tc.want {
+			t.Errorf("%s: verified = %v, want %v", name, got, tc.want)
+		}
+	}
+
+	t.Setenv("PAYMENT_WEBHOOK_SECRET", "whsec_other")
+	if provider.VerifyWebhookSignature(payload, signature) {
+		t.Error("signature accepted after the secret changed")
+	}
+
+	//without a secret nothing is signed and nothing verifies
+	t.Setenv("PAYMENT_WEBHOOK_SECRET", "")
+	if got := SignWebhookPayload(payload); got != "" {
+		t.Errorf("signed without a secret: %q", got)
+	}
+	if provider.VerifyWebhookSignature(payload, "") {
+		t.Error("empty signature accepted without a secret")
+	}
+}
+
+func TestParseWebhookEvent(t *testing.T) {
+	provider := NewMockPaymentProvider()
+
+	event, err := provider.ParseWebhookEvent([]byte(`{"id":"evt_2","type":"payment.failed","data":{"provider_ref":"mock_pi_2","failure_reason":"card declined"}}`))
+	if err != nil {
+		t.Fatalf("ParseWebhookEvent: %v", err)
+	}
+	if event.EventID != "evt_2" || event.Type != PaymentEventFailed || event.ProviderRef != "mock_pi_2" || event.FailureReason != "card declined" {
+		t.Fatalf("parsed %+v", event)
+	}
+
+	for _, payload := range []string{`not json`, `{"type":"payment.failed"}`, `{"id":"evt_3"}`} {
+		if _, err := provider.ParseWebhookEvent([]byte(payload)); err == nil {
+			t.Errorf("ParseWebhookEvent(%s) accepted", payload)
+		}
+	}
+}
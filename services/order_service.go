@@ -0,0 +1,233 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidTransition = errors.New("invalid order status transition")
+
+// who is changing the order, UserID 0 = system
+type OrderActor struct {
+	UserID uint
+	Role   string
+}
+
+var SystemActor = OrderActor{Role: "system"}
+
+// state handed to side effects of one transition
+type transitionRun struct {
+	tx          *gorm.DB
+	order       *models.Order
+	afterCommit []func(db *gorm.DB)
+}
+
+// queue work (mails) that must only happen once the change is saved
+func (r *transitionRun) onCommit(fn func(db *gorm.DB)) {
+	r.afterCommit = append(r.afterCommit, fn)
+}
+
+// side effect run inside the transition transaction
+type orderEffect func(run *transitionRun) error
+
+type orderTransition struct {
+	allowUser bool //customer may trigger it on their own order
+	effects   []orderEffect
+}
+
+// from -> to -> what happens, anything not listed is rejected
+var orderTransitions = map[string]map[string]orderTransition{
+	models.OrderStatusPending: {
+		models.OrderStatusConfirmed: {},
+		models.OrderStatusCancelled: {allowUser: true, effects: []orderEffect{restockOrder, reverseSaleStats, refundPaidOrder, releaseCoupon}},
+	},
+	models.OrderStatusConfirmed: {
+		models.OrderStatusPacked:    {},
+		models.OrderStatusCancelled: {allowUser: true, effects: []orderEffect{restockOrder, reverseSaleStats, refundPaidOrder, releaseCoupon}},
+	},
+	models.OrderStatusPacked: {
+		models.OrderStatusShipped:   {},
+		models.OrderStatusCancelled: {effects: []orderEffect{restockOrder, reverseSaleStats, refundPaidOrder, releaseCoupon}},
+	},
+	models.OrderStatusShipped: {
+		models.OrderStatusOutForDelivery: {},
+		models.OrderStatusDelivered:      {effects: []orderEffect{stampDelivered}},
+	},
+	models.OrderStatusOutForDelivery: {
+		models.OrderStatusDelivered: {effects: []orderEffect{stampDelivered}},
+		models.OrderStatusReturned:  {effects: []orderEffect{restockOrder, reverseSaleStats, refundPaidOrder}}, //failed delivery
+	},
+	models.OrderStatusDelivered: {
+		models.OrderStatusReturned: {effects: []orderEffect{restockOrder, reverseSaleStats, refundPaidOrder}},
+	},
+}
+
+// statuses an order can be moved to from its current one
+func NextOrderStatuses(from string) []string {
+	next := make([]string, 0, len(orderTransitions[from]))
+	for to := range orderTransitions[from] {
+		next = append(next, to)
+	}
+	return next
+}
+
+// no transition leaves these
+func IsFinalOrderStatus(status string) bool {
+	return len(orderTransitions[status]) == 0
+}
+
+// validate and apply a status change with its side effects and a history row
+func ChangeOrderStatus(db *gorm.DB, orderId uint, to string, actor OrderActor, note string) (*models.Order, error) {
+	var afterCommit []func(db *gorm.DB)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		afterCommit, err = ChangeOrderStatusTx(tx, orderId, to, actor, note)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fn := range afterCommit {
+		fn(db)
+	}
+
+	var order models.Order
+	if err := db.Preload("OrderItems").Preload("StatusHistory").First(&order, orderId).Error; err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// ChangeOrderStatus inside the callers transaction, the returned work (mails, provider refunds)
+// has to be run by the caller once it committed
+func ChangeOrderStatusTx(tx *gorm.DB, orderId uint, to string, actor OrderActor, note string) ([]func(db *gorm.DB), error) {
+	var order models.Order
+
+	//lock so two status changes can't interleave
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderId).Error; err != nil {
+		return nil, err
+	}
+
+	if order.Status == to {
+		return nil, nil
+	}
+
+	transition, ok := orderTransitions[order.Status][to]
+	if !ok || (actor.Role == "user" && !transition.allowUser) {
+		return nil, fmt.Errorf("%w: cannot move order from %s to %s", ErrInvalidTransition, order.Status, to)
+	}
+
+	if err := tx.Preload("OrderItems").First(&order, orderId).Error; err != nil {
+		return nil, err
+	}
+
+	from := order.Status
+
+	if err := tx.Model(&order).Update("status", to).Error; err != nil {
+		return nil, err
+	}
+	order.Status = to
+
+	run := &transitionRun{tx: tx, order: &order}
+	for _, effect := range transition.effects {
+		if err := effect(run); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := RecordOrderStatus(tx, order.ID, from, to, actor, note); err != nil {
+		return nil, err
+	}
+	return run.afterCommit, nil
+}
+
+// append an entry to the orders status history
+func RecordOrderStatus(tx *gorm.DB, orderId uint, from, to string, actor OrderActor, note string) error {
+	entry := models.OrderStatusHistory{
+		OrderID:     orderId,
+		FromStatus:  from,
+		ToStatus:    to,
+		ChangedBy:   actor.UserID,
+		ChangedRole: actor.Role,
+		Note:        note,
+	}
+	return tx.Create(&entry).Error
+}
+
+//---------------- side effects ----------------
+
+// put ordered units back on the shelf, units that came back through a return were handled there
+func restockOrder(run *transitionRun) error {
+	for _, val := range run.order.OrderItems {
+		quantity := val.Quantity - val.ReturnedQuantity
+		if quantity <= 0 {
+			continue
+		}
+
+		if err := AdjustStock(run.tx, val.ProductID, VariantKey(val.VariantID), quantity); err != nil {
+			return err
+		}
+		if err := AdjustSoldCount(run.tx, val.ProductID, -quantity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// undo the sale counters bumped when the order was paid, also when the money already went back
+// through returns (counters are only ever reversed here, once per order)
+func reverseSaleStats(run *transitionRun) error {
+	if !isPaidStatus(run.order.PaymentStatus) && run.order.PaymentStatus != PaymentStatusRefunded {
+		return nil
+	}
+
+	//same counting as when the payment was confirmed
+	totalProducts := len(run.order.OrderItems)
+
+	return run.tx.Model(&models.AppStats{}).Where("id=?", 1).Updates(map[string]interface{}{
+		"total_sales":         gorm.Expr("total_sales - ?", 1),
+		"total_products_sold": gorm.Expr("total_products_sold - ?", totalProducts),
+	}).Error
+}
+
+// paid orders that are called off get back whatever was not refunded yet
+func refundPaidOrder(run *transitionRun) error {
+	if !isPaidStatus(run.order.PaymentStatus) {
+		return nil
+	}
+
+	refund, err := IssueRefund(run.tx, run.order.ID, nil, "order "+run.order.Status, 0)
+	if err != nil {
+		if errors.Is(err, ErrNothingToRefund) {
+			return nil
+		}
+		return err
+	}
+
+	run.onCommit(func(db *gorm.DB) {
+		if err := SettleRefund(db, refund.ID); err != nil {
+			log.Printf("refund %d stays pending: %v\n", refund.ID, err)
+		}
+	})
+	return nil
+}
+
+// a cancelled order gives its coupon use back
+func releaseCoupon(run *transitionRun) error {
+	return ReleaseCouponRedemption(run.tx, run.order.ID)
+}
+
+func stampDelivered(run *transitionRun) error {
+	now := time.Now()
+	run.order.DeliveredAt = &now
+	return run.tx.Model(run.order).Update("delivered_at", now).Error
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/junaid9001/spectr_backend/models"
+)
+
+func TestNextOrderStatuses(t *testing.T) {
+	for _, tc := range []struct {
+		from  string
+		want  []string
+		final bool
+	}{
+		{models.OrderStatusPending, []string{models.OrderStatusCancelled, models.OrderStatusConfirmed}, false},
+		{models.OrderStatusConfirmed, []string{models.OrderStatusCancelled, models.OrderStatusPacked}, false},
+		{models.OrderStatusPacked, []string{models.OrderStatusCancelled, models.OrderStatusShipped}, false},
+		{models.OrderStatusShipped, []string{models.OrderStatusDelivered, models.OrderStatusOutForDelivery}, false},
+		{models.OrderStatusOutForDelivery, []string{models.OrderStatusDelivered, models.OrderStatusReturned}, false},
+		{models.OrderStatusDelivered, []string{models.OrderStatusReturned}, false},
+		{models.OrderStatusCancelled, []string{}, true},
+		{models.OrderStatusReturned, []string{}, true},
+		{"unknown", []string{}, true},
+	} {
+		got := NextOrderStatuses(tc.from)
+		slices.Sort(got)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("NextOrderStatuses(%q) = %v, want %v", tc.from, got, tc.want)
+		}
+		if final := IsFinalOrderStatus(tc.from); final != tc.final {
+			t.Errorf("IsFinalOrderStatus(%q) = %v, want %v", tc.from, final, tc.final)
+		}
+	}
+}
+
+func TestOrderTransitions(t *testing.T) {
+	cancelEffects := []orderEffect{restockOrder, reverseSaleStats, refundPaidOrder, releaseCoupon}
+	returnEffects := []orderEffect{restockOrder, reverseSaleStats, refundPaidOrder}
+
+	for _, tc := range []struct {
+		from, to  string
+		ok        bool
+		allowUser bool
+		effects   []orderEffect
+	}{
+		{models.OrderStatusPending, models.OrderStatusConfirmed, true, false, nil},
+		{models.OrderStatusPending, models.OrderStatusCancelled, true, true, cancelEffects},
+		{models.OrderStatusConfirmed, models.OrderStatusCancelled, true, true, cancelEffects},
+		{models.OrderStatusPacked, models.OrderStatusCancelled, true, false, cancelEffects},
+		{models.OrderStatusShipped, models.OrderStatusDelivered, true, false, []orderEffect{stampDelivered}},
+		{models.OrderStatusOutForDelivery, models.OrderStatusReturned, true, false, returnEffects},
+		{models.OrderStatusDelivered, models.OrderStatusReturned, true, false, returnEffects},
+
+		//skipping steps, going back and leaving a final status are rejected
+		{models.OrderStatusPending, models.OrderStatusShipped, false, false, nil},
+		{models.OrderStatusPacked, models.OrderStatusConfirmed, false, false, nil},
+		{models.OrderStatusShipped, models.OrderStatusCancelled, false, false, nil},
+		{models.OrderStatusDelivered, models.OrderStatusCancelled, false, false, nil},
+		{models.OrderStatusCancelled, models.OrderStatusPending, false, false, nil},
+		{models.OrderStatusReturned, models.OrderStatusDelivered, false, false, nil},
+		{models.OrderStatusPending, models.OrderStatusPending, false, false, nil},
+	} {
+		transition, ok := orderTransitions[tc.from][tc.to]
+		if ok != tc.ok {
+			t.Errorf("%s -> %s allowed = %v, want %v", tc.from, tc.to, ok, tc.ok)
+			continue
+		}
+		if transition.allowUser != tc.allowUser {
+			t.Errorf("%s -> %s allowUser = %v, want %v", tc.from, tc.to, transition.allowUser, tc.allowUser)
+		}
+		if !sameEffects(transition.effects, tc.effects) {
+			t.Errorf("%s -> %s runs other effects than expected", tc.from, tc.to)
+		}
+	}
+}
+
+// funcs can't be compared, their code pointers can
+func sameEffects(got, want []orderEffect) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if reflect.ValueOf(got[i]).Pointer() != reflect.ValueOf(want[i]).Pointer() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// payment event states
+const (
+	PaymentEventStatusReceived  = "received"
+	PaymentEventStatusProcessed = "processed"
+	PaymentEventStatusFailed    = "failed"
+)
+
+var ErrPaymentEventProcessed = errors.New("payment event already processed")
+
+// store an incoming event, returns false when the provider already sent this event id
+func StorePaymentEvent(db *gorm.DB, provider string, event *WebhookEvent, payload []byte) (*models.PaymentEvent, bool, error) {
+	stored := models.PaymentEvent{
+		Provider:        provider,
+		ProviderEventID: event.EventID,
+		EventType:       event.Type,
+		ProviderRef:     event.ProviderRef,
+		Payload:         string(payload),
+		FailureReason:   event.FailureReason,
+		Status:          PaymentEventStatusReceived,
+	}
+
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&stored)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+
+	return &stored, result.RowsAffected > 0, nil
+}
+
+// apply a stored event exactly once, the outcome is saved on the event row
+func ProcessPaymentEvent(db *gorm.DB, eventId uint) (*models.PaymentEvent, error) {
+	var afterCommit []func(db *gorm.DB)
+	var applied models.PaymentEvent
+
+	processErr := db.Transaction(func(tx *gorm.DB) error {
+		var event models.PaymentEvent
+
+		//lock so a retry and a delivery can't both apply it
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&event, eventId).Error; err != nil {
+			return err
+		}
+
+		if event.Status == PaymentEventStatusProcessed {
+			return ErrPaymentEventProcessed
+		}
+
+		var err error
+		if afterCommit, err = applyPaymentEvent(tx, &event); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&event).Updates(map[string]interface{}{
+			"status":       PaymentEventStatusProcessed,
+			"error":        "",
+			"attempts":     gorm.Expr("attempts + ?", 1),
+			"processed_at": now,
+		}).Error; err != nil {
+			return err
+		}
+		applied = event
+		return nil
+	})
+
+	if processErr == nil {
+		//the provider hears of the outcome first, a refund sent after commit needs the capture
+		notifyProvider(applied)
+		for _, fn := range afterCommit {
+			fn(db)
+		}
+	}
+
+	if processErr != nil && !errors.Is(processErr, ErrPaymentEventProcessed) {
+		//the transaction rolled back, note the failure so an admin can retry
+		if err := db.Model(&models.PaymentEvent{}).Where("id=?", eventId).Updates(map[string]interface{}{
+			"status":   PaymentEventStatusFailed,
+			"error":    processErr.Error(),
+			"attempts": gorm.Expr("attempts + ?", 1),
+		}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var event models.PaymentEvent
+	if err := db.First(&event, eventId).Error; err != nil {
+		return nil, err
+	}
+
+	if errors.Is(processErr, ErrPaymentEventProcessed) {
+		return &event, nil
+	}
+	return &event, processErr
+}
+
+// work that has to wait for the commit (mails) is returned
+func applyPaymentEvent(tx *gorm.DB, event *models.PaymentEvent) ([]func(db *gorm.DB), error) {
+	var payment models.Payment
+
+	if err := tx.Where("provider=? AND provider_ref=?", event.Provider, event.ProviderRef).First(&payment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("no payment with provider ref %s", event.ProviderRef)
+		}
+		return nil, err
+	}
+
+	switch event.EventType {
+	case PaymentEventSucceeded:
+		//a capture that can't count for the order is refunded, the event is still processed
+		_, afterCommit, err := ApplyCapturedPayment(tx, payment.ID)
+		return afterCommit, err
+
+	case PaymentEventFailed:
+		reason := event.FailureReason
+		if reason == "" {
+			reason = "declined by provider"
+		}
+		return nil, FailPayment(tx, payment.ID, reason)
+	}
+
+	//events we don't act on are just recorded
+	return nil, nil
+}
+
+// providers that only learn an outcome through the webhook (the mock, whose callbacks are
+// simulated) are told once an event was applied, parsing an event never changes them
+type paymentEventObserver interface {
+	ObservePaymentEvent(event WebhookEvent)
+}
+
+func notifyProvider(event models.PaymentEvent) {
+	provider, err := GetPaymentProvider(event.Provider)
+	if err != nil {
+		return
+	}
+	if observer, ok := provider.(paymentEventObserver); ok {
+		observer.ObservePaymentEvent(WebhookEvent{
+			EventID:       event.ProviderEventID,
+			Type:          event.EventType,
+			ProviderRef:   event.ProviderRef,
+			FailureReason: event.FailureReason,
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/junaid9001/spectr_backend/models"
+)
+
+// payment states shared by providers and the payments table
+const (
+	PaymentStatusPending    = "pending"
+	PaymentStatusProcessing = "processing" //accepted, settlement not done yet
+	PaymentStatusCompleted  = "completed"
+	PaymentStatusFailed     = "failed"
+)
+
+// what the client needs to continue paying with the provider
+type PaymentIntent struct {
+	ProviderRef  string `json:"provider_ref"`
+	ClientSecret string `json:"client_secret"`
+	Status       string `json:"status"`
+}
+
+type PaymentResult struct {
+	ProviderRef   string `json:"provider_ref"`
+	Status        string `json:"status"`
+	FailureReason string `json:"failure_reason,omitempty"`
+}
+
+// provider webhook translated to our terms
+type WebhookEvent struct {
+	EventID       string
+	Type          string //one of the PaymentEvent* types
+	ProviderRef   string
+	FailureReason string
+}
+
+// webhook event types
+const (
+	PaymentEventSucceeded = "payment.succeeded"
+	PaymentEventFailed    = "payment.failed"
+)
+
+type RefundResult struct {
+	ProviderRef string `json:"provider_ref"`
+	Status      string `json:"status"`
+}
+
+// a payment gateway (mock, stripe, razorpay ...)
+type PaymentProvider interface {
+	Name() string
+	// start a payment for amount in minor units of currency, reference is our own id (order)
+	CreateIntent(amount models.Money, currency, reference, method string) (*PaymentIntent, error)
+	// collect the money of an intent
+	Capture(providerRef string) (*PaymentResult, error)
+	// call off an intent that wasn't paid, fails when the money was already captured
+	Cancel(providerRef string) error
+	// give back amount of a captured payment, a retry with the same idempotency key returns the
+	// first result instead of refunding again
+	Refund(providerRef string, amount models.Money, idempotencyKey string) (*RefundResult, error)
+	// check a webhook body was sent by the provider
+	VerifyWebhookSignature(payload []byte, signature string) bool
+	// read a verified webhook body
+	ParseWebhookEvent(payload []byte) (*WebhookEvent, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]PaymentProvider{}
+)
+
+// make a provider selectable by its name
+func RegisterPaymentProvider(provider PaymentProvider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	providers[provider.Name()] = provider
+}
+
+// provider by name, empty name = PAYMENT_PROVIDER env (default mock)
+func GetPaymentProvider(name string) (PaymentProvider, error) {
+	if name == "" {
+		name = os.Getenv("PAYMENT_PROVIDER")
+	}
+	if name == "" {
+		name = "mock"
+	}
+
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	provider, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("payment provider %q is not registered", name)
+	}
+	return provider, nil
+}
+
+func init() {
+	RegisterPaymentProvider(NewMockPaymentProvider())
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"errors"
+	"log"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPaymentAmountMismatch = errors.New("amount mismatch")
+	ErrOrderAlreadyPaid      = errors.New("order is already paid")
+	ErrOrderNotPayable       = errors.New("order can no longer be paid")
+)
+
+// an order takes a payment while it is pending or confirmed and nothing was paid yet
+func CheckOrderPayable(order *models.Order) error {
+	//completed, or (partially) refunded after it
+	if order.PaymentStatus != PaymentStatusPending {
+		return ErrOrderAlreadyPaid
+	}
+	//cancelled / returned orders put their stock back
+	if order.Status != models.OrderStatusPending && order.Status != models.OrderStatusConfirmed {
+		return ErrOrderNotPayable
+	}
+	return nil
+}
+
+// mark a payment and its order paid, bump stats and confirm the order inside the callers
+// transaction, the returned work (mails) has to be run by the caller once it committed.
+// safe to call twice, an already completed payment is left alone. a second payment of an order
+// that was paid or closed meanwhile fails with ErrOrderAlreadyPaid / ErrOrderNotPayable
+func CompletePayment(tx *gorm.DB, paymentId uint) ([]func(db *gorm.DB), error) {
+	var payment models.Payment
+
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, paymentId).Error; err != nil {
+		return nil, err
+	}
+
+	if payment.PaymentStatus == PaymentStatusCompleted {
+		return nil, nil
+	}
+
+	var order models.Order
+
+	//locked so two payments of one order can't both be applied
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, payment.OrderID).Error; err != nil {
+		return nil, err
+	}
+
+	if err := CheckOrderPayable(&order); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Where("order_id=?", order.ID).Find(&order.OrderItems).Error; err != nil {
+		return nil, err
+	}
+
+	//exact minor unit compare, currency has to match too
+	if order.TotalAmount != payment.Amount || order.Currency != payment.Currency {
+		return nil, ErrPaymentAmountMismatch
+	}
+
+	if err := tx.Model(&payment).Updates(map[string]interface{}{
+		"payment_status": PaymentStatusCompleted,
+		"failure_reason": "",
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Model(&order).Update("payment_status", PaymentStatusCompleted).Error; err != nil {
+		return nil, err
+	}
+
+	totalProducts := len(order.OrderItems)
+	totalAmount := toBaseCurrency(&order, payment.Amount)
+
+	if err := tx.Model(&models.AppStats{}).Where("id=?", 1).Updates(map[string]interface{}{
+		"total_sales":         gorm.Expr("total_sales + ?", 1),
+		"total_products_sold": gorm.Expr("total_products_sold + ?", totalProducts),
+		"total_revenue":       gorm.Expr("total_revenue + ?", totalAmount),
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	//paid orders move on to confirmed
+	if order.Status != models.OrderStatusPending {
+		return nil, nil
+	}
+	return ChangeOrderStatusTx(tx, order.ID, models.OrderStatusConfirmed, SystemActor, "payment received")
+}
+
+// a payment the provider reports as captured. one that can't count for its order anymore (it was
+// replaced by a newer payment, or the order was paid or closed meanwhile) is booked for a full
+// refund instead, the refund is returned then. the returned work (mails, sending the refund) has
+// to be run by the caller once it committed
+func ApplyCapturedPayment(tx *gorm.DB, paymentId uint) (*models.Refund, []func(db *gorm.DB), error) {
+	var payment models.Payment
+
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, paymentId).Error; err != nil {
+		return nil, nil, err
+	}
+
+	switch payment.PaymentStatus {
+	case PaymentStatusRefunded, PaymentStatusPartiallyRefunded:
+		//given back already, a repeated capture report changes nothing
+		return nil, nil, nil
+	case PaymentStatusFailed:
+		return refundStrayPayment(tx, &payment, "payment was replaced by a newer one")
+	}
+
+	afterCommit, err := CompletePayment(tx, paymentId)
+	if errors.Is(err, ErrOrderAlreadyPaid) || errors.Is(err, ErrOrderNotPayable) {
+		return refundStrayPayment(tx, &payment, err.Error())
+	}
+	return nil, afterCommit, err
+}
+
+// book the whole captured amount of a payment that never counted for its order as a refund, the
+// order, its lines and the stats are left alone
+func refundStrayPayment(tx *gorm.DB, payment *models.Payment, reason string) (*models.Refund, []func(db *gorm.DB), error) {
+	refund := models.Refund{
+		OrderID:   payment.OrderID,
+		PaymentID: payment.ID,
+		Amount:    payment.Amount,
+		Currency:  payment.Currency,
+		Reason:    "payment captured after it was no longer needed: " + reason,
+		Status:    RefundStatusPending,
+	}
+
+	if err := tx.Create(&refund).Error; err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Model(payment).Updates(map[string]interface{}{
+		"payment_status":  PaymentStatusRefunded,
+		"refunded_amount": payment.Amount,
+		"failure_reason":  reason,
+	}).Error; err != nil {
+		return nil, nil, err
+	}
+
+	settle := func(db *gorm.DB) {
+		if err := SettleRefund(db, refund.ID); err != nil {
+			log.Printf("refund %d stays pending: %v\n", refund.ID, err)
+		}
+	}
+	return &refund, []func(db *gorm.DB){settle}, nil
+}
+
+// record a declined payment, the order stays open for another attempt. payments that were
+// captured or given back keep their status
+func FailPayment(tx *gorm.DB, paymentId uint, reason string) error {
+	return tx.Model(&models.Payment{}).
+		Where("id=? AND payment_status IN ?", paymentId, []string{PaymentStatusPending, PaymentStatusProcessing}).
+		Updates(map[string]interface{}{
+			"payment_status": PaymentStatusFailed,
+			"failure_reason": reason,
+		}).Error
+}
+
+// amount of an order in the store currency it was placed against, for stats
+func toBaseCurrency(order *models.Order, amount models.Money) models.Money {
+	if order.ExchangeRate <= 0 || order.Currency == order.BaseCurrency {
+		return amount
+	}
+	return amount.Convert(1 / order.ExchangeRate)
+}
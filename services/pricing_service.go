@@ -0,0 +1,218 @@
+package services
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+)
+
+var ErrAddressRequired = errors.New("tax and shipping depend on the destination, order to a saved address (address_id)")
+
+// price of one cart line
+type LinePrice struct {
+	ProductID uint         `json:"product_id"`
+	UnitPrice models.Money `json:"unit_price"`
+	Quantity  int          `json:"quantity"`
+	Subtotal  models.Money `json:"subtotal"`
+	Discount  models.Money `json:"discount"`
+	TaxRate   float64      `json:"tax_rate"` //percent
+	Tax       models.Money `json:"tax"`
+	Total     models.Money `json:"total"`
+}
+
+// every component of what a cart costs, used by the cart view and by PlaceOrder
+// PriceCart works in the store currency, ConvertBreakdown moves it to another one
+type PriceBreakdown struct {
+	Items        []LinePrice  `json:"items"` //same order as the cart items
+	Subtotal     models.Money `json:"subtotal"`
+	Discount     models.Money `json:"discount"`
+	Tax          models.Money `json:"tax"`
+	Shipping     models.Money `json:"shipping"`
+	Total        models.Money `json:"total"`
+	WeightGrams  int          `json:"weight_grams"`
+	FreeShipping bool         `json:"free_shipping"`
+	Currency     string       `json:"currency"`
+}
+
+// subtotal -> coupon discount -> tax on the discounted lines -> shipping
+// dest can be nil, then only rates and rules without a region apply
+func PriceCart(db *gorm.DB, items []models.CartItem, coupon *CouponResult, dest *models.AddressSnapshot) (*PriceBreakdown, error) {
+	breakdown := &PriceBreakdown{
+		Items:    make([]LinePrice, len(items)),
+		Currency: models.DefaultCurrency(),
+	}
+
+	var rates []models.TaxRate
+	if err := db.Where("is_active = ?", true).Find(&rates).Error; err != nil {
+		return nil, err
+	}
+
+	parents := make(map[uint]*uint)
+
+	for i, item := range items {
+		line := LinePrice{
+			ProductID: item.ProductId,
+			UnitPrice: item.UnitPrice,
+			Quantity:  item.Quantity,
+			Subtotal:  item.TotalPrice,
+		}
+
+		if coupon != nil {
+			line.Discount = coupon.ItemDiscounts[i]
+		}
+
+		chain, err := categoryChain(db, item.Product.CategoryID, parents)
+		if err != nil {
+			return nil, err
+		}
+
+		line.TaxRate = taxRateFor(rates, chain, dest)
+		line.Tax = (line.Subtotal - line.Discount).Percent(line.TaxRate)
+		line.Total = line.Subtotal - line.Discount + line.Tax
+
+		breakdown.Items[i] = line
+		breakdown.Subtotal += line.Subtotal
+		breakdown.Discount += line.Discount
+		breakdown.Tax += line.Tax
+		breakdown.WeightGrams += item.Product.WeightGrams * item.Quantity
+	}
+
+	if coupon != nil && coupon.FreeShipping {
+		breakdown.FreeShipping = true
+	} else {
+		shipping, err := shippingFee(db, breakdown.Subtotal-breakdown.Discount, breakdown.WeightGrams, dest)
+		if err != nil {
+			return nil, err
+		}
+		breakdown.Shipping = shipping
+		breakdown.FreeShipping = shipping == 0
+	}
+
+	breakdown.Total = breakdown.Subtotal - breakdown.Discount + breakdown.Tax + breakdown.Shipping
+	return breakdown, nil
+}
+
+// category and its parents, closest first, ending with nil for rates without a category
+func categoryChain(db *gorm.DB, categoryId *uint, parents map[uint]*uint) ([]*uint, error) {
+	var chain []*uint
+	visited := make(map[uint]bool)
+
+	for categoryId != nil && !visited[*categoryId] {
+		visited[*categoryId] = true
+		chain = append(chain, categoryId)
+
+		parent, ok := parents[*categoryId]
+		if !ok {
+			var category models.Category
+			if err := db.Select("id", "parent_id").First(&category, *categoryId).Error; err != nil {
+				if !errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil, err
+				}
+			}
+			parent = category.ParentID
+			parents[*categoryId] = parent
+		}
+		categoryId = parent
+	}
+
+	return append(chain, nil), nil
+}
+
+// whether any active tax rate or shipping rule is tied to a country, then a cart can only be
+// priced for a structured address
+func HasDestinationRules(db *gorm.DB) (bool, error) {
+	var rates, rules int64
+	if err := db.Model(&models.TaxRate{}).Where("is_active = ? AND country <> ''", true).Count(&rates).Error; err != nil {
+		return false, err
+	}
+	if err := db.Model(&models.ShippingRule{}).Where("is_active = ? AND country <> ''", true).Count(&rules).Error; err != nil {
+		return false, err
+	}
+	return rates > 0 || rules > 0, nil
+}
+
+// 0 = doesn't apply, higher = more specific to the destination
+func regionScore(country, state string, dest *models.AddressSnapshot) int {
+	score := 1
+
+	if country != "" {
+		if dest == nil || !strings.EqualFold(country, dest.Country) {
+			return 0
+		}
+		score++
+	}
+	if state != "" {
+		if dest == nil || !strings.EqualFold(state, dest.State) {
+			return 0
+		}
+		score++
+	}
+	return score
+}
+
+// closest category wins, then the most specific region
+func taxRateFor(rates []models.TaxRate, chain []*uint, dest *models.AddressSnapshot) float64 {
+	for _, categoryId := range chain {
+		best, bestScore := 0.0, 0
+
+		for _, rate := range rates {
+			if (rate.CategoryID == nil) != (categoryId == nil) {
+				continue
+			}
+			if categoryId != nil && *rate.CategoryID != *categoryId {
+				continue
+			}
+
+			if score := regionScore(rate.Country, rate.State, dest); score > bestScore {
+				best, bestScore = rate.Rate, score
+			}
+		}
+
+		if bestScore > 0 {
+			return best
+		}
+	}
+	return 0
+}
+
+// a met free_over threshold makes it free, otherwise the most specific
+// flat / weight rule is charged (cheapest on a tie), no rule = free
+func shippingFee(db *gorm.DB, goodsTotal models.Money, weightGrams int, dest *models.AddressSnapshot) (models.Money, error) {
+	var rules []models.ShippingRule
+	if err := db.Where("is_active = ?", true).Find(&rules).Error; err != nil {
+		return 0, err
+	}
+
+	var fee models.Money
+	bestScore := 0
+
+	for _, rule := range rules {
+		score := regionScore(rule.Country, rule.State, dest)
+		if score == 0 {
+			continue
+		}
+
+		var ruleFee models.Money
+		switch rule.Type {
+		case models.ShippingRuleFreeOver:
+			if goodsTotal >= rule.Threshold {
+				return 0, nil
+			}
+			continue
+		case models.ShippingRuleFlat:
+			ruleFee = rule.Amount
+		case models.ShippingRuleWeight:
+			ruleFee = rule.Amount + rule.PerKg.Share(int64(weightGrams), 1000)
+		default:
+			continue
+		}
+
+		if score > bestScore || (score == bestScore && ruleFee < fee) {
+			fee, bestScore = ruleFee, score
+		}
+	}
+
+	return fee, nil
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"time"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+)
+
+// products remembered per viewer, older views are dropped
+const RecentViewsKept = 50
+
+// remember that a user (userId > 0) or an anonymous session opened a product and count the view
+func RecordProductView(db *gorm.DB, productId, userId uint, sessionId string) error {
+	if userId == 0 && sessionId == "" {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		if userId > 0 {
+			if err := tx.Exec(`INSERT INTO product_views (product_id, user_id, session_id, viewed_at) VALUES (?, ?, '', ?)
+				ON CONFLICT (product_id, user_id) WHERE user_id IS NOT NULL DO UPDATE SET viewed_at = EXCLUDED.viewed_at`,
+				productId, userId, now).Error; err != nil {
+				return err
+			}
+		} else {
+			if err := tx.Exec(`INSERT INTO product_views (product_id, session_id, viewed_at) VALUES (?, ?, ?)
+				ON CONFLICT (product_id, session_id) WHERE session_id <> '' DO UPDATE SET viewed_at = EXCLUDED.viewed_at`,
+				productId, sessionId, now).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&models.Product{}).Where("id=?", productId).
+			UpdateColumn("view_count", gorm.Expr("view_count + 1")).Error; err != nil {
+			return err
+		}
+
+		return pruneProductViews(tx, userId, sessionId)
+	})
+}
+
+// move the views of an anonymous session to the user who just logged in, the later view wins
+func MergeProductViews(db *gorm.DB, sessionId string, userId uint) error {
+	if sessionId == "" {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`INSERT INTO product_views (product_id, user_id, session_id, viewed_at)
+			SELECT product_id, ?, '', viewed_at FROM product_views WHERE session_id = ?
+			ON CONFLICT (product_id, user_id) WHERE user_id IS NOT NULL
+			DO UPDATE SET viewed_at = GREATEST(product_views.viewed_at, EXCLUDED.viewed_at)`,
+			userId, sessionId).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("session_id=?", sessionId).Delete(&models.ProductView{}).Error; err != nil {
+			return err
+		}
+
+		return pruneProductViews(tx, userId, "")
+	})
+}
+
+// products the user opened, the latest first, deleted products are skipped
+func RecentlyViewedProducts(db *gorm.DB, userId uint, limit int) ([]models.Product, error) {
+	var ids []uint
+	if err := db.Model(&models.ProductView{}).
+		Joins("JOIN products ON products.id = product_views.product_id AND products.deleted_at IS NULL").
+		Where("product_views.user_id=?", userId).
+		Order("product_views.viewed_at DESC, product_views.id DESC").
+		Limit(limit).
+		Pluck("product_views.product_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return productsInOrder(db, ids)
+}
+
+// keep only the latest views of a viewer
+func pruneProductViews(tx *gorm.DB, userId uint, sessionId string) error {
+	viewer := "session_id = ?"
+	var key interface{} = sessionId
+	if userId > 0 {
+		viewer, key = "user_id = ?", userId
+	}
+
+	return tx.Exec(`DELETE FROM product_views WHERE `+viewer+` AND id NOT IN (
+			SELECT id FROM product_views WHERE `+viewer+` ORDER BY viewed_at DESC, id DESC LIMIT ?
+		)`, key, key, RecentViewsKept).Error
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCannotAnswer   = errors.New("only the store and customers who received this product can answer")
+	ErrQuestionHidden = errors.New("question is not published")
+)
+
+// ask about a product, published right away, admins can hide it later
+func AskQuestion(db *gorm.DB, userId, productId uint, body string) (*models.ProductQuestion, error) {
+	question := models.ProductQuestion{
+		ProductID: productId,
+		UserID:    userId,
+		Body:      body,
+		Status:    models.QuestionStatusPublished,
+		Answers:   []models.ProductAnswer{},
+	}
+
+	if err := db.First(&models.Product{}, productId).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Create(&question).Error; err != nil {
+		return nil, err
+	}
+	return &question, nil
+}
+
+// answer a published question, customers only when they received the product, the asker is
+// emailed after the answer is saved
+func AnswerQuestion(db *gorm.DB, actor OrderActor, questionId uint, body string) (*models.ProductAnswer, error) {
+	answer := models.ProductAnswer{
+		QuestionID: questionId,
+		UserID:     actor.UserID,
+		Body:       body,
+		ByStore:    actor.Role == "admin",
+		Status:     models.QuestionStatusPublished,
+	}
+
+	var question models.ProductQuestion
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&question, questionId).Error; err != nil {
+			return err
+		}
+		if question.Status != models.QuestionStatusPublished {
+			return ErrQuestionHidden
+		}
+
+		if !answer.ByStore {
+			purchased, err := HasDeliveredPurchase(tx, actor.UserID, question.ProductID)
+			if err != nil {
+				return err
+			}
+			if !purchased {
+				return ErrCannotAnswer
+			}
+		}
+
+		if err := tx.Create(&answer).Error; err != nil {
+			return err
+		}
+		return refreshAnswerCount(tx, question.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if question.UserID != actor.UserID {
+		SendQuestionAnsweredEmail(db, &question, &answer)
+	}
+
+	return &answer, nil
+}
+
+// publish or hide a question (admin)
+func ModerateQuestion(db *gorm.DB, questionId uint, status string) (*models.ProductQuestion, error) {
+	var question models.ProductQuestion
+
+	if err := db.First(&question, questionId).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&question).Update("status", status).Error; err != nil {
+		return nil, err
+	}
+
+	if err := WithQuestionAnswers(db, false).First(&question, question.ID).Error; err != nil {
+		return nil, err
+	}
+	return &question, nil
+}
+
+// publish or hide an answer (admin), the question's answer count follows
+func ModerateAnswer(db *gorm.DB, answerId uint, status string) (*models.ProductAnswer, error) {
+	var answer models.ProductAnswer
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&answer, answerId).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&answer).Update("status", status).Error; err != nil {
+			return err
+		}
+		return refreshAnswerCount(tx, answer.QuestionID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &answer, nil
+}
+
+// published questions of a product that have answers, most answered first, for the product page
+func TopAnsweredQuestions(db *gorm.DB, productId uint, limit int) ([]models.ProductQuestion, error) {
+	questions := []models.ProductQuestion{}
+
+	err := WithQuestionAnswers(db, true).
+		Where("product_id=? AND status=? AND answer_count > 0", productId, models.QuestionStatusPublished).
+		Order("answer_count DESC, created_at DESC").
+		Limit(limit).
+		Find(&questions).Error
+	return questions, err
+}
+
+// preload answers, store answers first then oldest first, only published ones when asked
+func WithQuestionAnswers(db *gorm.DB, publishedOnly bool) *gorm.DB {
+	return db.Preload("Answers", func(tx *gorm.DB) *gorm.DB {
+		if publishedOnly {
+			tx = tx.Where("status=?", models.QuestionStatusPublished)
+		}
+		return tx.Order("by_store DESC, created_at ASC, id ASC")
+	})
+}
+
+func refreshAnswerCount(tx *gorm.DB, questionId uint) error {
+	return tx.Model(&models.ProductQuestion{}).Where("id=?", questionId).
+		UpdateColumn("answer_count", gorm.Expr("(SELECT COUNT(*) FROM product_answers WHERE question_id = ? AND status = ?)",
+			questionId, models.QuestionStatusPublished)).Error
+}
+
+// tell the asker their question got an answer, failures are only logged
+func SendQuestionAnsweredEmail(db *gorm.DB, question *models.ProductQuestion, answer *models.ProductAnswer) {
+	var user models.User
+	if err := db.First(&user, question.UserID).Error; err != nil {
+		log.Println("question mail: could not find asker:", err)
+		return
+	}
+
+	var product models.Product
+	if err := db.Unscoped().First(&product, question.ProductID).Error; err != nil {
+		log.Println("question mail: could not find product:", err)
+		return
+	}
+
+	from := "another customer"
+	if answer.ByStore {
+		from = "our team"
+	}
+
+	subject := fmt.Sprintf("Your question about %s was answered", product.Name)
+	body := fmt.Sprintf("Hi %s,\n\nYou asked about %s:\n\n%s\n\nAnswer from %s:\n\n%s",
+		user.Name, product.Name, question.Body, from, answer.Body)
+
+	if err := SendEmail(user.Email, subject, body); err != nil {
+		log.Println("question mail:", err)
+	}
+}
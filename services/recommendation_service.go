@@ -0,0 +1,233 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+)
+
+// similar products kept per product, co-purchases are all kept
+const similarPerProduct = 20
+
+// what a shared trait adds to the similarity of two products
+const (
+	sameCategoryScore = 3
+	sameBrandScore    = 2
+	sharedOptionScore = 1
+)
+
+// the job builds the new pairs here and then swaps them into product_pairs, dropped on commit
+const pairStagingSQL = `CREATE TEMP TABLE product_pairs_next (LIKE product_pairs INCLUDING ALL) ON COMMIT DROP`
+
+// orders with both products, cancelled and returned orders don't count
+const coPurchaseSQL = `INSERT INTO product_pairs_next (product_id, related_id, bought_together, similarity, updated_at)
+	SELECT a.product_id, b.product_id, COUNT(DISTINCT a.order_id), 0, NOW()
+	FROM order_items a
+	JOIN order_items b ON b.order_id = a.order_id AND b.product_id <> a.product_id
+	JOIN orders o ON o.id = a.order_id
+	WHERE o.status NOT IN (?, ?)
+	GROUP BY a.product_id, b.product_id`
+
+// the closest products of every product by category, brand and shared filter options. only
+// products sharing one of those are scored, the candidates come from an equi-join per trait
+// instead of comparing every product with every other one
+const similaritySQL = `WITH shared AS (
+		SELECT a.product_id AS p, b.product_id AS q, COUNT(*) AS options
+		FROM product_filter_options a
+		JOIN product_filter_options b ON b.filter_option_id = a.filter_option_id AND b.product_id <> a.product_id
+		GROUP BY a.product_id, b.product_id
+	), candidates AS (
+		SELECT p.id AS p, q.id AS q
+		FROM products p
+		JOIN products q ON q.category_id = p.category_id AND q.id <> p.id
+		UNION
+		SELECT p.id, q.id
+		FROM products p
+		JOIN products q ON LOWER(q.brand) = LOWER(p.brand) AND q.id <> p.id
+		WHERE p.brand <> ''
+		UNION
+		SELECT p, q FROM shared
+	), scored AS (
+		SELECT c.p, c.q,
+			(CASE WHEN p.category_id = q.category_id THEN ? ELSE 0 END)
+			+ (CASE WHEN p.brand <> '' AND LOWER(p.brand) = LOWER(q.brand) THEN ? ELSE 0 END)
+			+ COALESCE(s.options, 0) * ? AS score
+		FROM candidates c
+		JOIN products p ON p.id = c.p AND p.deleted_at IS NULL
+		JOIN products q ON q.id = c.q AND q.deleted_at IS NULL
+		LEFT JOIN shared s ON s.p = c.p AND s.q = c.q
+	), ranked AS (
+		SELECT p, q, score, ROW_NUMBER() OVER (PARTITION BY p ORDER BY score DESC, q DESC) AS n
+		FROM scored
+	)
+	INSERT INTO product_pairs_next (product_id, related_id, bought_together, similarity, updated_at)
+	SELECT p, q, 0, score, NOW() FROM ranked WHERE n <= ?
+	ON CONFLICT (product_id, related_id) DO UPDATE SET similarity = EXCLUDED.similarity`
+
+// product_pairs takes the staged rows: pairs that are gone are removed and the rest inserted or
+// updated in place, so the table is never empty while the job runs
+const (
+	dropStalePairsSQL = `DELETE FROM product_pairs pp WHERE NOT EXISTS (SELECT 1 FROM product_pairs_next n
+		WHERE n.product_id = pp.product_id AND n.related_id = pp.related_id)`
+
+	upsertPairsSQL = `INSERT INTO product_pairs (product_id, related_id, bought_together, similarity, updated_at)
+	SELECT product_id, related_id, bought_together, similarity, updated_at FROM product_pairs_next
+	ON CONFLICT (product_id, related_id) DO UPDATE SET bought_together = EXCLUDED.bought_together,
+		similarity = EXCLUDED.similarity, updated_at = EXCLUDED.updated_at`
+)
+
+// rebuild every product pair from order history and the catalog
+func RefreshProductPairs(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(pairStagingSQL).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(coPurchaseSQL, models.OrderStatusCancelled, models.OrderStatusReturned).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(similaritySQL, sameCategoryScore, sameBrandScore, sharedOptionScore, similarPerProduct).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec(dropStalePairsSQL).Error; err != nil {
+			return err
+		}
+		return tx.Exec(upsertPairsSQL).Error
+	})
+}
+
+// rebuild the pairs now and then every interval
+func StartRecommendationJob(db *gorm.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			started := time.Now()
+			if err := RefreshProductPairs(db); err != nil {
+				log.Println("recommendation job:", err)
+			} else {
+				log.Printf("recommendation job refreshed product pairs in %s\n", time.Since(started).Round(time.Millisecond))
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// products most like this one, the same category by sales until the job has seen it
+func RelatedProducts(db *gorm.DB, productId uint, limit int) ([]models.Product, error) {
+	var ids []uint
+	if err := pairsOf(db, productId).
+		Where("product_pairs.similarity > 0").
+		Order("product_pairs.similarity DESC, product_pairs.bought_together DESC, product_pairs.related_id DESC").
+		Limit(limit).
+		Pluck("product_pairs.related_id", &ids).Error; err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		var product models.Product
+		if err := db.First(&product, productId).Error; err != nil {
+			return nil, err
+		}
+
+		query := db.Model(&models.Product{}).Where("id <> ?", productId)
+		if product.CategoryID != nil {
+			query = query.Where("category_id=?", *product.CategoryID)
+		} else {
+			query = query.Where("LOWER(brand)=LOWER(?)", product.Brand)
+		}
+		if err := query.Order("sold_count DESC, id DESC").Limit(limit).Pluck("id", &ids).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return productsInOrder(db, ids)
+}
+
+// products that were most often in the same orders as this one
+func BoughtTogether(db *gorm.DB, productId uint, limit int) ([]models.Product, error) {
+	var ids []uint
+	if err := pairsOf(db, productId).
+		Where("product_pairs.bought_together > 0").
+		Order("product_pairs.bought_together DESC, product_pairs.similarity DESC, product_pairs.related_id DESC").
+		Limit(limit).
+		Pluck("product_pairs.related_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return productsInOrder(db, ids)
+}
+
+// products for a user from what they ordered and wished for, neither of which is suggested
+// again, best sellers for users without history
+func UserRecommendations(db *gorm.DB, userId uint, limit int) ([]models.Product, error) {
+	var rows []struct {
+		RelatedID uint
+		Score     float64
+	}
+
+	err := db.Raw(`WITH seeds AS (
+			SELECT oi.product_id, 2.0 AS weight
+			FROM order_items oi JOIN orders o ON o.id = oi.order_id
+			WHERE o.user_id = ? AND o.status <> ?
+			UNION ALL
+			SELECT product_id, 1.0 FROM wishlists WHERE user_id = ? AND deleted_at IS NULL
+		)
+		SELECT pp.related_id, SUM(seeds.weight * (pp.bought_together * 2 + pp.similarity)) AS score
+		FROM product_pairs pp
+		JOIN seeds ON seeds.product_id = pp.product_id
+		JOIN products p ON p.id = pp.related_id AND p.deleted_at IS NULL
+		WHERE pp.related_id NOT IN (SELECT product_id FROM seeds)
+		GROUP BY pp.related_id
+		ORDER BY score DESC, pp.related_id DESC
+		LIMIT ?`, userId, models.OrderStatusCancelled, userId, limit).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, len(rows))
+	for i, row := range rows {
+		ids[i] = row.RelatedID
+	}
+
+	if len(ids) == 0 {
+		if err := db.Model(&models.Product{}).Order("sold_count DESC, id DESC").Limit(limit).Pluck("id", &ids).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return productsInOrder(db, ids)
+}
+
+// pairs of a product whose other product is still for sale
+func pairsOf(db *gorm.DB, productId uint) *gorm.DB {
+	return db.Model(&models.ProductPair{}).
+		Joins("JOIN products ON products.id = product_pairs.related_id AND products.deleted_at IS NULL").
+		Where("product_pairs.product_id=?", productId)
+}
+
+// load products keeping the order of ids, deleted ones are skipped
+func productsInOrder(db *gorm.DB, ids []uint) ([]models.Product, error) {
+	ordered := make([]models.Product, 0, len(ids))
+	if len(ids) == 0 {
+		return ordered, nil
+	}
+
+	var products []models.Product
+	if err := db.Where("id IN ?", ids).Find(&products).Error; err != nil {
+		return nil, err
+	}
+
+	byId := make(map[uint]models.Product, len(products))
+	for _, product := range products {
+		byId[product.ID] = product
+	}
+	for _, id := range ids {
+		if product, ok := byId[id]; ok {
+			ordered = append(ordered, product)
+		}
+	}
+	return ordered, nil
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// refund states, partially refunded / refunded are also used as payment status
+const (
+	PaymentStatusRefunded          = "refunded"
+	PaymentStatusPartiallyRefunded = "partially_refunded"
+
+	RefundStatusPending = "pending" //booked, not sent to the provider yet (or the provider failed)
+	RefundStatusSending = "sending" //claimed by SettleRefund, the provider is being called
+)
+
+// how long a refund may stay claimed before it counts as abandoned and is sent again
+const refundClaimTimeout = 10 * time.Minute
+
+var (
+	ErrNothingToRefund  = errors.New("nothing left to refund")
+	ErrNoPaidPayment    = errors.New("order has no completed payment")
+	ErrInvalidRefundQty = errors.New("invalid refund quantity")
+)
+
+// units of one order line to refund
+type RefundLine struct {
+	OrderItemID uint `json:"order_item_id" binding:"required"`
+	Quantity    int  `json:"quantity" binding:"required,gt=0"`
+}
+
+// order was paid (fully or with some money already given back)
+func isPaidStatus(status string) bool {
+	return status == PaymentStatusCompleted || status == PaymentStatusPartiallyRefunded
+}
+
+// book a refund of order lines, no lines = everything not refunded yet. runs inside the callers
+// transaction and only writes our side, the refund is pending until SettleRefund sends it to the
+// provider after commit, createdBy 0 = automatic
+func IssueRefund(tx *gorm.DB, orderId uint, lines []RefundLine, reason string, createdBy uint) (*models.Refund, error) {
+	var order models.Order
+
+	if err := tx.Preload("OrderItems").First(&order, orderId).Error; err != nil {
+		return nil, err
+	}
+
+	var payment models.Payment
+
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_id=? AND payment_status=?", orderId, PaymentStatusCompleted).
+		Order("id DESC").First(&payment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNoPaidPayment
+		}
+		return nil, err
+	}
+
+	remaining := payment.Amount - payment.RefundedAmount
+	if remaining <= 0 {
+		return nil, ErrNothingToRefund
+	}
+
+	itemsById := make(map[uint]models.OrderItem, len(order.OrderItems))
+	for _, item := range order.OrderItems {
+		itemsById[item.ID] = item
+	}
+
+	//whole order = every unit not refunded yet
+	fullRefund := len(lines) == 0
+	if fullRefund {
+		for _, item := range order.OrderItems {
+			if left := item.Quantity - item.RefundedQuantity; left > 0 {
+				lines = append(lines, RefundLine{OrderItemID: item.ID, Quantity: left})
+			}
+		}
+	}
+
+	refund := models.Refund{
+		OrderID:   order.ID,
+		PaymentID: payment.ID,
+		Currency:  payment.Currency,
+		Reason:    reason,
+		CreatedBy: createdBy,
+	}
+
+	var amount models.Money
+	for _, line := range lines {
+		item, ok := itemsById[line.OrderItemID]
+		if !ok {
+			return nil, fmt.Errorf("%w: item %d is not part of order %d", ErrInvalidRefundQty, line.OrderItemID, order.ID)
+		}
+
+		if line.Quantity <= 0 || line.Quantity > item.Quantity-item.RefundedQuantity {
+			return nil, fmt.Errorf("%w: only %d of item %d can be refunded", ErrInvalidRefundQty, item.Quantity-item.RefundedQuantity, item.ID)
+		}
+
+		//what the customer actually paid for these units: coupon share off, tax on
+		linePaid := item.TotalPrice - item.DiscountAmount + item.TaxAmount
+		lineAmount := linePaid.Share(int64(line.Quantity), int64(item.Quantity))
+		amount += lineAmount
+
+		refund.Items = append(refund.Items, models.RefundItem{
+			OrderItemID: item.ID,
+			Quantity:    line.Quantity,
+			Amount:      lineAmount,
+		})
+	}
+
+	//a full refund also returns whatever is not tied to a line
+	if fullRefund || amount > remaining {
+		amount = remaining
+	}
+
+	if amount <= 0 {
+		return nil, ErrNothingToRefund
+	}
+
+	refund.Amount = amount
+	refund.Status = RefundStatusPending
+
+	if err := tx.Create(&refund).Error; err != nil {
+		return nil, err
+	}
+
+	for _, item := range refund.Items {
+		if err := tx.Model(&models.OrderItem{}).Where("id=?", item.OrderItemID).
+			UpdateColumn("refunded_quantity", gorm.Expr("refunded_quantity + ?", item.Quantity)).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	refundedTotal := payment.RefundedAmount + amount
+
+	paymentUpdates := map[string]interface{}{"refunded_amount": refundedTotal}
+	orderPaymentStatus := PaymentStatusPartiallyRefunded
+	if refundedTotal >= payment.Amount {
+		paymentUpdates["payment_status"] = PaymentStatusRefunded
+		orderPaymentStatus = PaymentStatusRefunded
+	}
+
+	if err := tx.Model(&payment).Updates(paymentUpdates).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Model(&order).Update("payment_status", orderPaymentStatus).Error; err != nil {
+		return nil, err
+	}
+
+	//money is gone again, take it off the revenue
+	if err := tx.Model(&models.AppStats{}).Where("id=?", 1).
+		UpdateColumn("total_revenue", gorm.Expr("total_revenue - ?", toBaseCurrency(&order, amount))).Error; err != nil {
+		return nil, err
+	}
+
+	return &refund, nil
+}
+
+// send a booked refund to the provider, call after the transaction that booked it committed.
+// the refund is claimed first (pending -> sending) so the retry job and a request don't send it
+// side by side, the provider is called without a transaction or lock held and the outcome is
+// saved afterwards. the refund id is the idempotency key so a retry never pays twice, a failure
+// puts the refund back to pending for RetryPendingRefunds. the customer is mailed once the
+// provider took it
+func SettleRefund(db *gorm.DB, refundId uint) error {
+	//a claim older than refundClaimTimeout was left by a process that died while sending
+	claim := db.Model(&models.Refund{}).
+		Where("id=? AND (status=? OR (status=? AND updated_at < ?))",
+			refundId, RefundStatusPending, RefundStatusSending, time.Now().Add(-refundClaimTimeout)).
+		Update("status", RefundStatusSending)
+	if claim.Error != nil {
+		return claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return nil //sent already or being sent
+	}
+
+	var refund models.Refund
+	if err := db.First(&refund, refundId).Error; err != nil {
+		return err
+	}
+
+	result, providerErr := sendRefund(db, &refund)
+
+	if providerErr != nil {
+		if err := db.Model(&refund).Where("status=?", RefundStatusSending).Updates(map[string]interface{}{
+			"status":         RefundStatusPending,
+			"attempts":       gorm.Expr("attempts + ?", 1),
+			"failure_reason": providerErr.Error(),
+		}).Error; err != nil {
+			return err
+		}
+		return fmt.Errorf("provider refused refund: %w", providerErr)
+	}
+
+	if err := db.Model(&refund).Where("status=?", RefundStatusSending).Updates(map[string]interface{}{
+		"status":         result.Status,
+		"provider_ref":   result.ProviderRef,
+		"attempts":       gorm.Expr("attempts + ?", 1),
+		"failure_reason": "",
+	}).Error; err != nil {
+		return err
+	}
+
+	if err := db.First(&refund, refundId).Error; err != nil {
+		return err
+	}
+	SendRefundEmail(db, &refund)
+	return nil
+}
+
+// the provider call of SettleRefund
+func sendRefund(db *gorm.DB, refund *models.Refund) (*RefundResult, error) {
+	var payment models.Payment
+	if err := db.First(&payment, refund.PaymentID).Error; err != nil {
+		return nil, err
+	}
+
+	provider, err := GetPaymentProvider(payment.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	return provider.Refund(payment.ProviderRef, refund.Amount, fmt.Sprintf("refund_%d", refund.ID))
+}
+
+// send refunds the provider didn't take yet, oldest first. claims left behind by a crash are
+// picked up again once they timed out
+func RetryPendingRefunds(db *gorm.DB) (int, error) {
+	var ids []uint
+	if err := db.Model(&models.Refund{}).
+		Where("status=? OR (status=? AND updated_at < ?)",
+			RefundStatusPending, RefundStatusSending, time.Now().Add(-refundClaimTimeout)).
+		Order("id ASC").Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+
+	settled := 0
+	for _, id := range ids {
+		if err := SettleRefund(db, id); err != nil {
+			log.Printf("refund %d: %v\n", id, err)
+			continue
+		}
+		settled++
+	}
+	return settled, nil
+}
+
+// retry pending refunds every interval
+func StartRefundRetryJob(db *gorm.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if settled, err := RetryPendingRefunds(db); err != nil {
+				log.Println("refund retry job:", err)
+			} else if settled > 0 {
+				log.Printf("refund retry job sent %d refunds\n", settled)
+			}
+		}
+	}()
+}
+
+// tell the customer their money is on the way, errors are only logged
+func SendRefundEmail(db *gorm.DB, refund *models.Refund) {
+	var order models.Order
+	if err := db.First(&order, refund.OrderID).Error; err != nil {
+		log.Println("refund mail: could not find order:", err)
+		return
+	}
+
+	//unclaimed guest orders only have the email given at checkout
+	user := models.User{Name: order.ShipTo.Name, Email: order.GuestEmail}
+	if order.UserID != 0 {
+		if err := db.First(&user, order.UserID).Error; err != nil {
+			log.Println("refund mail: could not find customer:", err)
+			return
+		}
+	}
+
+	subject := fmt.Sprintf("Refund for your order #%d", refund.OrderID)
+	body := fmt.Sprintf("Hi %s,\n\nWe have refunded %s %s for your order #%d. It can take a few days to show up on your statement.\n\nReason: %s",
+		user.Name, refund.Currency, refund.Amount, refund.OrderID, refund.Reason)
+
+	if err := SendEmail(user.Email, subject, body); err != nil {
+		log.Println("refund mail:", err)
+	}
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/junaid9001/spectr_backend/config"
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInsufficientStock = errors.New("not enough stock is available")
+
+// how long a cart / checkout hold lives (RESERVATION_TTL_MINUTES, default 15)
+func ReservationTTL() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("RESERVATION_TTL_MINUTES"))
+	if err != nil || minutes <= 0 {
+		minutes = 15
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// stock is kept per (product, variant), variant 0 = the product itself
+func VariantKey(variantId *uint) uint {
+	if variantId == nil {
+		return 0
+	}
+	return *variantId
+}
+
+// VariantKey back to the optional id cart and order lines keep, nil for 0
+func VariantRef(variantKey uint) *uint {
+	if variantKey == 0 {
+		return nil
+	}
+	return &variantKey
+}
+
+// units held by active reservations, the exclude owner's own hold is skipped (zero CartOwner = count all)
+func ReservedQuantity(tx *gorm.DB, productId, variantId uint, exclude CartOwner) (int, error) {
+	var reserved int64
+
+	q := tx.Model(&models.StockReservation{}).
+		Where("product_id=? AND variant_id=? AND expires_at > ?", productId, variantId, time.Now())
+
+	if exclude != (CartOwner{}) {
+		q = q.Where("NOT (user_id=? AND guest_cart_id=?)", exclude.UserID, exclude.GuestCartID)
+	}
+
+	if err := q.Select("COALESCE(SUM(quantity),0)").Scan(&reserved).Error; err != nil {
+		return 0, err
+	}
+	return int(reserved), nil
+}
+
+// available to sell = on hand stock - active holds
+func AvailableStock(tx *gorm.DB, product *models.Product) (int, error) {
+	reserved, err := ReservedQuantity(tx, product.ID, 0, CartOwner{})
+	if err != nil {
+		return 0, err
+	}
+	return availableAfter(product.StockQuantity, reserved), nil
+}
+
+func AvailableVariantStock(tx *gorm.DB, variant *models.ProductVariant) (int, error) {
+	reserved, err := ReservedQuantity(tx, variant.ProductID, variant.ID, CartOwner{})
+	if err != nil {
+		return 0, err
+	}
+	return availableAfter(variant.StockQuantity, reserved), nil
+}
+
+func availableAfter(stock, reserved int) int {
+	if stock-reserved < 0 {
+		return 0
+	}
+	return stock - reserved
+}
+
+// lock the product or variant row and read its stock, used so two shoppers can't hold the same units
+func lockStock(tx *gorm.DB, productId, variantId uint) (int, error) {
+	locked := tx.Clauses(clause.Locking{Strength: "UPDATE"})
+
+	if variantId == 0 {
+		var product models.Product
+		if err := locked.First(&product, productId).Error; err != nil {
+			return 0, err
+		}
+		return product.StockQuantity, nil
+	}
+
+	var variant models.ProductVariant
+	if err := locked.Where("id=? AND product_id=? AND is_active = ?", variantId, productId, true).
+		First(&variant).Error; err != nil {
+		return 0, err
+	}
+	return variant.StockQuantity, nil
+}
+
+// move on hand stock of a product or variant by delta (restock > 0, sale < 0)
+func AdjustStock(tx *gorm.DB, productId, variantId uint, delta int) error {
+	if variantId == 0 {
+		return tx.Model(&models.Product{}).Where("id=?", productId).
+			UpdateColumn("stock_quantity", gorm.Expr("stock_quantity + ?", delta)).Error
+	}
+	return tx.Unscoped().Model(&models.ProductVariant{}).Where("id=?", variantId).
+		UpdateColumn("stock_quantity", gorm.Expr("stock_quantity + ?", delta)).Error
+}
+
+// move the units sold counter of a product by delta (sale > 0, cancel / return < 0), used for popularity
+func AdjustSoldCount(tx *gorm.DB, productId uint, delta int) error {
+	return tx.Model(&models.Product{}).Where("id=?", productId).
+		UpdateColumn("sold_count", gorm.Expr("GREATEST(sold_count + ?, 0)", delta)).Error
+}
+
+// set the cart owners hold on a product (variant) to quantity and restart its expiry, call inside a transaction
+func ReserveStock(tx *gorm.DB, owner CartOwner, productId, variantId uint, quantity int) (*models.StockReservation, error) {
+	stock, err := lockStock(tx, productId, variantId)
+	if err != nil {
+		return nil, err
+	}
+
+	heldByOthers, err := ReservedQuantity(tx, productId, variantId, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	if stock-heldByOthers < quantity {
+		return nil, ErrInsufficientStock
+	}
+
+	reservation := models.StockReservation{
+		UserId:      owner.UserID,
+		GuestCartID: owner.GuestCartID,
+		ProductId:   productId,
+		VariantId:   variantId,
+		Quantity:    quantity,
+		ExpiresAt:   time.Now().Add(ReservationTTL()),
+	}
+
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "guest_cart_id"}, {Name: "product_id"}, {Name: "variant_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"quantity", "expires_at", "updated_at"}),
+	}).Create(&reservation).Error; err != nil {
+		return nil, err
+	}
+
+	return &reservation, nil
+}
+
+// drop the cart owners hold on one product (variant), item removed from cart
+func ReleaseReservation(tx *gorm.DB, owner CartOwner, productId, variantId uint) error {
+	return owner.Scope(tx).Where("product_id=? AND variant_id=?", productId, variantId).
+		Delete(&models.StockReservation{}).Error
+}
+
+// turn the cart owners hold into sold stock, fails if other shoppers hold what is left
+func CommitReservedStock(tx *gorm.DB, owner CartOwner, productId, variantId uint, quantity int) error {
+	stock, err := lockStock(tx, productId, variantId)
+	if err != nil {
+		return err
+	}
+
+	heldByOthers, err := ReservedQuantity(tx, productId, variantId, owner)
+	if err != nil {
+		return err
+	}
+
+	if stock-heldByOthers < quantity {
+		return ErrInsufficientStock
+	}
+
+	return AdjustStock(tx, productId, variantId, -quantity)
+}
+
+// remove every hold of a cart owner (after order is placed)
+func ReleaseCartReservations(tx *gorm.DB, owner CartOwner) error {
+	return owner.Scope(tx).Delete(&models.StockReservation{}).Error
+}
+
+// delete holds whose expiry has passed
+func ReleaseExpiredReservations(db *gorm.DB) (int64, error) {
+	result := db.Where("expires_at <= ?", time.Now()).Delete(&models.StockReservation{})
+	return result.RowsAffected, result.Error
+}
+
+// background job releasing expired holds every interval, abandoned guest carts go with them
+func StartReservationSweeper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if removed, err := DeleteStaleGuestCarts(config.DB); err != nil {
+				log.Println("reservation sweeper:", err)
+			} else if removed > 0 {
+				log.Printf("reservation sweeper removed %d stale guest carts\n", removed)
+			}
+
+			released, err := ReleaseExpiredReservations(config.DB)
+			if err != nil {
+				log.Println("reservation sweeper:", err)
+				continue
+			}
+			if released > 0 {
+				log.Printf("reservation sweeper released %d expired holds\n", released)
+			}
+		}
+	}()
+}
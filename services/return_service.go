@@ -0,0 +1,295 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrReturnWindowClosed = errors.New("return window has closed")
+	ErrInvalidReturnQty   = errors.New("invalid return quantity")
+	ErrReturnState        = errors.New("return request can't do that in its current state")
+	ErrOrderNotReturnable = errors.New("only delivered orders can be returned")
+)
+
+// units of one order line the customer wants to send back
+type ReturnLine struct {
+	OrderItemID uint `json:"order_item_id" binding:"required"`
+	Quantity    int  `json:"quantity" binding:"required,gt=0"`
+}
+
+// fallback window when no category sets one (RETURN_WINDOW_DAYS, default 7)
+func DefaultReturnWindowDays() int {
+	days, err := strconv.Atoi(os.Getenv("RETURN_WINDOW_DAYS"))
+	if err != nil || days < 0 {
+		days = 7
+	}
+	return days
+}
+
+// window of a category, inherited from the closest parent that sets one
+func ReturnWindowDays(db *gorm.DB, categoryId *uint) (int, error) {
+	visited := make(map[uint]bool)
+
+	for categoryId != nil && !visited[*categoryId] {
+		visited[*categoryId] = true
+
+		var category models.Category
+		if err := db.First(&category, *categoryId).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				break
+			}
+			return 0, err
+		}
+
+		if category.ReturnWindowDays != nil {
+			return *category.ReturnWindowDays, nil
+		}
+		categoryId = category.ParentID
+	}
+
+	return DefaultReturnWindowDays(), nil
+}
+
+// units of an order line already in an open or finished return
+func returnedOrPending(tx *gorm.DB, orderItemId uint) (int, error) {
+	var quantity int64
+
+	err := tx.Model(&models.ReturnItem{}).
+		Joins("JOIN return_requests rr ON rr.id = return_items.return_request_id").
+		Where("return_items.order_item_id=? AND rr.status <> ?", orderItemId, models.ReturnStatusRejected).
+		Select("COALESCE(SUM(return_items.quantity),0)").Scan(&quantity).Error
+
+	return int(quantity), err
+}
+
+// open a return for lines of a delivered order owned by userId
+func CreateReturnRequest(db *gorm.DB, userId, orderId uint, lines []ReturnLine, reason, photoKey string) (*models.ReturnRequest, error) {
+	request := models.ReturnRequest{
+		OrderID:  orderId,
+		UserID:   userId,
+		Status:   models.ReturnStatusRequested,
+		Reason:   reason,
+		PhotoUrl: photoKey,
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var order models.Order
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id=? AND user_id=?", orderId, userId).First(&order).Error; err != nil {
+			return err
+		}
+
+		if order.Status != models.OrderStatusDelivered || order.DeliveredAt == nil {
+			return ErrOrderNotReturnable
+		}
+
+		var orderItems []models.OrderItem
+		if err := tx.Where("order_id=?", orderId).Find(&orderItems).Error; err != nil {
+			return err
+		}
+
+		itemsById := make(map[uint]models.OrderItem, len(orderItems))
+		for _, item := range orderItems {
+			itemsById[item.ID] = item
+		}
+
+		for _, line := range lines {
+			item, ok := itemsById[line.OrderItemID]
+			if !ok {
+				return fmt.Errorf("%w: item %d is not part of order %d", ErrInvalidReturnQty, line.OrderItemID, orderId)
+			}
+
+			var product models.Product
+			if err := tx.Unscoped().First(&product, item.ProductID).Error; err != nil {
+				return err
+			}
+
+			days, err := ReturnWindowDays(tx, product.CategoryID)
+			if err != nil {
+				return err
+			}
+
+			if time.Now().After(order.DeliveredAt.AddDate(0, 0, days)) {
+				return fmt.Errorf("%w for %s", ErrReturnWindowClosed, product.Name)
+			}
+
+			alreadyReturned, err := returnedOrPending(tx, item.ID)
+			if err != nil {
+				return err
+			}
+
+			if line.Quantity > item.Quantity-alreadyReturned {
+				return fmt.Errorf("%w: only %d of item %d can be returned", ErrInvalidReturnQty, item.Quantity-alreadyReturned, item.ID)
+			}
+
+			request.Items = append(request.Items, models.ReturnItem{OrderItemID: item.ID, Quantity: line.Quantity})
+		}
+
+		return tx.Create(&request).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+// admin decision on a requested return
+func ReviewReturnRequest(db *gorm.DB, returnId uint, approve bool, note string) (*models.ReturnRequest, error) {
+	var request models.ReturnRequest
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&request, returnId).Error; err != nil {
+			return err
+		}
+
+		if request.Status != models.ReturnStatusRequested {
+			return ErrReturnState
+		}
+
+		status := models.ReturnStatusRejected
+		if approve {
+			status = models.ReturnStatusApproved
+		}
+
+		return tx.Model(&request).Updates(map[string]interface{}{"status": status, "admin_note": note}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Preload("Items").First(&request, returnId).Error; err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// goods of an approved return arrived: restock or write off, refund and close it
+func ReceiveReturn(db *gorm.DB, returnId uint, resolution, note string, adminId uint) (*models.ReturnRequest, error) {
+	if resolution != models.ReturnResolutionRestock && resolution != models.ReturnResolutionWriteOff {
+		return nil, fmt.Errorf("resolution must be %s or %s", models.ReturnResolutionRestock, models.ReturnResolutionWriteOff)
+	}
+
+	var request models.ReturnRequest
+	var refund *models.Refund
+	var afterCommit []func(db *gorm.DB)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&request, returnId).Error; err != nil {
+			return err
+		}
+
+		if request.Status != models.ReturnStatusApproved {
+			return ErrReturnState
+		}
+
+		refundLines := make([]RefundLine, 0, len(request.Items))
+
+		for _, line := range request.Items {
+			var item models.OrderItem
+			if err := tx.First(&item, line.OrderItemID).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Model(&item).
+				UpdateColumn("returned_quantity", gorm.Expr("returned_quantity + ?", line.Quantity)).Error; err != nil {
+				return err
+			}
+
+			if err := AdjustSoldCount(tx, item.ProductID, -line.Quantity); err != nil {
+				return err
+			}
+
+			if resolution == models.ReturnResolutionRestock {
+				if err := AdjustStock(tx, item.ProductID, VariantKey(item.VariantID), line.Quantity); err != nil {
+					return err
+				}
+			}
+
+			//never refund more units than are still unrefunded
+			if left := item.Quantity - item.RefundedQuantity; left > 0 {
+				quantity := line.Quantity
+				if quantity > left {
+					quantity = left
+				}
+				refundLines = append(refundLines, RefundLine{OrderItemID: item.ID, Quantity: quantity})
+			}
+		}
+
+		updates := map[string]interface{}{
+			"status":      models.ReturnStatusCompleted,
+			"resolution":  resolution,
+			"received_at": time.Now(),
+		}
+		if note != "" {
+			updates["admin_note"] = note
+		}
+
+		var openUnits int64
+		if err := tx.Model(&models.OrderItem{}).Where("order_id=?", request.OrderID).
+			Select("COALESCE(SUM(quantity - returned_quantity),0)").Scan(&openUnits).Error; err != nil {
+			return err
+		}
+		orderFullyReturned := openUnits == 0
+
+		//the return that brings back the last units also gives back what is not tied to a line
+		//(shipping, order level tax), so it is refunded in full instead of line by line
+		if orderFullyReturned {
+			refundLines = nil
+		}
+
+		if len(refundLines) > 0 || orderFullyReturned {
+			var err error
+			refund, err = IssueRefund(tx, request.OrderID, refundLines, "return #"+strconv.Itoa(int(request.ID))+": "+request.Reason, adminId)
+			if err != nil && !errors.Is(err, ErrNoPaidPayment) && !errors.Is(err, ErrNothingToRefund) {
+				return err
+			}
+			if refund != nil {
+				updates["refund_id"] = refund.ID
+			}
+		}
+
+		if err := tx.Model(&request).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		if !orderFullyReturned {
+			return nil
+		}
+
+		//everything came back, close the order as returned. units and money were handled above,
+		//so the transition only reverses the sale counters
+		var err error
+		afterCommit, err = ChangeOrderStatusTx(tx, request.OrderID, models.OrderStatusReturned,
+			OrderActor{UserID: adminId, Role: "admin"}, "all items returned")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if refund != nil {
+		if err := SettleRefund(db, refund.ID); err != nil {
+			log.Printf("refund %d stays pending: %v\n", refund.ID, err)
+		}
+	}
+
+	for _, fn := range afterCommit {
+		fn(db)
+	}
+
+	if err := db.Preload("Items").First(&request, returnId).Error; err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
@@ -0,0 +1,344 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrNotPurchased   = errors.New("only customers who received this product can review it")
+	ErrReviewExists   = errors.New("you already reviewed this product")
+	ErrOwnReview      = errors.New("you can't vote on your own review")
+	ErrInvalidRating  = errors.New("rating must be between 1 and 5")
+	ErrTooManyPhotos  = errors.New("a review can have at most 5 photos")
+	ErrReviewNotShown = errors.New("review is not published")
+)
+
+const MaxReviewPhotos = 5
+
+// what a customer writes, nil fields are left alone on edits
+type ReviewInput struct {
+	Rating *int
+	Title  *string
+	Body   *string
+}
+
+type RatingSummary struct {
+	Average   float64       `json:"average"`
+	Count     int64         `json:"count"`
+	Histogram map[int]int64 `json:"histogram"` //stars -> approved reviews, every star present
+}
+
+func (input ReviewInput) validate() error {
+	if input.Rating != nil && (*input.Rating < 1 || *input.Rating > 5) {
+		return ErrInvalidRating
+	}
+	return nil
+}
+
+// user has a delivered order containing the product
+func HasDeliveredPurchase(db *gorm.DB, userId, productId uint) (bool, error) {
+	var count int64
+	err := db.Model(&models.OrderItem{}).
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("orders.user_id=? AND orders.status=? AND order_items.product_id=?", userId, models.OrderStatusDelivered, productId).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// new review of a received product, waits for a moderator before it is shown
+func CreateReview(db *gorm.DB, userId, productId uint, input ReviewInput, photoKeys []string) (*models.Review, error) {
+	if input.Rating == nil {
+		return nil, ErrInvalidRating
+	}
+	if err := input.validate(); err != nil {
+		return nil, err
+	}
+	if len(photoKeys) > MaxReviewPhotos {
+		return nil, ErrTooManyPhotos
+	}
+
+	review := models.Review{
+		ProductID: productId,
+		UserID:    userId,
+		Rating:    *input.Rating,
+		Status:    models.ReviewStatusPending,
+		Photos:    reviewPhotos(photoKeys),
+	}
+	if input.Title != nil {
+		review.Title = *input.Title
+	}
+	if input.Body != nil {
+		review.Body = *input.Body
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&models.Product{}, productId).Error; err != nil {
+			return err
+		}
+
+		purchased, err := HasDeliveredPurchase(tx, userId, productId)
+		if err != nil {
+			return err
+		}
+		if !purchased {
+			return ErrNotPurchased
+		}
+
+		var existing int64
+		if err := tx.Model(&models.Review{}).Where("user_id=? AND product_id=?", userId, productId).
+			Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return ErrReviewExists
+		}
+
+		if err := tx.Create(&review).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) || strings.Contains(err.Error(), "duplicate key") {
+				return ErrReviewExists
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &review, nil
+}
+
+// edit of the authors review, it goes back to moderation, photoKeys replace the old photos when
+// replacePhotos is set, returns the keys of removed photos to delete after the call
+func UpdateReview(db *gorm.DB, userId, reviewId uint, input ReviewInput, photoKeys []string, replacePhotos bool) (*models.Review, []string, error) {
+	if err := input.validate(); err != nil {
+		return nil, nil, err
+	}
+	if len(photoKeys) > MaxReviewPhotos {
+		return nil, nil, ErrTooManyPhotos
+	}
+
+	var review models.Review
+	var removed []string
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Photos").
+			Where("id=? AND user_id=?", reviewId, userId).First(&review).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{"status": models.ReviewStatusPending, "admin_note": ""}
+		if input.Rating != nil {
+			updates["rating"] = *input.Rating
+		}
+		if input.Title != nil {
+			updates["title"] = *input.Title
+		}
+		if input.Body != nil {
+			updates["body"] = *input.Body
+		}
+		if err := tx.Model(&review).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		if replacePhotos {
+			for _, photo := range review.Photos {
+				removed = append(removed, photo.Key)
+			}
+			if err := tx.Where("review_id=?", review.ID).Delete(&models.ReviewPhoto{}).Error; err != nil {
+				return err
+			}
+
+			photos := reviewPhotos(photoKeys)
+			for i := range photos {
+				photos[i].ReviewID = review.ID
+			}
+			if len(photos) > 0 {
+				if err := tx.Create(&photos).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		//a published review leaves the rating until it is approved again
+		return RefreshProductRating(tx, review.ProductID)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := WithReviewPhotos(db).First(&review, review.ID).Error; err != nil {
+		return nil, nil, err
+	}
+	return &review, removed, nil
+}
+
+// remove the authors review, returns the photo keys to delete after the call
+func DeleteReview(db *gorm.DB, userId, reviewId uint) ([]string, error) {
+	var keys []string
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var review models.Review
+		if err := tx.Preload("Photos").Where("id=? AND user_id=?", reviewId, userId).First(&review).Error; err != nil {
+			return err
+		}
+
+		for _, photo := range review.Photos {
+			keys = append(keys, photo.Key)
+		}
+
+		if err := tx.Where("review_id=?", review.ID).Delete(&models.ReviewVote{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("review_id=?", review.ID).Delete(&models.ReviewPhoto{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&review).Error; err != nil {
+			return err
+		}
+
+		return RefreshProductRating(tx, review.ProductID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// approve or hide a review (admin), the product rating follows
+func ModerateReview(db *gorm.DB, reviewId uint, status, note string) (*models.Review, error) {
+	var review models.Review
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&review, reviewId).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&review).Updates(map[string]interface{}{"status": status, "admin_note": note}).Error; err != nil {
+			return err
+		}
+
+		return RefreshProductRating(tx, review.ProductID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := WithReviewPhotos(db).First(&review, review.ID).Error; err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// mark a published review helpful (or take the vote back), voting twice changes nothing
+func VoteReviewHelpful(db *gorm.DB, userId, reviewId uint, helpful bool) (*models.Review, error) {
+	var review models.Review
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&review, reviewId).Error; err != nil {
+			return err
+		}
+		if review.Status != models.ReviewStatusApproved {
+			return ErrReviewNotShown
+		}
+		if review.UserID == userId {
+			return ErrOwnReview
+		}
+
+		var result *gorm.DB
+		if helpful {
+			result = tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&models.ReviewVote{ReviewID: reviewId, UserID: userId})
+		} else {
+			result = tx.Where("review_id=? AND user_id=?", reviewId, userId).Delete(&models.ReviewVote{})
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		delta := 1
+		if !helpful {
+			delta = -1
+		}
+		return tx.Model(&review).UpdateColumn("helpful_count", gorm.Expr("helpful_count + ?", delta)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := WithReviewPhotos(db).First(&review, review.ID).Error; err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// recount the average and number of approved reviews stored on the product (used for sorting)
+func RefreshProductRating(tx *gorm.DB, productId uint) error {
+	var stats struct {
+		Average float64
+		Count   int64
+	}
+	if err := tx.Model(&models.Review{}).
+		Where("product_id=? AND status=?", productId, models.ReviewStatusApproved).
+		Select("COALESCE(AVG(rating), 0) AS average, COUNT(*) AS count").
+		Scan(&stats).Error; err != nil {
+		return err
+	}
+
+	return tx.Unscoped().Model(&models.Product{}).Where("id=?", productId).Updates(map[string]interface{}{
+		"rating_average": math.Round(stats.Average*100) / 100,
+		"rating_count":   stats.Count,
+	}).Error
+}
+
+// average, count and stars histogram of the approved reviews of a product
+func ProductRatingSummary(db *gorm.DB, productId uint) (*RatingSummary, error) {
+	var rows []struct {
+		Rating int
+		Count  int64
+	}
+	if err := db.Model(&models.Review{}).
+		Where("product_id=? AND status=?", productId, models.ReviewStatusApproved).
+		Select("rating, COUNT(*) AS count").Group("rating").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	summary := &RatingSummary{Histogram: map[int]int64{1: 0, 2: 0, 3: 0, 4: 0, 5: 0}}
+
+	var total int64
+	for _, row := range rows {
+		summary.Histogram[row.Rating] = row.Count
+		summary.Count += row.Count
+		total += int64(row.Rating) * row.Count
+	}
+	if summary.Count > 0 {
+		summary.Average = math.Round(float64(total)/float64(summary.Count)*100) / 100
+	}
+	return summary, nil
+}
+
+// preload review photos in upload order
+func WithReviewPhotos(db *gorm.DB) *gorm.DB {
+	return db.Preload("Photos", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("position ASC, id ASC")
+	})
+}
+
+func reviewPhotos(keys []string) []models.ReviewPhoto {
+	photos := make([]models.ReviewPhoto, len(keys))
+	for i, key := range keys {
+		photos[i] = models.ReviewPhoto{Key: key, Position: i}
+	}
+	return photos
+}
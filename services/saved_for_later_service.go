@@ -0,0 +1,214 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCartItemNotFound     = errors.New("cart item not found")
+	ErrWishlistItemNotFound = errors.New("wishlist item not found")
+	ErrProductUnavailable   = errors.New("product is no longer sold")
+)
+
+// outcome of one wishlist entry when the whole wishlist is added to the cart
+const (
+	WishlistAdded             = "added"
+	WishlistInsufficientStock = "insufficient_stock" //fewer units left than saved, see available
+	WishlistOutOfStock        = "out_of_stock"
+	WishlistVariantRequired   = "variant_required" //saved without a variant, one has to be picked
+	WishlistUnavailable       = "unavailable"      //product or variant no longer sold
+)
+
+type WishlistCartResult struct {
+	WishlistID uint   `json:"wishlist_id"`
+	ProductID  uint   `json:"product_id"`
+	VariantID  *uint  `json:"variant_id,omitempty"`
+	Quantity   int    `json:"quantity"`
+	Available  int    `json:"available"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// wishlist entries shown as "saved for later" under the cart, latest first
+func SavedForLater(db *gorm.DB, userId uint) ([]models.Wishlist, error) {
+	saved := []models.Wishlist{}
+	if err := db.Preload("Product").Where("user_id=?", userId).Order("id DESC").Find(&saved).Error; err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// move a cart line to the wishlist in one go, the entry remembers the quantity and an entry of
+// the same product (variant) is reused
+func SaveForLater(db *gorm.DB, userId, cartItemId uint) (*models.Wishlist, error) {
+	owner := UserCart(userId)
+	var saved models.Wishlist
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var item models.CartItem
+		if err := owner.Scope(tx).Where("id=?", cartItemId).First(&item).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrCartItemNotFound
+			}
+			return err
+		}
+
+		variantId := VariantKey(item.VariantID)
+
+		err := tx.Where("user_id=? AND product_id=? AND variant_id=?", userId, item.ProductId, variantId).First(&saved).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err != nil {
+			saved = models.Wishlist{UserId: userId, ProductId: item.ProductId, VariantID: variantId}
+		}
+
+		saved.Quantity = item.Quantity
+		if err := tx.Omit("Product").Save(&saved).Error; err != nil {
+			return err
+		}
+
+		return RemoveCartLine(tx, owner, &item)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// move a wishlist entry into the cart in one go. variantId picks a variant for entries saved
+// without one, quantity 0 takes the saved quantity
+func MoveToCart(db *gorm.DB, userId, wishlistId uint, variantId *uint, quantity int) (*models.StockReservation, error) {
+	owner := UserCart(userId)
+	var reservation *models.StockReservation
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var saved models.Wishlist
+		if err := tx.Where("id=? AND user_id=?", wishlistId, userId).First(&saved).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrWishlistItemNotFound
+			}
+			return err
+		}
+
+		var product models.Product
+		if err := tx.First(&product, saved.ProductId).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrProductUnavailable
+			}
+			return err
+		}
+
+		if variantId == nil {
+			variantId = VariantRef(saved.VariantID)
+		}
+		variant, err := ResolveVariant(tx, &product, variantId)
+		if err != nil {
+			return err
+		}
+
+		if quantity <= 0 {
+			quantity = savedQuantity(saved)
+		}
+
+		reservation, err = AddCartLine(tx, owner, &product, variant, quantity)
+		if err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Delete(&saved).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
+
+// add every wishlist entry to the cart with its saved quantity. each entry is added on its own,
+// entries that can't be added are reported and nothing of them is held. the wishlist is kept
+func AddWishlistToCart(db *gorm.DB, userId uint) ([]WishlistCartResult, error) {
+	var entries []models.Wishlist
+	if err := db.Where("user_id=?", userId).Order("id ASC").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	owner := UserCart(userId)
+	results := make([]WishlistCartResult, 0, len(entries))
+
+	for _, saved := range entries {
+		result := WishlistCartResult{
+			WishlistID: saved.ID,
+			ProductID:  saved.ProductId,
+			VariantID:  VariantRef(saved.VariantID),
+			Quantity:   savedQuantity(saved),
+		}
+
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return addWishlistEntry(tx, owner, &result)
+		}); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func addWishlistEntry(tx *gorm.DB, owner CartOwner, result *WishlistCartResult) error {
+	var product models.Product
+	if err := tx.First(&product, result.ProductID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			result.Status = WishlistUnavailable
+			result.Error = ErrProductUnavailable.Error()
+			return nil
+		}
+		return err
+	}
+
+	variant, err := ResolveVariant(tx, &product, result.VariantID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrVariantRequired):
+			result.Status = WishlistVariantRequired
+		case errors.Is(err, ErrVariantNotFound):
+			result.Status = WishlistUnavailable
+		default:
+			return err
+		}
+		result.Error = err.Error()
+		return nil
+	}
+
+	result.Available, err = CartStockLeft(tx, owner, product.ID, result.VariantID)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case result.Available == 0:
+		result.Status = WishlistOutOfStock
+		result.Error = ErrInsufficientStock.Error()
+		return nil
+	case result.Available < result.Quantity:
+		result.Status = WishlistInsufficientStock
+		result.Error = ErrInsufficientStock.Error()
+		return nil
+	}
+
+	if _, err := AddCartLine(tx, owner, &product, variant, result.Quantity); err != nil {
+		return err
+	}
+	result.Status = WishlistAdded
+	result.Available -= result.Quantity
+	return nil
+}
+
+// at least one unit
+func savedQuantity(saved models.Wishlist) int {
+	if saved.Quantity < 1 {
+		return 1
+	}
+	return saved.Quantity
+}
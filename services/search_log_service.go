@@ -0,0 +1,65 @@
+package services
+
+import (
+	"strings"
+	"time"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+)
+
+type SearchQueryStat struct {
+	Query        string    `json:"query"`
+	Searches     int64     `json:"searches"`
+	AvgResults   float64   `json:"avg_results"`
+	LastSearched time.Time `json:"last_searched"`
+}
+
+type SearchReport struct {
+	Since              time.Time         `json:"since"`
+	TotalSearches      int64             `json:"total_searches"`
+	ZeroResultSearches int64             `json:"zero_result_searches"`
+	TopQueries         []SearchQueryStat `json:"top_queries"`
+	ZeroResultQueries  []SearchQueryStat `json:"zero_result_queries"` //searched for but nothing found, what the catalog is missing
+}
+
+// remember a search and how many products it found
+func LogSearchQuery(db *gorm.DB, query string, results int64) error {
+	query = strings.Join(strings.Fields(strings.ToLower(query)), " ")
+	if runes := []rune(query); len(runes) > 255 {
+		query = string(runes[:255])
+	}
+	return db.Create(&models.SearchQueryLog{Query: query, ResultCount: results}).Error
+}
+
+// most searched queries and queries that found nothing since a time, limit rows each
+func SearchQueryReport(db *gorm.DB, since time.Time, limit int) (*SearchReport, error) {
+	report := &SearchReport{Since: since, TopQueries: []SearchQueryStat{}, ZeroResultQueries: []SearchQueryStat{}}
+
+	logs := func() *gorm.DB {
+		return db.Model(&models.SearchQueryLog{}).Where("created_at >= ?", since)
+	}
+
+	if err := logs().Count(&report.TotalSearches).Error; err != nil {
+		return nil, err
+	}
+	if err := logs().Where("result_count = 0").Count(&report.ZeroResultSearches).Error; err != nil {
+		return nil, err
+	}
+
+	stats := "query, COUNT(*) AS searches, AVG(result_count) AS avg_results, MAX(created_at) AS last_searched"
+
+	if err := logs().Select(stats).Group("query").
+		Order("searches DESC, last_searched DESC").Limit(limit).
+		Scan(&report.TopQueries).Error; err != nil {
+		return nil, err
+	}
+
+	if err := logs().Where("result_count = 0").Select(stats).Group("query").
+		Order("searches DESC, last_searched DESC").Limit(limit).
+		Scan(&report.ZeroResultQueries).Error; err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
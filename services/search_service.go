@@ -0,0 +1,439 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+)
+
+// how close a misspelt word has to be to a product name / brand to count as a match (pg_trgm, 0..1),
+// set as pg_trgm.word_similarity_threshold / pg_trgm.similarity_threshold for each search
+const (
+	nameSimilarity  = 0.35
+	brandSimilarity = 0.45
+)
+
+// ts_headline options for the highlighted snippets
+const headlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxWords=30, MinWords=10, MaxFragments=2"
+
+// filters a shopper can combine when browsing or searching, prices are in the store currency
+type ProductFilters struct {
+	CategoryID *uint //the category and everything below it
+	Brands     []string
+	MinPrice   *models.Money
+	MaxPrice   *models.Money
+	OptionIDs  []uint
+	InStock    bool //only products (or one of their variants) with units left after holds
+}
+
+// what a shopper searched for and the filters they picked
+type SearchParams struct {
+	Query string
+	ProductFilters
+	Page  int
+	Limit int
+}
+
+type SearchHit struct {
+	Product      models.Product `json:"product"`
+	Rank         float64        `json:"rank"`
+	NameHeadline string         `json:"name_headline"` //name with the matched words in <mark>
+	Headline     string         `json:"headline"`      //description snippets around the matches
+}
+
+type FacetCount struct {
+	ID    uint   `json:"id,omitempty"`
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+type PriceBucket struct {
+	Min   models.Money `json:"min"`
+	Max   models.Money `json:"max"` //exclusive
+	Count int64        `json:"count"`
+}
+
+type OptionFacet struct {
+	FilterID   uint         `json:"filter_id"`
+	FilterName string       `json:"filter_name"`
+	Options    []FacetCount `json:"options"`
+}
+
+// counts for narrowing the search down, each facet ignores its own filter so the other
+// values stay visible after one is picked
+type SearchFacets struct {
+	Brands       []FacetCount  `json:"brands"`
+	Categories   []FacetCount  `json:"categories"`
+	PriceBuckets []PriceBucket `json:"price_buckets"`
+	Options      []OptionFacet `json:"options"`
+}
+
+type SearchResult struct {
+	Hits   []SearchHit  `json:"hits"`
+	Total  int64        `json:"total"`
+	Page   int          `json:"page"`
+	Limit  int          `json:"limit"`
+	Facets SearchFacets `json:"facets"`
+}
+
+// facet dimensions a filter belongs to
+const (
+	facetNone     = ""
+	facetBrand    = "brand"
+	facetCategory = "category"
+	facetPrice    = "price"
+	facetOption   = "option"
+)
+
+type productSearch struct {
+	db          *gorm.DB
+	query       string //empty = every product
+	params      ProductFilters
+	priceSQL    string
+	categoryIds []uint
+	optionSets  [][]uint //picked options grouped by filter, any of a group, all groups
+}
+
+// full text search (name, brand, category names, description) with trigram matching
+// for typos, ranked by relevance, with highlighted snippets and facet counts
+func SearchProducts(db *gorm.DB, params SearchParams) (*SearchResult, error) {
+	result := &SearchResult{Page: params.Page, Limit: params.Limit}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := setSimilarityThresholds(tx); err != nil {
+			return err
+		}
+
+		search := &productSearch{db: tx, query: params.Query, params: params.ProductFilters}
+
+		if err := search.prepare(); err != nil {
+			return err
+		}
+
+		if err := search.matches(facetNone).Count(&result.Total).Error; err != nil {
+			return err
+		}
+
+		hits, err := search.hits(params.Page, params.Limit)
+		if err != nil {
+			return err
+		}
+		result.Hits = hits
+
+		return search.facets(&result.Facets)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// the trigram operators (<% and %) compare against these settings instead of taking a
+// threshold, SET LOCAL keeps them to the search transaction so pooled connections stay untouched
+func setSimilarityThresholds(tx *gorm.DB) error {
+	if err := tx.Exec(fmt.Sprintf("SET LOCAL pg_trgm.word_similarity_threshold = %g", nameSimilarity)).Error; err != nil {
+		return err
+	}
+	return tx.Exec(fmt.Sprintf("SET LOCAL pg_trgm.similarity_threshold = %g", brandSimilarity)).Error
+}
+
+func (s *productSearch) prepare() error {
+	var err error
+
+	s.priceSQL, err = StorePriceSQL(s.db, "products")
+	if err != nil {
+		return err
+	}
+
+	if s.params.CategoryID != nil {
+		s.categoryIds, err = CategoryWithDescendants(s.db, *s.params.CategoryID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(s.params.OptionIDs) > 0 {
+		var options []models.FilterOption
+		if err := s.db.Where("id IN ?", s.params.OptionIDs).Order("filter_id, id").Find(&options).Error; err != nil {
+			return err
+		}
+
+		groups := make(map[uint][]uint)
+		var filterIds []uint
+		for _, option := range options {
+			if _, ok := groups[option.FilterID]; !ok {
+				filterIds = append(filterIds, option.FilterID)
+			}
+			groups[option.FilterID] = append(groups[option.FilterID], option.ID)
+		}
+		for _, filterId := range filterIds {
+			s.optionSets = append(s.optionSets, groups[filterId])
+		}
+
+		//unknown option ids can't match anything
+		if len(options) == 0 {
+			s.optionSets = [][]uint{{0}}
+		}
+	}
+
+	return nil
+}
+
+// products matching the text and every filter except the one of skip
+func (s *productSearch) matches(skip string) *gorm.DB {
+	q := s.db.Model(&models.Product{})
+
+	//operators rather than the similarity functions so the trigram indexes on name and brand are used
+	if s.query != "" {
+		q = q.Where(`(products.search_vector @@ websearch_to_tsquery('english', ?)
+			OR ? <% products.name
+			OR products.brand % ?)`,
+			s.query, s.query, s.query)
+	}
+
+	if skip != facetCategory && s.params.CategoryID != nil {
+		q = q.Where("products.category_id IN ?", s.categoryIds)
+	}
+
+	if skip != facetBrand && len(s.params.Brands) > 0 {
+		q = q.Where("LOWER(products.brand) IN ?", lowerAll(s.params.Brands))
+	}
+
+	if skip != facetPrice {
+		if s.params.MinPrice != nil {
+			q = q.Where(s.priceSQL+" >= ?", *s.params.MinPrice)
+		}
+		if s.params.MaxPrice != nil {
+			q = q.Where(s.priceSQL+" <= ?", *s.params.MaxPrice)
+		}
+	}
+
+	if skip != facetOption {
+		for _, set := range s.optionSets {
+			q = q.Where(`EXISTS (SELECT 1 FROM product_filter_options pfo
+				WHERE pfo.product_id = products.id AND pfo.filter_option_id IN ?)`, set)
+		}
+	}
+
+	if s.params.InStock {
+		q = q.Where(inStockSQL)
+	}
+
+	return q
+}
+
+// units on hand minus unexpired holds, for the product itself or any active variant
+const inStockSQL = `(products.stock_quantity - COALESCE((SELECT SUM(r.quantity) FROM stock_reservations r
+		WHERE r.product_id = products.id AND r.variant_id = 0 AND r.expires_at > NOW()), 0) > 0
+	OR EXISTS (SELECT 1 FROM product_variants v
+		WHERE v.product_id = products.id AND v.is_active AND v.deleted_at IS NULL
+		AND v.stock_quantity - COALESCE((SELECT SUM(r.quantity) FROM stock_reservations r
+			WHERE r.variant_id = v.id AND r.expires_at > NOW()), 0) > 0))`
+
+// one page of matches, best first, with the products loaded in that order
+func (s *productSearch) hits(page, limit int) ([]SearchHit, error) {
+	var rows []struct {
+		ID           uint
+		Rank         float64
+		NameHeadline string
+		Headline     string
+	}
+
+	query := s.query
+
+	err := s.matches(facetNone).
+		Select(`products.id,
+			ts_rank_cd(products.search_vector, websearch_to_tsquery('english', ?)) + word_similarity(?, products.name) AS rank,
+			ts_headline('english', products.name, websearch_to_tsquery('english', ?), 'HighlightAll=true, StartSel=<mark>, StopSel=</mark>') AS name_headline,
+			ts_headline('english', COALESCE(products.description, ''), websearch_to_tsquery('english', ?), ?) AS headline`,
+			query, query, query, query, headlineOptions).
+		Order("rank DESC, products.id DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return []SearchHit{}, nil
+	}
+
+	ids := make([]uint, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+
+	var products []models.Product
+	if err := s.db.Where("id IN ?", ids).Find(&products).Error; err != nil {
+		return nil, err
+	}
+
+	byId := make(map[uint]models.Product, len(products))
+	for _, product := range products {
+		byId[product.ID] = product
+	}
+
+	hits := make([]SearchHit, 0, len(rows))
+	for _, row := range rows {
+		product, ok := byId[row.ID]
+		if !ok {
+			continue
+		}
+		hits = append(hits, SearchHit{
+			Product:      product,
+			Rank:         math.Round(row.Rank*10000) / 10000,
+			NameHeadline: row.NameHeadline,
+			Headline:     row.Headline,
+		})
+	}
+	return hits, nil
+}
+
+func (s *productSearch) facets(facets *SearchFacets) error {
+	facets.Brands = []FacetCount{}
+	if err := s.matches(facetBrand).
+		Select("products.brand AS value, COUNT(*) AS count").
+		Group("products.brand").
+		Order("count DESC, value ASC").
+		Scan(&facets.Brands).Error; err != nil {
+		return err
+	}
+
+	facets.Categories = []FacetCount{}
+	if err := s.matches(facetCategory).
+		Joins("JOIN categories ON categories.id = products.category_id").
+		Select("categories.id AS id, categories.category_name AS value, COUNT(*) AS count").
+		Group("categories.id, categories.category_name").
+		Order("count DESC, value ASC").
+		Scan(&facets.Categories).Error; err != nil {
+		return err
+	}
+
+	buckets, err := s.priceBuckets()
+	if err != nil {
+		return err
+	}
+	facets.PriceBuckets = buckets
+
+	options, err := s.optionFacets()
+	if err != nil {
+		return err
+	}
+	facets.Options = options
+
+	return nil
+}
+
+// about five equal ranges with round edges (1, 2 or 5 times a power of ten) up to the highest price
+func (s *productSearch) priceBuckets() ([]PriceBucket, error) {
+	var highest struct{ Price *float64 }
+	if err := s.matches(facetPrice).Select("MAX(" + s.priceSQL + ") AS price").Scan(&highest).Error; err != nil {
+		return nil, err
+	}
+
+	buckets := []PriceBucket{}
+	if highest.Price == nil {
+		return buckets, nil
+	}
+
+	width := niceBucketWidth(models.Money(*highest.Price) / 5)
+
+	var rows []struct {
+		Bucket int64
+		Count  int64
+	}
+	if err := s.matches(facetPrice).
+		Where(s.priceSQL+" IS NOT NULL").
+		Select("FLOOR("+s.priceSQL+" / ?)::bigint AS bucket, COUNT(*) AS count", int64(width)).
+		Group("bucket").
+		Order("bucket ASC").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		buckets = append(buckets, PriceBucket{
+			Min:   width * models.Money(row.Bucket),
+			Max:   width * models.Money(row.Bucket+1),
+			Count: row.Count,
+		})
+	}
+	return buckets, nil
+}
+
+func niceBucketWidth(raw models.Money) models.Money {
+	if raw < 100 {
+		return 100 //one unit of the currency
+	}
+
+	step := models.Money(1)
+	for step*10 <= raw {
+		step *= 10
+	}
+	for _, factor := range []models.Money{1, 2, 5, 10} {
+		if step*factor >= raw {
+			return step * factor
+		}
+	}
+	return step * 10
+}
+
+func (s *productSearch) optionFacets() ([]OptionFacet, error) {
+	var rows []struct {
+		FilterID   uint
+		FilterName string
+		OptionID   uint
+		Label      string
+		Count      int64
+	}
+
+	if err := s.matches(facetOption).
+		Joins("JOIN product_filter_options pfo ON pfo.product_id = products.id").
+		Joins("JOIN filter_options fo ON fo.id = pfo.filter_option_id").
+		Joins("JOIN filters f ON f.id = fo.filter_id").
+		Select("f.id AS filter_id, f.filter_name, fo.id AS option_id, fo.label, COUNT(DISTINCT products.id) AS count").
+		Group("f.id, f.filter_name, fo.id, fo.label").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	facets := []OptionFacet{}
+	index := make(map[uint]int)
+
+	for _, row := range rows {
+		i, ok := index[row.FilterID]
+		if !ok {
+			i = len(facets)
+			index[row.FilterID] = i
+			facets = append(facets, OptionFacet{FilterID: row.FilterID, FilterName: row.FilterName})
+		}
+		facets[i].Options = append(facets[i].Options, FacetCount{ID: row.OptionID, Value: row.Label, Count: row.Count})
+	}
+
+	sort.Slice(facets, func(a, b int) bool { return facets[a].FilterName < facets[b].FilterName })
+	for i := range facets {
+		options := facets[i].Options
+		sort.Slice(options, func(a, b int) bool {
+			if options[a].Count != options[b].Count {
+				return options[a].Count > options[b].Count
+			}
+			return options[a].Value < options[b].Value
+		})
+	}
+
+	return facets, nil
+}
+
+func lowerAll(values []string) []string {
+	lowered := make([]string, len(values))
+	for i, value := range values {
+		lowered[i] = strings.ToLower(strings.TrimSpace(value))
+	}
+	return lowered
+}
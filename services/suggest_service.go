@@ -0,0 +1,227 @@
+package services
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+)
+
+const (
+	SuggestionProduct  = "product"
+	SuggestionBrand    = "brand"
+	SuggestionCategory = "category"
+)
+
+type Suggestion struct {
+	Type     string `json:"type"`
+	ID       uint   `json:"id,omitempty"` //product or category id, brands have none
+	Text     string `json:"text"`
+	sold     int64  //units sold of the product, brand or category (with subcategories)
+	products int    //products of a brand or category, breaks ties
+}
+
+type SuggestResult struct {
+	Products   []Suggestion `json:"products"`
+	Brands     []Suggestion `json:"brands"`
+	Categories []Suggestion `json:"categories"`
+}
+
+// a lower case name, or its tail from one of its words on, pointing at an entry
+type suggestKey struct {
+	text  string
+	entry int
+	start bool //the whole name, not a later word
+}
+
+// names held in memory so autocomplete never touches the database, keys are sorted for prefix lookups
+type suggestIndex struct {
+	mu      sync.RWMutex
+	entries []Suggestion
+	keys    []suggestKey
+}
+
+var (
+	suggestions    = &suggestIndex{}
+	suggestRefresh = make(chan struct{}, 1)
+)
+
+// build the index now, then again after product / category changes and every interval (sales move the ranking)
+func StartSuggestIndex(db *gorm.DB, interval time.Duration) {
+	if err := rebuildSuggestIndex(db); err != nil {
+		log.Println("suggest index:", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-suggestRefresh:
+			}
+			if err := rebuildSuggestIndex(db); err != nil {
+				log.Println("suggest index:", err)
+			}
+		}
+	}()
+}
+
+// ask for a rebuild without waiting for it, several calls in a row rebuild once
+func RefreshSuggestIndex() {
+	select {
+	case suggestRefresh <- struct{}{}:
+	default:
+	}
+}
+
+func rebuildSuggestIndex(db *gorm.DB) error {
+	var products []struct {
+		ID         uint
+		Name       string
+		Brand      string
+		CategoryID *uint
+		SoldCount  int64
+	}
+	if err := db.Model(&models.Product{}).Select("id, name, brand, category_id, sold_count").Scan(&products).Error; err != nil {
+		return err
+	}
+
+	var categories []models.Category
+	if err := db.Find(&categories).Error; err != nil {
+		return err
+	}
+
+	entries := make([]Suggestion, 0, len(products)+len(categories))
+
+	brands := make(map[string]int)
+	categorySold := make(map[uint]int64)
+	categoryProducts := make(map[uint]int)
+
+	for _, product := range products {
+		entries = append(entries, Suggestion{Type: SuggestionProduct, ID: product.ID, Text: product.Name, sold: product.SoldCount})
+
+		if brand := strings.TrimSpace(product.Brand); brand != "" {
+			//brands differing only in case are one brand
+			i, ok := brands[strings.ToLower(brand)]
+			if !ok {
+				i = len(entries)
+				brands[strings.ToLower(brand)] = i
+				entries = append(entries, Suggestion{Type: SuggestionBrand, Text: brand})
+			}
+			entries[i].sold += product.SoldCount
+			entries[i].products++
+		}
+
+		if product.CategoryID != nil {
+			categorySold[*product.CategoryID] += product.SoldCount
+			categoryProducts[*product.CategoryID]++
+		}
+	}
+
+	//a category counts the sales of everything below it
+	parents := make(map[uint]*uint, len(categories))
+	for _, category := range categories {
+		parents[category.ID] = category.ParentID
+	}
+	totalSold := make(map[uint]int64, len(categories))
+	totalProducts := make(map[uint]int, len(categories))
+	for _, category := range categories {
+		seen := make(map[uint]bool)
+		for id := &category.ID; id != nil && !seen[*id]; id = parents[*id] {
+			seen[*id] = true
+			totalSold[*id] += categorySold[category.ID]
+			totalProducts[*id] += categoryProducts[category.ID]
+		}
+	}
+	for _, category := range categories {
+		entries = append(entries, Suggestion{
+			Type:     SuggestionCategory,
+			ID:       category.ID,
+			Text:     category.CategoryName,
+			sold:     totalSold[category.ID],
+			products: totalProducts[category.ID],
+		})
+	}
+
+	suggestions.load(entries)
+	return nil
+}
+
+// swap in new entries with their lookup keys
+func (index *suggestIndex) load(entries []Suggestion) {
+	var keys []suggestKey
+	for i, entry := range entries {
+		words := strings.Fields(strings.ToLower(entry.Text))
+		for w := range words {
+			keys = append(keys, suggestKey{text: strings.Join(words[w:], " "), entry: i, start: w == 0})
+		}
+	}
+	sort.Slice(keys, func(a, b int) bool { return keys[a].text < keys[b].text })
+
+	index.mu.Lock()
+	index.entries, index.keys = entries, keys
+	index.mu.Unlock()
+}
+
+// names starting with query, or with a word starting with it, most sold first, at most limit of each type
+func Suggest(query string, limit int) SuggestResult {
+	result := SuggestResult{Products: []Suggestion{}, Brands: []Suggestion{}, Categories: []Suggestion{}}
+
+	prefix := strings.Join(strings.Fields(strings.ToLower(query)), " ")
+	if prefix == "" {
+		return result
+	}
+
+	suggestions.mu.RLock()
+	defer suggestions.mu.RUnlock()
+
+	keys := suggestions.keys
+	matched := make(map[int]bool) //entry -> matched at the start of its name
+
+	for i := sort.Search(len(keys), func(i int) bool { return keys[i].text >= prefix }); i < len(keys); i++ {
+		if !strings.HasPrefix(keys[i].text, prefix) {
+			break
+		}
+		matched[keys[i].entry] = matched[keys[i].entry] || keys[i].start
+	}
+
+	hits := make([]int, 0, len(matched))
+	for entry := range matched {
+		hits = append(hits, entry)
+	}
+
+	entries := suggestions.entries
+	sort.Slice(hits, func(a, b int) bool {
+		x, y := entries[hits[a]], entries[hits[b]]
+		switch {
+		case x.sold != y.sold:
+			return x.sold > y.sold
+		case x.products != y.products:
+			return x.products > y.products
+		case matched[hits[a]] != matched[hits[b]]:
+			return matched[hits[a]]
+		case len(x.Text) != len(y.Text):
+			return len(x.Text) < len(y.Text)
+		}
+		return x.Text < y.Text
+	})
+
+	for _, i := range hits {
+		entry := entries[i]
+		switch {
+		case entry.Type == SuggestionProduct && len(result.Products) < limit:
+			result.Products = append(result.Products, entry)
+		case entry.Type == SuggestionBrand && len(result.Brands) < limit:
+			result.Brands = append(result.Brands, entry)
+		case entry.Type == SuggestionCategory && len(result.Categories) < limit:
+			result.Categories = append(result.Categories, entry)
+		}
+	}
+	return result
+}
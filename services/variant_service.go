@@ -0,0 +1,49 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/junaid9001/spectr_backend/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrVariantRequired = errors.New("product has variants, choose one")
+	ErrVariantNotFound = errors.New("variant not found for this product")
+)
+
+// the variant a shopper picked, nil for products without variants
+func ResolveVariant(db *gorm.DB, product *models.Product, variantId *uint) (*models.ProductVariant, error) {
+	if variantId == nil {
+		var count int64
+		if err := db.Model(&models.ProductVariant{}).
+			Where("product_id=? AND is_active = ?", product.ID, true).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, ErrVariantRequired
+		}
+		return nil, nil
+	}
+
+	var variant models.ProductVariant
+
+	if err := db.Where("id=? AND product_id=? AND is_active = ?", *variantId, product.ID, true).
+		First(&variant).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrVariantNotFound
+		}
+		return nil, err
+	}
+	return &variant, nil
+}
+
+// rows of one product variant, nil = rows without a variant
+func WhereVariant(variantId *uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if variantId == nil {
+			return db.Where("variant_id IS NULL")
+		}
+		return db.Where("variant_id=?", *variantId)
+	}
+}
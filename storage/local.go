@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// files on the local disk, served by the app itself
+type Local struct {
+	dir        string //STORAGE_LOCAL_DIR, default uploads
+	baseURL    string //where dir is served, /uploads
+	signedURL  string //route that serves signed links, /files
+	signingKey []byte
+}
+
+func NewLocal(dir, baseURL, signedURL string, signingKey []byte) *Local {
+	return &Local{
+		dir:        dir,
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		signedURL:  strings.TrimSuffix(signedURL, "/"),
+		signingKey: signingKey,
+	}
+}
+
+// STORAGE_LOCAL_DIR (default uploads) served under /uploads, signed links under /files
+// signed with STORAGE_SIGNING_KEY, falling back to JWT_SECRETKEY
+func NewLocalFromEnv() *Local {
+	dir := os.Getenv("STORAGE_LOCAL_DIR")
+	if dir == "" {
+		dir = "uploads"
+	}
+
+	key := os.Getenv("STORAGE_SIGNING_KEY")
+	if key == "" {
+		key = os.Getenv("JWT_SECRETKEY")
+	}
+
+	return NewLocal(dir, "/uploads", "/files", []byte(key))
+}
+
+func (l *Local) Name() string { return "local" }
+
+// directory the files are kept in, main serves it as static files
+func (l *Local) Dir() string { return l.dir }
+
+func (l *Local) path(key string) (string, error) {
+	clean, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(clean)), nil
+}
+
+// written to a temp file first so readers never see half a file
+func (l *Local) Put(key string, r io.Reader, contentType string) error {
+	target, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), target)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (l *Local) Get(key string) (io.ReadCloser, error) {
+	target, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (l *Local) Delete(key string) error {
+	target, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) URL(key string) string {
+	clean, err := cleanKey(key)
+	if err != nil {
+		return ""
+	}
+	return l.baseURL + "/" + escapePath(clean)
+}
+
+// /files/<key>?expires=<unix>&signature=<hmac>, checked by VerifySignature
+func (l *Local) SignedURL(key string, expires time.Duration) (string, error) {
+	clean, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	if len(l.signingKey) == 0 {
+		return "", errors.New("no signing key for local storage")
+	}
+
+	expiresAt := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+
+	query := url.Values{}
+	query.Set("expires", expiresAt)
+	query.Set("signature", l.signature(clean, expiresAt))
+
+	return l.signedURL + "/" + escapePath(clean) + "?" + query.Encode(), nil
+}
+
+// true when signature was made by SignedURL for key and hasn't expired
+func (l *Local) VerifySignature(key, expires, signature string) bool {
+	clean, err := cleanKey(key)
+	if err != nil || len(l.signingKey) == 0 {
+		return false
+	}
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(l.signature(clean, expires)))
+}
+
+func (l *Local) signature(key, expires string) string {
+	mac := hmac.New(sha256.New, l.signingKey)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// escape every segment of a key but keep the slashes
+func escapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCleanKey(t *testing.T) {
+	for _, tc := range []struct {
+		key  string
+		want string
+		err  error
+	}{
+		{"products/a.jpg", "products/a.jpg", nil},
+		{"/products/a.jpg", "products/a.jpg", nil},
+		{"products//a.jpg", "products/a.jpg", nil},
+		{"products/./a.jpg", "products/a.jpg", nil},
+		{"", "", ErrInvalidKey},
+		{"/", "", ErrInvalidKey},
+		{"..", "", ErrInvalidKey},
+		{"../a.jpg", "", ErrInvalidKey},
+		{"products/../a.jpg", "", ErrInvalidKey},
+		{"/../../etc/passwd", "", ErrInvalidKey},
+		{"products\\a.jpg", "", ErrInvalidKey},
+	} {
+		got, err := cleanKey(tc.key)
+		if got != tc.want || !errors.Is(err, tc.err) {
+			t.Errorf("cleanKey(%q) = %q, %v, want %q, %v", tc.key, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestLocalPutGetDelete(t *testing.T) {
+	dir := t.TempDir()
+	local := NewLocal(dir, "/uploads", "/files", []byte("secret"))
+
+	if err := local.Put("products/a.jpg", strings.NewReader("image bytes"), "image/jpeg"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	file, err := local.Get("products/a.jpg")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	content, err := io.ReadAll(file)
+	file.Close()
+	if err != nil || string(content) != "image bytes" {
+		t.Fatalf("Get returned %q, %v", content, err)
+	}
+
+	if err := local.Delete("products/a.jpg"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := local.Get("products/a.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get after Delete = %v, want ErrNotFound", err)
+	}
+	if err := local.Delete("products/a.jpg"); err != nil {
+		t.Fatalf("second Delete: %v", err)
+	}
+}
+
+func TestLocalRejectsKeysOutsideDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "uploads")
+	local := NewLocal(dir, "/uploads", "/files", []byte("secret"))
+
+	for _, key := range []string{"../escaped.txt", "products/../../escaped.txt", "..\\escaped.txt"} {
+		if err := local.Put(key, strings.NewReader("x"), ""); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		if _, err := local.Get(key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		if err := local.Delete(key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete(%q) = %v, want ErrInvalidKey", key, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "escaped.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("a file was written outside the storage dir: %v", err)
+	}
+}
+
+func TestLocalVerifySignature(t *testing.T) {
+	local := NewLocal(t.TempDir(), "/uploads", "/files", []byte("secret"))
+
+	raw, err := local.SignedURL("invoices/order 42.pdf", time.Minute)
+	if err != nil {
+		t.Fatalf("SignedURL: %v", err)
+	}
+
+	signed, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("signed url doesn't parse: %v", err)
+	}
+	key, ok := strings.CutPrefix(signed.Path, "/files/")
+	if !ok || key != "invoices/order 42.pdf" {
+		t.Fatalf("signed url %q doesn't point at the key", raw)
+	}
+
+	expires := signed.Query().Get("expires")
+	signature := signed.Query().Get("signature")
+
+	if !local.VerifySignature(key, expires, signature) {
+		t.Fatal("valid signature rejected")
+	}
+	if !local.VerifySignature("/"+key, expires, signature) {
+		t.Error("signature rejected for the same key with a leading slash")
+	}
+
+	later := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+
+	for name, tc := range map[string]struct{ key, expires, signature string }{
+		"other key":        {"invoices/order 43.pdf", expires, signature},
+		"extended expiry":  {key, later, signature},
+		"tampered":         {key, expires, strings.Repeat("0", len(signature))},
+		"empty signature":  {key, expires, ""},
+		"bad expiry":       {key, "soon", signature},
+		"traversal":        {"invoices/../" + key, expires, signature},
+		"expired (resign)": {key, past, local.signature(key, past)},
+	} {
+		if local.VerifySignature(tc.key, tc.expires, tc.signature) {
+			t.Errorf("%s: signature accepted", name)
+		}
+	}
+
+	other := NewLocal(t.TempDir(), "/uploads", "/files", []byte("other secret"))
+	if other.VerifySignature(key, expires, signature) {
+		t.Error("signature accepted with a different signing key")
+	}
+
+	unsigned := NewLocal(t.TempDir(), "/uploads", "/files", nil)
+	if _, err := unsigned.SignedURL(key, time.Minute); err == nil {
+		t.Error("SignedURL without a signing key succeeded")
+	}
+	if unsigned.VerifySignature(key, expires, unsigned.signature(key, expires)) {
+		t.Error("signature accepted without a signing key")
+	}
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("file not found")
+	ErrInvalidKey = errors.New("invalid file key")
+)
+
+// where uploaded files live, files are addressed by a key like "products/1700000000_ab12cd.jpg"
+type Storage interface {
+	Name() string
+	// store the content of r under key, an existing file is replaced
+	Put(key string, r io.Reader, contentType string) error
+	// read a file, ErrNotFound when it doesn't exist
+	Get(key string) (io.ReadCloser, error)
+	// remove a file, a missing file is not an error
+	Delete(key string) error
+	// public address of a file
+	URL(key string) string
+	// address of a file that stops working after expires
+	SignedURL(key string, expires time.Duration) (string, error)
+}
+
+var (
+	defaultMu      sync.RWMutex
+	defaultStorage Storage
+)
+
+// build the storage picked by STORAGE_DRIVER (local or s3, default local), called once on start-up
+func Init() error {
+	var (
+		store Storage
+		err   error
+	)
+
+	switch driver := strings.ToLower(strings.TrimSpace(os.Getenv("STORAGE_DRIVER"))); driver {
+	case "", "local":
+		store = NewLocalFromEnv()
+	case "s3":
+		store, err = NewS3FromEnv()
+	default:
+		err = fmt.Errorf("storage driver %q is not supported", driver)
+	}
+	if err != nil {
+		return err
+	}
+
+	defaultMu.Lock()
+	defaultStorage = store
+	defaultMu.Unlock()
+	return nil
+}
+
+// storage set up by Init, local disk when Init wasn't called
+func Default() Storage {
+	defaultMu.RLock()
+	store := defaultStorage
+	defaultMu.RUnlock()
+
+	if store != nil {
+		return store
+	}
+
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultStorage == nil {
+		defaultStorage = NewLocalFromEnv()
+	}
+	return defaultStorage
+}
+
+// public address of key in the default storage, empty key = empty url
+func URL(key string) string {
+	if key == "" {
+		return ""
+	}
+	return Default().URL(key)
+}
+
+// short lived address of key in the default storage, empty when it can't be signed
+func SignedURL(key string, expires time.Duration) string {
+	if key == "" {
+		return ""
+	}
+	url, err := Default().SignedURL(key, expires)
+	if err != nil {
+		return ""
+	}
+	return url
+}
+
+// new unique key inside dir, upload names never become keys
+func NewKey(dir, ext string) (string, error) {
+	random := make([]byte, 6)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%d_%s%s", dir, time.Now().Unix(), hex.EncodeToString(random), ext), nil
+}
+
+// key without leading slash or dot segments, ErrInvalidKey if it points outside the storage
+func cleanKey(key string) (string, error) {
+	if key == "" || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	for _, segment := range strings.Split(strings.TrimPrefix(key, "/"), "/") {
+		if segment == ".." {
+			return "", ErrInvalidKey
+		}
+	}
+
+	clean := strings.TrimPrefix(path.Clean("/"+key), "/")
+	if clean == "" || clean == "." {
+		return "", ErrInvalidKey
+	}
+	return clean, nil
+}